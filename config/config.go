@@ -0,0 +1,450 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const minSessionKeyLength = 32
+
+// Config is the complete configuration of the store. Every field can be set in
+// the configuration file, from an environment variable and with a command-line
+// flag, named by the yaml, env and flag tags. Fields tagged secret are masked
+// when the configuration is printed, see Masked.
+type Config struct {
+	Server     Server     `yaml:"server"`
+	Database   Database   `yaml:"database"`
+	OAuth      OAuth      `yaml:"oauth"`
+	Sessions   Sessions   `yaml:"sessions"`
+	Accounts   Accounts   `yaml:"accounts"`
+	Mail       Mail       `yaml:"mail"`
+	RateLimits RateLimits `yaml:"rateLimits"`
+	Pagination Pagination `yaml:"pagination"`
+	Logging    Logging    `yaml:"logging"`
+	Tracing    Tracing    `yaml:"tracing"`
+	Metrics    Metrics    `yaml:"metrics"`
+	GRPC       GRPC       `yaml:"grpc"`
+}
+
+type Server struct {
+	Port           int           `yaml:"port" env:"PORT" flag:"port" usage:"HTTP port"`
+	Host           string        `yaml:"host" env:"HOST" flag:"host" usage:"public URL of the store, used for OAuth redirects"`
+	RequestTimeout time.Duration `yaml:"requestTimeout" env:"REQUEST_TIMEOUT" flag:"request-timeout" usage:"maximum duration of a request"`
+	SessionKey     string        `yaml:"sessionKey" env:"SESSION_STORE_KEY" flag:"session-key" secret:"true" usage:"key authenticating the session cookies, at least 32 bytes"`
+	Admins         []int64       `yaml:"admins" env:"ADMIN_USER_IDS" flag:"admins" usage:"comma separated ids of the users who are administrators"`
+
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"READ_TIMEOUT" flag:"read-timeout" usage:"maximum duration of reading a request, including its body"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT" flag:"read-header-timeout" usage:"maximum duration of reading the request headers"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"WRITE_TIMEOUT" flag:"write-timeout" usage:"maximum duration of writing a response, longer than the request timeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"IDLE_TIMEOUT" flag:"idle-timeout" usage:"how long idle keep-alive connections are kept open"`
+	MaxHeaderBytes    int           `yaml:"maxHeaderBytes" env:"MAX_HEADER_BYTES" flag:"max-header-bytes" usage:"maximum size of the request headers"`
+	ShutdownDelay     time.Duration `yaml:"shutdownDelay" env:"SHUTDOWN_DELAY" flag:"shutdown-delay" usage:"how long the server keeps serving with a failing readiness probe before it stops accepting requests"`
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"deadline for draining in-flight requests on shutdown"`
+}
+
+type Database struct {
+	Driver           string `yaml:"driver" env:"DB_DRIVER_NAME" flag:"db-driver" usage:"database driver, postgres or sqlite"`
+	ConnectionString string `yaml:"connectionString" env:"DB_CONNECTION_STRING" flag:"db-connection-string" secret:"true" usage:"database connection string"`
+
+	MaxOpenConns    int           `yaml:"maxOpenConns" env:"DB_MAX_OPEN_CONNS" flag:"db-max-open-conns" usage:"maximum open connections, 0 for unlimited"`
+	MaxIdleConns    int           `yaml:"maxIdleConns" env:"DB_MAX_IDLE_CONNS" flag:"db-max-idle-conns" usage:"maximum idle connections kept in the pool"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime" env:"DB_CONN_MAX_LIFETIME" flag:"db-conn-max-lifetime" usage:"maximum age of a connection, 0 for unlimited"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime" env:"DB_CONN_MAX_IDLE_TIME" flag:"db-conn-max-idle-time" usage:"how long a connection may stay idle, 0 for unlimited"`
+	ConnectTimeout  time.Duration `yaml:"connectTimeout" env:"DB_CONNECT_TIMEOUT" flag:"db-connect-timeout" usage:"how long to retry connecting on startup"`
+	HealthCacheTTL  time.Duration `yaml:"healthCacheTtl" env:"DB_HEALTH_CACHE_TTL" flag:"db-health-cache-ttl" usage:"how long a readiness check result is reused"`
+
+	Replicas             []string      `yaml:"replicas" env:"DB_REPLICA_CONNECTION_STRINGS" flag:"db-replicas" secret:"true" usage:"comma separated connection strings of read replicas serving the catalogue"`
+	ReplicaCheckInterval time.Duration `yaml:"replicaCheckInterval" env:"DB_REPLICA_CHECK_INTERVAL" flag:"db-replica-check-interval" usage:"how often the replicas are health checked"`
+	ReadYourWritesWindow time.Duration `yaml:"readYourWritesWindow" env:"DB_READ_YOUR_WRITES_WINDOW" flag:"db-read-your-writes-window" usage:"how long a client reads from the primary after a write"`
+}
+
+// OAuth configures the identity providers. The provider configured by the
+// flat fields is named google, as it defaults to Google, and is enabled when
+// its client ID is set. Further providers can only be configured in the file.
+type OAuth struct {
+	ClientID     string     `yaml:"clientId" env:"CLIENT_ID" flag:"oauth-client-id" usage:"OAuth client ID"`
+	ClientSecret string     `yaml:"clientSecret" env:"CLIENT_SECRET" flag:"oauth-client-secret" secret:"true" usage:"OAuth client secret"`
+	Issuer       string     `yaml:"issuer" env:"OAUTH_ISSUER" flag:"oauth-issuer" usage:"OpenID Connect issuer whose endpoints are discovered, empty for plain OAuth2"`
+	AuthURL      string     `yaml:"authUrl" env:"OAUTH_AUTH_URL" flag:"oauth-auth-url" usage:"OAuth authorization endpoint, overrides the discovered one"`
+	TokenURL     string     `yaml:"tokenUrl" env:"OAUTH_TOKEN_URL" flag:"oauth-token-url" usage:"OAuth token endpoint, overrides the discovered one"`
+	UserInfoURL  string     `yaml:"userInfoUrl" env:"OAUTH_USERINFO_URL" flag:"oauth-userinfo-url" usage:"user info endpoint, overrides the discovered one"`
+	Scopes       []string   `yaml:"scopes" env:"OAUTH_SCOPES" flag:"oauth-scopes" usage:"comma separated OAuth scopes"`
+	Providers    []Provider `yaml:"providers"`
+}
+
+// Provider is an identity provider, see security.ProviderConfig.
+type Provider struct {
+	Name         string         `yaml:"name"`
+	DisplayName  string         `yaml:"displayName"`
+	Issuer       string         `yaml:"issuer"`
+	ClientID     string         `yaml:"clientId"`
+	ClientSecret string         `yaml:"clientSecret" secret:"true"`
+	AuthURL      string         `yaml:"authUrl"`
+	TokenURL     string         `yaml:"tokenUrl"`
+	UserInfoURL  string         `yaml:"userInfoUrl"`
+	Scopes       []string       `yaml:"scopes"`
+	TrustEmail   bool           `yaml:"trustEmail"`
+	Claims       ProviderClaims `yaml:"claims"`
+}
+
+// ProviderClaims names the claims of a provider that differ from the OpenID
+// Connect ones, e.g. "id" as the subject of GitHub.
+type ProviderClaims struct {
+	Subject       string `yaml:"subject"`
+	Email         string `yaml:"email"`
+	EmailVerified string `yaml:"emailVerified"`
+	Name          string `yaml:"name"`
+	FirstName     string `yaml:"firstName"`
+	LastName      string `yaml:"lastName"`
+	Picture       string `yaml:"picture"`
+}
+
+type Sessions struct {
+	Store        string        `yaml:"store" env:"SESSION_STORE" flag:"session-store" usage:"where sessions are kept, database or memory"`
+	PreviousKeys []string      `yaml:"previousKeys" env:"SESSION_STORE_PREVIOUS_KEYS" flag:"session-previous-keys" secret:"true" usage:"comma separated previous session keys, accepted until their cookies are signed with the current key"`
+	MaxAge       time.Duration `yaml:"maxAge" env:"SESSION_MAX_AGE" flag:"session-max-age" usage:"lifetime of a session"`
+	IdleTimeout  time.Duration `yaml:"idleTimeout" env:"SESSION_IDLE_TIMEOUT" flag:"session-idle-timeout" usage:"how long an unused session stays valid"`
+	SecureCookie bool          `yaml:"secureCookie" env:"SESSION_SECURE_COOKIE" flag:"session-secure-cookie" usage:"send the session cookie over HTTPS only, browsers make an exception for localhost"`
+}
+
+// Accounts configures local accounts, which log in with an email and a
+// password, and the deletion of all accounts.
+type Accounts struct {
+	MaxFailedLogins int           `yaml:"maxFailedLogins" env:"ACCOUNT_MAX_FAILED_LOGINS" flag:"account-max-failed-logins" usage:"failed logins in a row after which an account is locked"`
+	LockoutDuration time.Duration `yaml:"lockoutDuration" env:"ACCOUNT_LOCKOUT_DURATION" flag:"account-lockout-duration" usage:"how long an account stays locked"`
+	VerificationTTL time.Duration `yaml:"verificationTtl" env:"ACCOUNT_VERIFICATION_TTL" flag:"account-verification-ttl" usage:"how long an email verification link is valid"`
+	ResetTTL        time.Duration `yaml:"resetTtl" env:"ACCOUNT_RESET_TTL" flag:"account-reset-ttl" usage:"how long a password reset link is valid"`
+
+	DeletionGracePeriod time.Duration `yaml:"deletionGracePeriod" env:"ACCOUNT_DELETION_GRACE_PERIOD" flag:"account-deletion-grace-period" usage:"how long users can cancel the deletion of their account"`
+}
+
+// Mail configures the SMTP server sending the emails of local accounts. The
+// emails are logged instead if no server is set.
+type Mail struct {
+	SMTPAddr string `yaml:"smtpAddr" env:"SMTP_ADDR" flag:"smtp-addr" usage:"host:port of the SMTP server, empty to log emails"`
+	From     string `yaml:"from" env:"MAIL_FROM" flag:"mail-from" usage:"sender of the emails"`
+	Username string `yaml:"username" env:"SMTP_USERNAME" flag:"smtp-username" usage:"SMTP username, empty to send without authentication"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD" flag:"smtp-password" secret:"true" usage:"SMTP password"`
+}
+
+// RateLimits are requests per minute, per user or IP address. Zero disables a
+// limit.
+type RateLimits struct {
+	Store  string `yaml:"store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" usage:"where requests are counted, memory for a single replica or database"`
+	API    int    `yaml:"api" env:"RATE_LIMIT_API" flag:"rate-limit-api" usage:"API requests per minute of a user or IP address"`
+	Search int    `yaml:"search" env:"RATE_LIMIT_SEARCH" flag:"rate-limit-search" usage:"product searches per minute of a user or IP address"`
+	Write  int    `yaml:"write" env:"RATE_LIMIT_WRITE" flag:"rate-limit-write" usage:"API requests that change something per minute of a user or IP address"`
+	Login  int    `yaml:"login" env:"RATE_LIMIT_LOGIN" flag:"rate-limit-login" usage:"login attempts per minute of an IP address"`
+}
+
+type Pagination struct {
+	MinPageSize int `yaml:"minPageSize" env:"PAGE_SIZE_MIN" flag:"page-size-min" usage:"smallest page of products returned"`
+	MaxPageSize int `yaml:"maxPageSize" env:"PAGE_SIZE_MAX" flag:"page-size-max" usage:"largest page of products returned"`
+}
+
+type Logging struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" usage:"text or json"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" flag:"trace-exporter" usage:"none, stdout or otlp"`
+}
+
+type Metrics struct {
+	Port  int    `yaml:"port" env:"METRICS_PORT" flag:"metrics-port" usage:"port of a separate metrics listener, 0 to serve them on the main port"`
+	Token string `yaml:"token" env:"METRICS_TOKEN" flag:"metrics-token" secret:"true" usage:"bearer token required to read the metrics"`
+}
+
+type GRPC struct {
+	Port          int      `yaml:"port" env:"GRPC_PORT" flag:"grpc-port" usage:"gRPC port"`
+	ServiceTokens []string `yaml:"serviceTokens" env:"GRPC_SERVICE_TOKENS" flag:"grpc-service-tokens" secret:"token" usage:"comma separated service:token pairs, gRPC is disabled if empty"`
+}
+
+// Default returns the configuration used for every value that is not set
+// explicitly.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:              8080,
+			RequestTimeout:    30 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      35 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownDelay:     5 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Database: Database{
+			Driver:          "postgres",
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			ConnectTimeout:  time.Minute,
+			HealthCacheTTL:  2 * time.Second,
+
+			ReplicaCheckInterval: 5 * time.Second,
+			ReadYourWritesWindow: 10 * time.Second,
+		},
+		OAuth: OAuth{
+			Issuer: "https://accounts.google.com",
+			Scopes: []string{"openid", "profile", "email"},
+		},
+		Sessions: Sessions{
+			Store:        "database",
+			MaxAge:       24 * time.Hour,
+			IdleTimeout:  2 * time.Hour,
+			SecureCookie: true,
+		},
+		Accounts: Accounts{
+			MaxFailedLogins: 5,
+			LockoutDuration: 15 * time.Minute,
+			VerificationTTL: 24 * time.Hour,
+			ResetTTL:        time.Hour,
+
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+		},
+		RateLimits: RateLimits{
+			Store:  "memory",
+			API:    600,
+			Search: 60,
+			Write:  60,
+			Login:  10,
+		},
+		Pagination: Pagination{
+			MinPageSize: 40,
+			MaxPageSize: 80,
+		},
+		Logging: Logging{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+		},
+		GRPC: GRPC{
+			Port: 9090,
+		},
+	}
+}
+
+// GRPCServiceTokens maps every configured gRPC token to the name of its service.
+func (c *Config) GRPCServiceTokens() map[string]string {
+	serviceTokens := map[string]string{}
+	for _, pair := range c.GRPC.ServiceTokens {
+		service, token, _ := strings.Cut(pair, ":")
+		serviceTokens[token] = service
+	}
+	return serviceTokens
+}
+
+// IdentityProviders returns the configured identity providers, starting with
+// the google one if it is enabled.
+func (c *Config) IdentityProviders() []Provider {
+	var providers []Provider
+	if c.OAuth.ClientID != "" {
+		providers = append(providers, Provider{
+			Name:         "google",
+			DisplayName:  "Google",
+			Issuer:       c.OAuth.Issuer,
+			ClientID:     c.OAuth.ClientID,
+			ClientSecret: c.OAuth.ClientSecret,
+			AuthURL:      c.OAuth.AuthURL,
+			TokenURL:     c.OAuth.TokenURL,
+			UserInfoURL:  c.OAuth.UserInfoURL,
+			Scopes:       c.OAuth.Scopes,
+			// Users created before identities were linked are found by email.
+			TrustEmail: true,
+		})
+	}
+	return append(providers, c.OAuth.Providers...)
+}
+
+// Validate reports every invalid value of the configuration at once.
+func (c *Config) Validate() error {
+	v := model.NewValidator()
+	c.validate(v)
+	return v.Err()
+}
+
+func (c *Config) validate(v *model.Validator) {
+	v.Nested("server", func(v *model.Validator) {
+		model.Field(v, "port", c.Server.Port, validPort())
+		model.Field(v, "host", c.Server.Host, required(), model.ValidURL())
+		model.Field(v, "requestTimeout", c.Server.RequestTimeout, model.Positive[time.Duration]())
+		model.Field(v, "sessionKey", c.Server.SessionKey, required(), minLength(minSessionKeyLength))
+		model.Field(v, "readTimeout", c.Server.ReadTimeout, model.Positive[time.Duration]())
+		model.Field(v, "readHeaderTimeout", c.Server.ReadHeaderTimeout, model.Positive[time.Duration]())
+		model.Field(v, "writeTimeout", c.Server.WriteTimeout, model.Positive[time.Duration]())
+		if c.Server.WriteTimeout <= c.Server.RequestTimeout {
+			v.AddError("writeTimeout", "should be longer than the request timeout")
+		}
+		model.Field(v, "idleTimeout", c.Server.IdleTimeout, model.Positive[time.Duration]())
+		model.Field(v, "maxHeaderBytes", c.Server.MaxHeaderBytes, model.Positive[int]())
+		model.Field(v, "shutdownDelay", c.Server.ShutdownDelay, model.Range[time.Duration](0, time.Hour))
+		model.Field(v, "shutdownTimeout", c.Server.ShutdownTimeout, model.Positive[time.Duration]())
+	})
+
+	v.Nested("database", func(v *model.Validator) {
+		model.Field(v, "driver", c.Database.Driver, model.OneOf("postgres", "sqlite"))
+		model.Field(v, "connectionString", c.Database.ConnectionString, required())
+		model.Field(v, "maxOpenConns", c.Database.MaxOpenConns, model.Range(0, 1<<31-1))
+		if c.Database.MaxOpenConns == 0 {
+			model.Field(v, "maxIdleConns", c.Database.MaxIdleConns, model.Range(0, 1<<31-1))
+		} else {
+			model.Field(v, "maxIdleConns", c.Database.MaxIdleConns, model.Range(0, c.Database.MaxOpenConns))
+		}
+		model.Field(v, "connMaxLifetime", c.Database.ConnMaxLifetime, model.Range[time.Duration](0, 1<<63-1))
+		model.Field(v, "connMaxIdleTime", c.Database.ConnMaxIdleTime, model.Range[time.Duration](0, 1<<63-1))
+		model.Field(v, "connectTimeout", c.Database.ConnectTimeout, model.Positive[time.Duration]())
+		model.Field(v, "healthCacheTtl", c.Database.HealthCacheTTL, model.Range[time.Duration](0, time.Minute))
+		for i, replica := range c.Database.Replicas {
+			v.Index("replicas", i, func(v *model.Validator) { model.Field(v, "", replica, required()) })
+		}
+		model.Field(v, "replicaCheckInterval", c.Database.ReplicaCheckInterval, model.Positive[time.Duration]())
+		model.Field(v, "readYourWritesWindow", c.Database.ReadYourWritesWindow, model.Range[time.Duration](0, time.Hour))
+	})
+
+	v.Nested("oauth", func(v *model.Validator) {
+		if c.OAuth.ClientID != "" || c.OAuth.ClientSecret != "" {
+			model.Field(v, "clientId", c.OAuth.ClientID, required())
+			model.Field(v, "clientSecret", c.OAuth.ClientSecret, required())
+			validateEndpoints(v, c.OAuth.Issuer, c.OAuth.AuthURL, c.OAuth.TokenURL, c.OAuth.UserInfoURL)
+		}
+
+		names := map[string]bool{"google": c.OAuth.ClientID != ""}
+		for i, provider := range c.OAuth.Providers {
+			v.Index("providers", i, func(v *model.Validator) {
+				model.Field(v, "name", provider.Name, required(), validProviderName())
+				if names[provider.Name] {
+					v.AddError("name", "is not unique")
+				}
+				names[provider.Name] = true
+
+				model.Field(v, "clientId", provider.ClientID, required())
+				model.Field(v, "clientSecret", provider.ClientSecret, required())
+				validateEndpoints(v, provider.Issuer, provider.AuthURL, provider.TokenURL, provider.UserInfoURL)
+			})
+		}
+	})
+
+	v.Nested("sessions", func(v *model.Validator) {
+		model.Field(v, "store", c.Sessions.Store, model.OneOf("database", "memory"))
+		for i, key := range c.Sessions.PreviousKeys {
+			v.Index("previousKeys", i, func(v *model.Validator) { model.Field(v, "", key, required(), minLength(minSessionKeyLength)) })
+		}
+		model.Field(v, "maxAge", c.Sessions.MaxAge, model.Positive[time.Duration]())
+		model.Field(v, "idleTimeout", c.Sessions.IdleTimeout, model.Range(time.Minute, max(c.Sessions.MaxAge, time.Minute)))
+	})
+
+	v.Nested("accounts", func(v *model.Validator) {
+		model.Field(v, "maxFailedLogins", c.Accounts.MaxFailedLogins, model.Positive[int]())
+		model.Field(v, "lockoutDuration", c.Accounts.LockoutDuration, model.Positive[time.Duration]())
+		model.Field(v, "verificationTtl", c.Accounts.VerificationTTL, model.Positive[time.Duration]())
+		model.Field(v, "resetTtl", c.Accounts.ResetTTL, model.Positive[time.Duration]())
+		model.Field(v, "deletionGracePeriod", c.Accounts.DeletionGracePeriod, model.Positive[time.Duration]())
+	})
+
+	v.Nested("mail", func(v *model.Validator) {
+		if c.Mail.SMTPAddr != "" {
+			model.Field(v, "from", c.Mail.From, required())
+		}
+	})
+
+	v.Nested("rateLimits", func(v *model.Validator) {
+		model.Field(v, "store", c.RateLimits.Store, model.OneOf("database", "memory"))
+		model.Field(v, "api", c.RateLimits.API, model.Range(0, 1<<31-1))
+		model.Field(v, "search", c.RateLimits.Search, model.Range(0, 1<<31-1))
+		model.Field(v, "write", c.RateLimits.Write, model.Range(0, 1<<31-1))
+		model.Field(v, "login", c.RateLimits.Login, model.Range(0, 1<<31-1))
+	})
+
+	v.Nested("pagination", func(v *model.Validator) {
+		model.Field(v, "minPageSize", c.Pagination.MinPageSize, model.Positive[int]())
+		model.Field(v, "maxPageSize", c.Pagination.MaxPageSize, model.Range(c.Pagination.MinPageSize, 1<<31-1))
+	})
+
+	v.Nested("logging", func(v *model.Validator) {
+		model.Field(v, "level", strings.ToLower(c.Logging.Level), model.OneOf("debug", "info", "warn", "error"))
+		model.Field(v, "format", strings.ToLower(c.Logging.Format), model.OneOf("text", "json"))
+	})
+
+	v.Nested("tracing", func(v *model.Validator) {
+		model.Field(v, "exporter", strings.ToLower(c.Tracing.Exporter), model.OneOf("none", "stdout", "otlp"))
+	})
+
+	v.Nested("metrics", func(v *model.Validator) {
+		if c.Metrics.Port != 0 {
+			model.Field(v, "port", c.Metrics.Port, validPort())
+		}
+	})
+
+	v.Nested("grpc", func(v *model.Validator) {
+		model.Field(v, "port", c.GRPC.Port, validPort())
+		for i, pair := range c.GRPC.ServiceTokens {
+			service, token, found := strings.Cut(pair, ":")
+			if !found || service == "" || token == "" {
+				v.Index("serviceTokens", i, func(v *model.Validator) { v.AddError("", "should be a service:token pair") })
+			}
+		}
+	})
+}
+
+// validateEndpoints requires the endpoints of plain OAuth2 providers, which
+// OpenID Connect providers discover from their issuer.
+func validateEndpoints(v *model.Validator, issuer, authURL, tokenURL, userInfoURL string) {
+	endpoints := []struct {
+		name, url string
+	}{{"issuer", issuer}, {"authUrl", authURL}, {"tokenUrl", tokenURL}, {"userInfoUrl", userInfoURL}}
+
+	for _, endpoint := range endpoints {
+		if endpoint.url == "" {
+			if issuer == "" && endpoint.name != "issuer" {
+				v.AddError(endpoint.name, "is required without an issuer")
+			}
+			continue
+		}
+		model.Field(v, endpoint.name, endpoint.url, model.ValidURL())
+	}
+}
+
+var providerNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// validProviderName allows names that can be used in the login path
+// /login/<name>, which does not clash with /login/providers.
+func validProviderName() model.Rule[string] {
+	return func(value string) string {
+		if value != "" && (!providerNameRegex.MatchString(value) || value == "providers") {
+			return "should be lower case letters, digits and dashes, other than providers"
+		}
+		return ""
+	}
+}
+
+func required() model.Rule[string] {
+	return func(value string) string {
+		if value == "" {
+			return "is required"
+		}
+		return ""
+	}
+}
+
+func minLength(min int) model.Rule[string] {
+	return func(value string) string {
+		if value != "" && len(value) < min {
+			return fmt.Sprintf("should be at least %d bytes long", min)
+		}
+		return ""
+	}
+}
+
+func validPort() model.Rule[int] {
+	return model.Range(1, 65535)
+}
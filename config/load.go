@@ -0,0 +1,271 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileEnv = "CONFIG_FILE"
+	mask          = "********"
+)
+
+// field is a single configurable value of a Config.
+type field struct {
+	path   string
+	env    string
+	flag   string
+	usage  string
+	secret string
+	value  reflect.Value
+}
+
+// fields lists the configurable values of c, with paths made of yaml names.
+func fields(c *Config) []field {
+	var result []field
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			structField := v.Type().Field(i)
+			path := prefix + strings.Split(structField.Tag.Get("yaml"), ",")[0]
+			if structField.Type.Kind() == reflect.Struct && structField.Type != reflect.TypeOf(time.Duration(0)) {
+				walk(path+".", v.Field(i))
+				continue
+			}
+
+			result = append(result, field{
+				path:   path,
+				env:    structField.Tag.Get("env"),
+				flag:   structField.Tag.Get("flag"),
+				usage:  structField.Tag.Get("usage"),
+				secret: structField.Tag.Get("secret"),
+				value:  v.Field(i),
+			})
+		}
+	}
+
+	walk("", reflect.ValueOf(c).Elem())
+	return result
+}
+
+// set parses raw into the value of f. Lists are comma separated.
+func (f *field) set(raw string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return errors.New("should be an integer")
+		}
+		f.value.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return errors.New("should be true or false")
+		}
+		f.value.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			return errors.New("should be a duration, e.g. 30s")
+		}
+		f.value.SetInt(int64(d))
+	case []int64:
+		var values []int64
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("should be a list of integers")
+			}
+			values = append(values, n)
+		}
+		f.value.Set(reflect.ValueOf(values))
+	case []string:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		f.value.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+	return nil
+}
+
+// rawFlag records the value of a flag, so that it is applied after the
+// configuration file and the environment.
+type rawFlag struct {
+	value string
+	set   bool
+}
+
+func (f *rawFlag) String() string { return f.value }
+
+func (f *rawFlag) Set(value string) error {
+	f.value, f.set = value, true
+	return nil
+}
+
+// Result is the outcome of Load.
+type Result struct {
+	Config *Config
+	// PrintConfig is set by the --print-config flag.
+	PrintConfig bool
+	// File is the configuration file that was read, if any.
+	File string
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, a YAML configuration file, environment variables and the
+// command-line args. The file is named by the --config flag or the CONFIG_FILE
+// environment variable.
+//
+// All problems are reported at once as model.ValidationErrors. The returned
+// Result is usable for printing even if the configuration is invalid.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Result, error) {
+	result := &Result{Config: Default()}
+	configFields := fields(result.Config)
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&result.File, "config", "", "YAML configuration `file`, defaults to $"+configFileEnv)
+	flags.BoolVar(&result.PrintConfig, "print-config", false, "print the configuration with secrets masked")
+	rawFlags := make([]rawFlag, len(configFields))
+	for i, f := range configFields {
+		if f.flag == "" {
+			continue
+		}
+		usage := f.usage
+		if f.env != "" {
+			usage += " ($" + f.env + ")"
+		}
+		flags.Var(&rawFlags[i], f.flag, usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	v := model.NewValidator()
+
+	if result.File == "" {
+		result.File, _ = lookupEnv(configFileEnv)
+	}
+	if result.File != "" {
+		if err := loadFile(result.Config, result.File); err != nil {
+			v.AddError("", err.Error())
+		}
+	}
+
+	for _, f := range configFields {
+		if f.env == "" {
+			continue
+		}
+		// Empty variables are treated as unset, like the blanks of .env.sample.
+		if raw, ok := lookupEnv(f.env); ok && raw != "" {
+			if err := f.set(raw); err != nil {
+				v.AddError(f.path, "$"+f.env+" "+err.Error())
+			}
+		}
+	}
+
+	for i, f := range configFields {
+		if rawFlags[i].set {
+			if err := f.set(rawFlags[i].value); err != nil {
+				v.AddError(f.path, "--"+f.flag+" "+err.Error())
+			}
+		}
+	}
+
+	result.Config.validate(v)
+	return result, v.Err()
+}
+
+func loadFile(c *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read configuration file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Masked returns a copy of c with every secret replaced by a placeholder.
+// Fields tagged secret:"token" are service:token pairs, whose service names
+// are kept.
+func (c *Config) Masked() *Config {
+	masked := *c
+
+	for _, f := range fields(&masked) {
+		if f.value.Kind() == reflect.Slice && !f.value.IsNil() {
+			f.value.Set(reflect.AppendSlice(reflect.MakeSlice(f.value.Type(), 0, f.value.Len()), f.value))
+			if f.value.Type().Elem().Kind() == reflect.Struct {
+				for i := 0; i < f.value.Len(); i++ {
+					maskStruct(f.value.Index(i))
+				}
+			}
+		}
+		if f.secret == "" {
+			continue
+		}
+
+		switch value := f.value.Interface().(type) {
+		case string:
+			if value != "" {
+				f.value.SetString(mask)
+			}
+		case []string:
+			for i, pair := range value {
+				if service, _, found := strings.Cut(pair, ":"); found && f.secret == "token" {
+					value[i] = service + ":" + mask
+				} else {
+					value[i] = mask
+				}
+			}
+		}
+	}
+
+	return &masked
+}
+
+// maskStruct masks the string fields tagged secret of an element of a list,
+// such as the client secrets of the identity providers.
+func maskStruct(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("secret") != "" && v.Field(i).Kind() == reflect.String && v.Field(i).String() != "" {
+			v.Field(i).SetString(mask)
+		}
+	}
+}
+
+// Print writes c as YAML with its secrets masked.
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Masked()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
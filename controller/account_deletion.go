@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/logging"
+	"github.com/vladoiliev02/online-store/model"
+)
+
+// deletionGracePeriod is how long users can change their mind after asking
+// for their account to be deleted.
+var deletionGracePeriod = 30 * 24 * time.Hour
+
+// SetDeletionGracePeriod delays the deletion of accounts by gracePeriod. It has
+// to be called before Router.
+func SetDeletionGracePeriod(gracePeriod time.Duration) {
+	deletionGracePeriod = gracePeriod
+}
+
+// deleteAccount anonymises a user and logs them out everywhere. It fails with
+// sql.ErrNoRows if the user does not exist or is deleted already.
+func deleteAccount(ctx context.Context, userDAO *dao.UserDAO, sessions SessionManager, id int64) error {
+	if err := userDAO.Delete(ctx, id); err != nil {
+		return err
+	}
+	if sessions == nil {
+		return nil
+	}
+	return sessions.RevokeAll(ctx, id)
+}
+
+// DeleteDueAccountsEvery deletes the accounts whose grace period is over
+// every interval until done is closed.
+func DeleteDueAccountsEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	userDAO := dao.NewUserDAO()
+	ctx := dao.WithAuditActor(context.Background(), dao.AuditActor{Service: "account-deletion"})
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		ids, err := userDAO.GetDueForDeletion(ctx, time.Now())
+		if err != nil {
+			logging.FromContext(ctx).Warn("cannot get the accounts due for deletion", "error", err)
+			continue
+		}
+		for _, id := range ids {
+			if err := deleteAccount(ctx, userDAO, sessionManager, id); err != nil {
+				logging.FromContext(ctx).Warn("cannot delete account", "user_id", id, "error", err)
+			}
+		}
+	}
+}
+
+// export returns the data of the current user as a JSON file.
+func (u *userController) export(r *http.Request) (*HTTPResponse[*model.UserExport], error) {
+	userID := GetContextParam[int64](UserIDKey, r.Context())
+
+	export, err := u.userDAO.Export(r.Context(), userID)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot export user data", Err: err}
+	}
+
+	response := NewOKResponse(export)
+	response.Header = http.Header{
+		"Content-Disposition": {`attachment; filename="online-store-user-` + strconv.FormatInt(userID, 10) + `.json"`},
+		"Cache-Control":       {"no-store"},
+	}
+	return response, nil
+}
+
+// deleteLoggedInUser schedules the deletion of the current user after the
+// grace period, which they can cancel until then.
+func (u *userController) deleteLoggedInUser(r *http.Request) (*HTTPResponse[*model.User], error) {
+	userID := GetContextParam[int64](UserIDKey, r.Context())
+
+	if err := u.userDAO.ScheduleDeletion(r.Context(), userID, time.Now().Add(deletionGracePeriod)); err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot delete user", Err: err}
+	}
+
+	user, err := u.userDAO.GetByID(r.Context(), userID)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get user", Err: err}
+	}
+
+	return NewResponse(http.StatusAccepted, user), nil
+}
+
+func (u *userController) cancelDeletion(r *http.Request) (*HTTPResponse[any], error) {
+	err := u.userDAO.CancelDeletion(r.Context(), GetContextParam[int64](UserIDKey, r.Context()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "No deletion is scheduled", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot cancel deletion", Err: err}
+	}
+
+	return NewStatusResponse[any](http.StatusNoContent), nil
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// admins holds the ids of the users who are administrators. Emails are not
+// used, users have them from several sources and not all are verified.
+var admins = map[int64]struct{}{}
+
+// SetAdmins makes the users with these ids administrators. It has to be
+// called before Router.
+func SetAdmins(userIDs []int64) {
+	admins = make(map[int64]struct{}, len(userIDs))
+	for _, id := range userIDs {
+		admins[id] = struct{}{}
+	}
+}
+
+// IsAdmin reports whether the user is an administrator.
+func IsAdmin(userID int64) bool {
+	_, ok := admins[userID]
+	return ok && userID != 0
+}
+
+// RequireAdmin responds with 403 unless the current user is an administrator
+// who passed two-factor authentication when logging in.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(GetContextParam[int64](UserIDKey, r.Context())) {
+			writeError(&HTTPError{Code: http.StatusForbidden, Message: "Administrators only"}, w, r)
+			return
+		}
+		if !GetContextParam[bool](TwoFactorKey, r.Context()) {
+			writeError(&HTTPError{Code: http.StatusForbidden, Message: "Administrators have to enable two-factor authentication and log in with it"}, w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newAdminRouter() chi.Router {
+	adminController := newAdminController()
+	r := chi.NewRouter()
+	r.Use(RequireAdmin)
+
+	r.Get("/audit", ControllerHandler(adminController.getAuditLog))
+	r.Get("/health", ControllerHandler(adminController.getHealth))
+
+	r.Route("/users/{id}", func(r chi.Router) {
+		r.Use(numericPathVariableExtractor("id"))
+		r.Delete("/", ControllerHandler(adminController.deleteUser))
+		r.Delete("/sessions", ControllerHandler(adminController.deleteUserSessions))
+	})
+
+	return r
+}
+
+type adminController struct {
+	sessions SessionManager
+	auditDAO *dao.AuditDAO
+	userDAO  *dao.UserDAO
+}
+
+func newAdminController() *adminController {
+	return &adminController{
+		sessions: sessionManager,
+		auditDAO: dao.NewAuditDAO(),
+		userDAO:  dao.NewUserDAO(),
+	}
+}
+
+type auditPage struct {
+	Entries []*model.AuditEntry `json:"entries"`
+	Count   int64               `json:"count"`
+}
+
+// getAuditLog returns the audit log, newest first, filtered by the actorId,
+// entityType, entityId and action query parameters and by the RFC 3339 times
+// from and to.
+func (a *adminController) getAuditLog(r *http.Request) (*HTTPResponse[*auditPage], error) {
+	page, pageSize, err := getPageAndPageSize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &dao.AuditFilter{
+		EntityType: getQueryParam(r, "entityType"),
+		Action:     getQueryParam(r, "action"),
+	}
+	if filter.ActorID, err = getNumericQueryParam(r, "actorId"); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid actor id", Err: err}
+	}
+	if filter.EntityID, err = getNumericQueryParam(r, "entityId"); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid entity id", Err: err}
+	}
+	if filter.From, err = getTimeQueryParam(r, "from"); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid value for from", Err: err}
+	}
+	if filter.To, err = getTimeQueryParam(r, "to"); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid value for to", Err: err}
+	}
+
+	entries, count, err := a.auditDAO.GetAll(r.Context(), filter, page, pageSize)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot read the audit log", Err: err}
+	}
+
+	return NewOKResponse(&auditPage{Entries: entries, Count: count}), nil
+}
+
+// getHealth returns the health of the database behind the readiness probe:
+// its errors, pending migrations, connection pool and replicas.
+func (a *adminController) getHealth(r *http.Request) (*HTTPResponse[*dao.Health], error) {
+	return NewOKResponse(dao.GetDAO().Health(r.Context())), nil
+}
+
+// deleteUserSessions forces a user to log in again, e.g. after their account
+// was compromised.
+func (a *adminController) deleteUserSessions(r *http.Request) (*HTTPResponse[any], error) {
+	if err := a.sessions.RevokeAll(r.Context(), GetContextParam[int64]("id", r.Context())); err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot revoke sessions", Err: err}
+	}
+
+	return NewStatusResponse[any](http.StatusNoContent), nil
+}
+
+// deleteUser deletes an account right away, without the grace period users
+// get when deleting their own.
+func (a *adminController) deleteUser(r *http.Request) (*HTTPResponse[any], error) {
+	err := deleteAccount(r.Context(), a.userDAO, a.sessions, GetContextParam[int64]("id", r.Context()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "User not found", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot delete user", Err: err}
+	}
+
+	return NewStatusResponse[any](http.StatusNoContent), nil
+}
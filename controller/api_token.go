@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	apiTokenIdCtxKey = "apiTokenId"
+
+	// apiTokenPrefix makes the tokens easy to recognize, e.g. by secret
+	// scanners.
+	apiTokenPrefix = "store_pat_"
+)
+
+// createdAPIToken is the response to creating a token, the only one holding
+// the token itself.
+type createdAPIToken struct {
+	*model.APIToken
+	Token string `json:"token"`
+}
+
+// newAPITokenRouter serves the personal access tokens of the current user,
+// which authenticate API requests with an "Authorization: Bearer" header.
+func newAPITokenRouter() chi.Router {
+	apiTokenController := newAPITokenController()
+	r := chi.NewRouter()
+
+	r.Get("/", ControllerHandler(apiTokenController.getAll))
+	r.Post("/", ControllerHandler(apiTokenController.create))
+
+	r.Route("/{apiTokenId}", func(r chi.Router) {
+		r.Use(numericPathVariableExtractor(apiTokenIdCtxKey))
+		r.Delete("/", ControllerHandler(apiTokenController.delete))
+	})
+
+	return r
+}
+
+type apiTokenController struct {
+	apiTokenDAO *dao.APITokenDAO
+}
+
+func newAPITokenController() *apiTokenController {
+	return &apiTokenController{
+		apiTokenDAO: dao.NewAPITokenDAO(),
+	}
+}
+
+func (a *apiTokenController) getAll(r *http.Request) (*HTTPResponse[[]*model.APIToken], error) {
+	tokens, err := a.apiTokenDAO.GetByUserID(r.Context(), GetContextParam[int64](UserIDKey, r.Context()))
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get API tokens", Err: err}
+	}
+
+	return NewOKResponse(tokens), nil
+}
+
+func (a *apiTokenController) create(r *http.Request) (*HTTPResponse[*createdAPIToken], error) {
+	token, err := jsonUnmarshalBody[model.APIToken](r)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if err := model.ValidateAPIToken(token, now); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid API token", Err: err}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot generate API token", Err: err}
+	}
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	token.UserID = model.NullInt64JSON{Int64: GetContextParam[int64](UserIDKey, r.Context()), Valid: true}
+	token.TokenHash = HashAPIToken(secret)
+	token.CreatedAt = now
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	token.LastUsedAt = nil
+
+	token, err = a.apiTokenDAO.Create(r.Context(), token)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot create API token", Err: err}
+	}
+
+	return NewResponse(http.StatusCreated, &createdAPIToken{APIToken: token, Token: secret}), nil
+}
+
+func (a *apiTokenController) delete(r *http.Request) (*HTTPResponse[any], error) {
+	id := GetContextParam[int64](apiTokenIdCtxKey, r.Context())
+
+	err := a.apiTokenDAO.DeleteByUser(r.Context(), GetContextParam[int64](UserIDKey, r.Context()), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "API token not found", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot revoke API token", Err: err}
+	}
+
+	return NewStatusResponse[any](http.StatusNoContent), nil
+}
+
+// HashAPIToken returns the hash under which a token is stored.
+func HashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// RequireSession responds with 403 to requests authenticated with an API
+// token, for routes that manage the account of the user, so that a leaked
+// token cannot be used to take it over.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetContextParam[int64](APITokenIDKey, r.Context()) != 0 {
+			writeError(&HTTPError{Code: http.StatusForbidden, Message: "Not allowed with an API token"}, w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
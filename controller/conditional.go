@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETags of versioned resources are their quoted version number.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// conditionalGet responds with body, or with 304 Not Modified if the client
+// already has the given version.
+func conditionalGet[T any](r *http.Request, body T, version int64) *HTTPResponse[T] {
+	var response *HTTPResponse[T]
+	if etagListContains(r.Header.Get("If-None-Match"), etag(version)) {
+		response = NewStatusResponse[T](http.StatusNotModified)
+	} else {
+		response = NewOKResponse(body)
+	}
+
+	return withETag(response, version)
+}
+
+func withETag[T any](response *HTTPResponse[T], version int64) *HTTPResponse[T] {
+	if response.Header == nil {
+		response.Header = http.Header{}
+	}
+	response.Header.Set("ETag", etag(version))
+	return response
+}
+
+// ifMatchVersion returns the version required by the If-Match header, or 0
+// when the header is absent or "*".
+func ifMatchVersion(r *http.Request) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) {
+		return 0, &HTTPError{Code: http.StatusPreconditionFailed, Message: "Invalid If-Match header", Err: err}
+	}
+
+	return version, nil
+}
+
+func etagListContains(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConditionalGet_NotModified(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+	r.Header.Set("If-None-Match", `"1", W/"3"`)
+
+	response := conditionalGet(r, "body", 3)
+	if response.StatusCode != http.StatusNotModified || response.HasBody {
+		t.Errorf("expected 304 without a body, got %d", response.StatusCode)
+	}
+	if response.Header.Get("ETag") != `"3"` {
+		t.Errorf("unexpected ETag %q", response.Header.Get("ETag"))
+	}
+
+	response = conditionalGet(r, "body", 4)
+	if response.StatusCode != http.StatusOK || response.Body != "body" {
+		t.Errorf("expected 200 with a body, got %d", response.StatusCode)
+	}
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int64
+		valid   bool
+	}{
+		{"", 0, true},
+		{"*", 0, true},
+		{`"7"`, 7, true},
+		{"7", 0, false},
+		{`"abc"`, 0, false},
+		{`"0"`, 0, false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/products/1", nil)
+		r.Header.Set("If-Match", test.header)
+
+		version, err := ifMatchVersion(r)
+		if (err == nil) != test.valid || version != test.version {
+			t.Errorf("If-Match %q: got %d, %v", test.header, version, err)
+		}
+	}
+}
@@ -1,43 +1,95 @@
 package controller
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"reflect"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/logging"
 
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 )
 
+var tracer = otel.Tracer("github.com/vladoiliev02/online-store/controller")
+
 const (
 	SessionKey = "sessionKey"
+
+	// statusClientClosedRequest is reported when the client disconnects before
+	// the response is ready. It follows the nginx convention.
+	statusClientClosedRequest = 499
 )
 
+// shuttingDown fails the readiness probe once the server starts draining.
+var shuttingDown atomic.Bool
+
+// SetShuttingDown makes the readiness probe report 503, so that load balancers
+// stop routing new requests to the server while in-flight ones are drained.
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
 func Router() chi.Router {
 	r := chi.NewRouter()
+	r.Use(auditActor)
 
-	r.Mount("/products", newProductRouter())
-	r.Mount("/orders", newOrderRouter())
-	r.Mount("/users", newUserRouter())
+	r.Group(func(r chi.Router) {
+		r.Use(rateLimit("api", rateLimits.API))
+		// GraphQL posts its queries too, it limits its mutations as writes
+		// itself.
+		r.Mount("/graphql", newGraphQLRouter())
+
+		r.Group(func(r chi.Router) {
+			r.Use(rateLimitWrites)
+			r.Mount("/products", newProductRouter())
+			r.Mount("/orders", newOrderRouter())
+			r.Mount("/users", newUserRouter())
+			r.Mount("/admin", newAdminRouter())
+		})
+	})
 
 	r.Get("/liveness", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	r.Get("/readiness", func(w http.ResponseWriter, r *http.Request) {
-		if dao.GetDAO().IsReady() {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(http.StatusServiceUnavailable)
-		}
-	})
+	r.Get("/readiness", ControllerHandler(readiness))
+	r.Get("/openapi.json", openAPIHandler(r))
 
 	return r
 }
 
+// readinessStatus is the body of the readiness probe. It is public, so the
+// health of the database is only told to administrators, see
+// adminController.getHealth.
+type readinessStatus struct {
+	Status string `json:"status"`
+}
+
+func readiness(r *http.Request) (*HTTPResponse[*readinessStatus], error) {
+	if shuttingDown.Load() {
+		return NewResponse(http.StatusServiceUnavailable, &readinessStatus{Status: "shutting down"}), nil
+	}
+
+	// The database is not checked while the server shuts down.
+	if health := dao.GetDAO().Health(r.Context()); !health.Ready {
+		return NewResponse(http.StatusServiceUnavailable, &readinessStatus{Status: "unavailable"}), nil
+	}
+
+	return NewOKResponse(&readinessStatus{Status: "ready"}), nil
+}
+
 type HTTPError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
@@ -48,6 +100,7 @@ type HTTPResponse[T any] struct {
 	StatusCode int
 	HasBody    bool
 	Body       T
+	Header     http.Header
 }
 
 func NewOKResponse[T any](body T) *HTTPResponse[T] {
@@ -81,46 +134,94 @@ func (e *HTTPError) Unwrap() error {
 	return e.Err
 }
 
+// ControllerHandler adapts a controller method to an http.HandlerFunc, running
+// it in a span named after the method.
 func ControllerHandler[T any](handler func(*http.Request) (*HTTPResponse[T], error)) http.HandlerFunc {
+	spanName := handlerName(handler)
+
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), spanName)
+		defer span.End()
+		r = r.WithContext(ctx)
+
 		response, err := handler(r)
 		if err != nil {
-			writeError(err, w)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "handler failed")
+			writeError(err, w, r)
 		} else {
 			writeResponse(response, w)
 		}
 	}
 }
 
+// handlerName turns the name of a method value, such as
+// "github.com/.../controller.(*productController).getById-fm", into
+// "productController.getById".
+func handlerName(handler any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name[strings.LastIndex(name, "/")+1:], "-fm")
+	name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+	return strings.TrimPrefix(name, "controller.")
+}
+
 func writeResponse[T any](response *HTTPResponse[T], w http.ResponseWriter) {
-	if response.HasBody {
-		log.Println("writing response")
-		responseJSON, err := json.Marshal(response.Body)
-		if err != nil {
-			internalError(w)
-			return
+	for key, values := range response.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
 		}
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(responseJSON)
+	if !response.HasBody {
+		w.WriteHeader(response.StatusCode)
+		return
 	}
 
+	responseJSON, err := json.Marshal(response.Body)
+	if err != nil {
+		internalError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(response.StatusCode)
+	w.Write(responseJSON)
+}
+
+// WriteError writes err like ControllerHandler does, for handlers outside of
+// the package that need the http.ResponseWriter, e.g. to set cookies.
+func WriteError(err error, w http.ResponseWriter, r *http.Request) {
+	writeError(err, w, r)
 }
 
-func writeError(err error, w http.ResponseWriter) {
+func writeError(err error, w http.ResponseWriter, r *http.Request) {
 	e, ok := err.(*HTTPError)
 	if !ok {
 		e = &HTTPError{Code: http.StatusInternalServerError, Message: "Internal Server Error", Err: err}
 	}
 
-	log.Println(err.Error() + " - caused by - " + e.Err.Error())
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		e = &HTTPError{Code: http.StatusGatewayTimeout, Message: "Request timed out", Err: err}
+	case errors.Is(err, context.Canceled):
+		e = &HTTPError{Code: statusClientClosedRequest, Message: "Request canceled", Err: err}
+	}
+
+	level := slog.LevelWarn
+	if e.Code >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	attrs := []any{"status", e.Code}
+	if e.Err != nil {
+		attrs = append(attrs, "error", e.Err)
+	}
+	logging.FromContext(r.Context()).Log(r.Context(), level, e.Message, attrs...)
+
 	httpErr, err := json.Marshal(e)
 	if err != nil {
 		internalError(w)
 		return
 	}
-	log.Println("Returning:", string(httpErr))
 
 	http.Error(w, string(httpErr), e.Code)
 	w.Header().Set("Content-Type", "application/json")
@@ -148,6 +249,16 @@ func getNumericQueryParam(r *http.Request, name string) (int64, error) {
 	}
 }
 
+// getTimeQueryParam parses an RFC 3339 query parameter, which is the zero
+// time if it is missing.
+func getTimeQueryParam(r *http.Request, name string) (time.Time, error) {
+	val := r.URL.Query().Get(name)
+	if val == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
 func getNumericPathVariable(r *http.Request, name string) (int64, error) {
 	str := chi.URLParam(r, name)
 	return toInt(str)
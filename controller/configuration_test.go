@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadiness_FailsWhenShuttingDown(t *testing.T) {
+	router := Router()
+	defer shuttingDown.Store(false)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 before shutdown, got %d", w.Code)
+	}
+
+	SetShuttingDown()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while shutting down, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/liveness", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected liveness to keep passing, got %d", w.Code)
+	}
+}
+
+func TestReadiness_ReportsOnlyStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+
+	var status map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("expected a JSON body, got %q", w.Body.String())
+	}
+	if len(status) != 1 || status["status"] != "ready" {
+		t.Errorf("expected only the status of a ready server, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/health", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected the database health to be for administrators only, got %d", w.Code)
+	}
+}
@@ -0,0 +1,804 @@
+package controller
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type graphQLController struct {
+	schema     graphql.Schema
+	userDAO    *dao.UserDAO
+	productDAO *dao.ProductDAO
+	commentDAO *dao.CommentDAO
+	imageDAO   *dao.ImageDAO
+	orderDAO   *dao.OrderDAO
+	itemDAO    *dao.ItemDAO
+	invoiceDAO *dao.InvoiceDAO
+}
+
+func newGraphQLRouter() chi.Router {
+	graphQLController := newGraphQLController()
+	r := chi.NewRouter()
+
+	r.Get("/", graphQLController.serve)
+	r.Post("/", graphQLController.serve)
+
+	return r
+}
+
+func newGraphQLController() *graphQLController {
+	g := &graphQLController{
+		userDAO:    dao.NewUserDAO(),
+		productDAO: dao.NewProductDAO(),
+		commentDAO: dao.NewCommentDAO(),
+		imageDAO:   dao.NewImageDAO(),
+		orderDAO:   dao.NewOrderDAO(),
+		itemDAO:    dao.NewItemDAO(),
+		invoiceDAO: dao.NewInvoiceDAO(),
+	}
+
+	schema, err := g.newSchema()
+	if err != nil {
+		panic(err.Error())
+	}
+	g.schema = schema
+
+	return g
+}
+
+func (g *graphQLController) serve(w http.ResponseWriter, r *http.Request) {
+	var request *graphQLRequest
+	if r.Method == http.MethodGet {
+		request = &graphQLRequest{
+			Query:         getQueryParam(r, "query"),
+			OperationName: getQueryParam(r, "operationName"),
+		}
+		if variables := getQueryParam(r, "variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				writeError(&HTTPError{Code: http.StatusBadRequest, Message: "Invalid GraphQL variables", Err: err}, w, r)
+				return
+			}
+		}
+	} else {
+		var err error
+		request, err = jsonUnmarshalBody[graphQLRequest](r)
+		if err != nil {
+			writeError(err, w, r)
+			return
+		}
+	}
+
+	document, operation := graphQLOperation(request)
+	// GET requests are exempt from the CSRF check, the write scope of API
+	// tokens and the write rate limit, so they may only read.
+	if r.Method == http.MethodGet && operation != nil && operation.Operation != ast.OperationTypeQuery {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(&HTTPError{Code: http.StatusMethodNotAllowed, Message: "Only queries can be sent with GET, use POST"}, w, r)
+		return
+	}
+
+	execute := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Loaders cache DAO results, so they must not outlive the request.
+		ctx := SetContextParam(graphQLLoadersKey, newGraphQLLoaders(r.Context(), g), r.Context())
+		result := graphql.Do(graphql.Params{
+			Schema:         g.schema,
+			RequestString:  request.Query,
+			OperationName:  request.OperationName,
+			VariableValues: request.Variables,
+			Context:        ctx,
+		})
+
+		writeResponse(NewOKResponse(result), w)
+	})
+	graphQLRateLimit(document, operation)(execute).ServeHTTP(w, r)
+}
+
+// graphQLOperation parses request and returns the operation that is
+// executed, or nil if there is none, in which case executing the request
+// fails too.
+func graphQLOperation(request *graphQLRequest) (*ast.Document, *ast.OperationDefinition) {
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return nil, nil
+	}
+
+	var operation *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		candidate, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if request.OperationName == "" && operation != nil {
+			return document, nil
+		}
+		if request.OperationName == "" || (candidate.Name != nil && candidate.Name.Value == request.OperationName) {
+			operation = candidate
+		}
+	}
+	return document, operation
+}
+
+// graphQLRateLimit limits mutations as writes and the queries that search
+// products as searches, like their REST counterparts.
+func graphQLRateLimit(document *ast.Document, operation *ast.OperationDefinition) func(http.Handler) http.Handler {
+	switch {
+	case operation == nil:
+		return func(next http.Handler) http.Handler { return next }
+	case operation.Operation == ast.OperationTypeMutation:
+		return rateLimit("write", rateLimits.Write)
+	case selectsField(document, operation.SelectionSet, "search", map[string]bool{}):
+		return rateLimit("search", rateLimits.Search)
+	default:
+		return func(next http.Handler) http.Handler { return next }
+	}
+}
+
+// selectsField tells if selections select the top-level field name, directly
+// or in fragments. visited holds the fragments already looked into.
+func selectsField(document *ast.Document, selections *ast.SelectionSet, name string, visited map[string]bool) bool {
+	if selections == nil {
+		return false
+	}
+
+	for _, selection := range selections.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Name != nil && selection.Name.Value == name {
+				return true
+			}
+		case *ast.InlineFragment:
+			if selectsField(document, selection.SelectionSet, name, visited) {
+				return true
+			}
+		case *ast.FragmentSpread:
+			if selection.Name == nil || visited[selection.Name.Value] {
+				continue
+			}
+			visited[selection.Name.Value] = true
+			for _, definition := range document.Definitions {
+				fragment, ok := definition.(*ast.FragmentDefinition)
+				if ok && fragment.Name != nil && fragment.Name.Value == selection.Name.Value &&
+					selectsField(document, fragment.SelectionSet, name, visited) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (g *graphQLController) newSchema() (graphql.Schema, error) {
+	price := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Price",
+		Fields: graphql.Fields{
+			"units":    scalarField(graphql.Int),
+			"currency": scalarField(graphql.Int),
+		},
+	})
+
+	address := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Address",
+		Fields: graphql.Fields{
+			"id":         scalarField(graphql.ID),
+			"city":       scalarField(graphql.String),
+			"country":    scalarField(graphql.String),
+			"address":    scalarField(graphql.String),
+			"postalCode": scalarField(graphql.String),
+		},
+	})
+
+	user := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":         scalarField(graphql.ID),
+			"name":       scalarField(graphql.String),
+			"firstName":  scalarField(graphql.String),
+			"lastName":   scalarField(graphql.String),
+			"pictureUrl": scalarField(graphql.String),
+			"email":      scalarField(graphql.String),
+			"createdAt":  scalarField(graphql.String),
+			"address":    &graphql.Field{Type: address},
+		},
+	})
+
+	comment := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Comment",
+		Fields: graphql.Fields{
+			"id":        scalarField(graphql.ID),
+			"productId": scalarField(graphql.ID),
+			"comment":   scalarField(graphql.String),
+			"createdAt": scalarField(graphql.String),
+			"user": &graphql.Field{
+				Type: user,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).users.load(p.Source.(*model.Comment).User.ID.Int64), nil
+				},
+			},
+		},
+	})
+
+	image := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Image",
+		Fields: graphql.Fields{
+			"id":        scalarField(graphql.ID),
+			"productId": scalarField(graphql.ID),
+			"data":      scalarField(graphql.String),
+			"format":    scalarField(graphql.String),
+		},
+	})
+
+	rating := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Rating",
+		Fields: graphql.Fields{
+			"productId": scalarField(graphql.ID),
+			"rating":    scalarField(graphql.Int),
+			"user": &graphql.Field{
+				Type: user,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).users.load(p.Source.(*model.Rating).UserID.Int64), nil
+				},
+			},
+		},
+	})
+
+	product := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Product",
+		Fields: graphql.Fields{
+			"id":           scalarField(graphql.ID),
+			"name":         scalarField(graphql.String),
+			"description":  scalarField(graphql.String),
+			"price":        &graphql.Field{Type: price},
+			"quantity":     scalarField(graphql.Int),
+			"category":     scalarField(graphql.Int),
+			"available":    scalarField(graphql.Boolean),
+			"rating":       scalarField(graphql.Float),
+			"ratingsCount": scalarField(graphql.Int),
+			"createdAt":    scalarField(graphql.String),
+			"version":      scalarField(graphql.Int),
+			"seller": &graphql.Field{
+				Type: user,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).users.load(p.Source.(*model.Product).UserID.Int64), nil
+				},
+			},
+			"comments": &graphql.Field{
+				Type: graphql.NewList(comment),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).comments.load(p.Source.(*model.Product).ID.Int64), nil
+				},
+			},
+			"ratings": &graphql.Field{
+				Type: graphql.NewList(rating),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).ratings.load(p.Source.(*model.Product).ID.Int64), nil
+				},
+			},
+			"images": &graphql.Field{
+				Type: graphql.NewList(image),
+				Args: graphql.FieldConfigArgument{
+					"limit": {Type: graphql.Int, DefaultValue: 1},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					limit := p.Args["limit"].(int)
+					images := getGraphQLLoaders(p.Context).images.load(p.Source.(*model.Product).ID.Int64)
+					return func() (any, error) {
+						result, err := images()
+						if err != nil || result == nil {
+							return result, err
+						}
+						if list := result.([]*model.Image); len(list) > limit {
+							return list[:limit], nil
+						}
+						return result, nil
+					}, nil
+				},
+			},
+		},
+	})
+
+	productPageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ProductPage",
+		Fields: graphql.Fields{
+			"products": &graphql.Field{Type: graphql.NewList(product)},
+			"count":    &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	invoice := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Invoice",
+		Fields: graphql.Fields{
+			"id":         scalarField(graphql.ID),
+			"totalPrice": &graphql.Field{Type: price},
+			"createdAt":  scalarField(graphql.String),
+		},
+	})
+
+	item := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Item",
+		Fields: graphql.Fields{
+			"id":       scalarField(graphql.ID),
+			"orderId":  scalarField(graphql.ID),
+			"quantity": scalarField(graphql.Int),
+			"price":    &graphql.Field{Type: price},
+			"product": &graphql.Field{
+				Type: product,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).products.load(p.Source.(*model.Item).ProductID.Int64), nil
+				},
+			},
+		},
+	})
+
+	order := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Order",
+		Fields: graphql.Fields{
+			"id":           scalarField(graphql.ID),
+			"status":       scalarField(graphql.Int),
+			"address":      &graphql.Field{Type: address},
+			"createdAt":    scalarField(graphql.String),
+			"latestUpdate": scalarField(graphql.String),
+			"user": &graphql.Field{
+				Type: user,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).users.load(p.Source.(*model.Order).UserID.Int64), nil
+				},
+			},
+			"items": &graphql.Field{
+				Type: graphql.NewList(item),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).items.load(p.Source.(*model.Order).ID.Int64), nil
+				},
+			},
+			"invoice": &graphql.Field{
+				Type: invoice,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return getGraphQLLoaders(p.Context).invoices.load(p.Source.(*model.Order).ID.Int64), nil
+				},
+			},
+		},
+	})
+
+	priceInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "PriceInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"units":    {Type: graphql.NewNonNull(graphql.Int)},
+			"currency": {Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	addressInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "AddressInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"city":       {Type: graphql.NewNonNull(graphql.String)},
+			"country":    {Type: graphql.NewNonNull(graphql.String)},
+			"address":    {Type: graphql.NewNonNull(graphql.String)},
+			"postalCode": {Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	productInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "ProductInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":        {Type: graphql.String},
+			"description": {Type: graphql.String},
+			"price":       {Type: graphql.NewNonNull(priceInput)},
+			"quantity":    {Type: graphql.NewNonNull(graphql.Int)},
+			"category":    {Type: graphql.NewNonNull(graphql.Int)},
+			"available":   {Type: graphql.Boolean},
+		},
+	})
+
+	pageArgs := graphql.FieldConfigArgument{
+		"page":       {Type: graphql.Int, DefaultValue: 1},
+		"pageSize":   {Type: graphql.Int, DefaultValue: 0},
+		"categories": {Type: graphql.Int, DefaultValue: model.ProductCategoryMask},
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type:    user,
+				Resolve: g.me,
+			},
+			"user": &graphql.Field{
+				Type:    user,
+				Args:    graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: g.user,
+			},
+			"product": &graphql.Field{
+				Type:    product,
+				Args:    graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: g.product,
+			},
+			"products": &graphql.Field{
+				Type:    productPageType,
+				Args:    withArgs(pageArgs, graphql.FieldConfigArgument{"userId": {Type: graphql.ID}}),
+				Resolve: g.products,
+			},
+			"search": &graphql.Field{
+				Type:    productPageType,
+				Args:    withArgs(pageArgs, graphql.FieldConfigArgument{"name": {Type: graphql.NewNonNull(graphql.String)}}),
+				Resolve: g.search,
+			},
+			"order": &graphql.Field{
+				Type:    order,
+				Args:    graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: g.order,
+			},
+			"orders": &graphql.Field{
+				Type:    graphql.NewList(order),
+				Args:    graphql.FieldConfigArgument{"status": {Type: graphql.Int}},
+				Resolve: g.orders,
+			},
+			"cart": &graphql.Field{
+				Type:    order,
+				Resolve: g.cart,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"addToCart": &graphql.Field{
+				Type: item,
+				Args: graphql.FieldConfigArgument{
+					"productId": {Type: graphql.NewNonNull(graphql.ID)},
+					"quantity":  {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: g.addToCart,
+			},
+			"removeFromCart": &graphql.Field{
+				Type:    graphql.Boolean,
+				Args:    graphql.FieldConfigArgument{"itemId": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: g.removeFromCart,
+			},
+			"checkout": &graphql.Field{
+				Type:    order,
+				Args:    graphql.FieldConfigArgument{"address": {Type: graphql.NewNonNull(addressInput)}},
+				Resolve: g.checkout,
+			},
+			"createProduct": &graphql.Field{
+				Type:    product,
+				Args:    graphql.FieldConfigArgument{"input": {Type: graphql.NewNonNull(productInput)}},
+				Resolve: g.createProduct,
+			},
+			"updateProduct": &graphql.Field{
+				Type: product,
+				Args: graphql.FieldConfigArgument{
+					"id":    {Type: graphql.NewNonNull(graphql.ID)},
+					"input": {Type: graphql.NewNonNull(productInput)},
+					// version makes the update fail if the product changed
+					// since, like If-Match does for REST.
+					"version": {Type: graphql.Int},
+				},
+				Resolve: g.updateProduct,
+			},
+			"rateProduct": &graphql.Field{
+				Type: product,
+				Args: graphql.FieldConfigArgument{
+					"id":     {Type: graphql.NewNonNull(graphql.ID)},
+					"rating": {Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: g.rateProduct,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+}
+
+func (g *graphQLController) me(p graphql.ResolveParams) (any, error) {
+	return getGraphQLLoaders(p.Context).users.load(GetContextParam[int64](UserIDKey, p.Context)), nil
+}
+
+func (g *graphQLController) user(p graphql.ResolveParams) (any, error) {
+	id, err := idArg(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	return getGraphQLLoaders(p.Context).users.load(id), nil
+}
+
+func (g *graphQLController) product(p graphql.ResolveParams) (any, error) {
+	id, err := idArg(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	return getGraphQLLoaders(p.Context).products.load(id), nil
+}
+
+func (g *graphQLController) products(p graphql.ResolveParams) (any, error) {
+	page, pageSize, category := p.Args["page"].(int), p.Args["pageSize"].(int), model.ProductCategory(p.Args["categories"].(int))
+
+	var products []*model.Product
+	var count int64
+	var err error
+	if _, ok := p.Args["userId"]; ok {
+		var userID int64
+		userID, err = idArg(p, "userId")
+		if err != nil {
+			return nil, err
+		}
+		products, count, err = g.productDAO.GetByUserID(p.Context, userID, page, pageSize)
+	} else {
+		products, count, err = g.productDAO.GetAll(p.Context, page, pageSize, category)
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Products not found", Err: err}
+	}
+
+	return &productPage{Products: products, Count: count}, nil
+}
+
+func (g *graphQLController) search(p graphql.ResolveParams) (any, error) {
+	page, pageSize, category := p.Args["page"].(int), p.Args["pageSize"].(int), model.ProductCategory(p.Args["categories"].(int))
+
+	products, count, err := g.productDAO.GetByNameLike(p.Context, p.Args["name"].(string), page, pageSize, category)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Products not found", Err: err}
+	}
+
+	return &productPage{Products: products, Count: count}, nil
+}
+
+func (g *graphQLController) order(p graphql.ResolveParams) (any, error) {
+	id, err := idArg(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := g.orderDAO.GetByID(p.Context, id)
+	if err != nil || order.UserID.Int64 != GetContextParam[int64](UserIDKey, p.Context) {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Order not found", Err: err}
+	}
+
+	return order, nil
+}
+
+func (g *graphQLController) orders(p graphql.ResolveParams) (any, error) {
+	userID := GetContextParam[int64](UserIDKey, p.Context)
+
+	var orders []*model.Order
+	var err error
+	if status, ok := p.Args["status"].(int); ok {
+		orders, err = g.orderDAO.GetByUserIDAndStatus(p.Context, userID, model.OrderStatus(status))
+	} else {
+		orders, err = g.orderDAO.GetByUserID(p.Context, userID)
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Order not found", Err: err}
+	}
+
+	return orders, nil
+}
+
+func (g *graphQLController) cart(p graphql.ResolveParams) (any, error) {
+	cart, err := g.orderDAO.GetCart(p.Context, GetContextParam[int64](UserIDKey, p.Context))
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Cart not found", Err: err}
+	}
+
+	return cart, nil
+}
+
+func (g *graphQLController) addToCart(p graphql.ResolveParams) (any, error) {
+	userID := GetContextParam[int64](UserIDKey, p.Context)
+	productID, err := idArg(p, "productId")
+	if err != nil {
+		return nil, err
+	}
+
+	cart, err := g.orderDAO.GetCart(p.Context, userID)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Cart not found", Err: err}
+	}
+
+	item := &model.Item{}
+	item.ProductID.Scan(productID)
+	item.OrderID = cart.ID
+	item.Quantity.Scan(int64(p.Args["quantity"].(int)))
+	if err := model.ValidateItem(item, false); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid item", Err: err}
+	}
+
+	item, err = g.orderDAO.AddItem(p.Context, userID, item)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot add item", Err: err}
+	}
+
+	return item, nil
+}
+
+func (g *graphQLController) removeFromCart(p graphql.ResolveParams) (any, error) {
+	itemID, err := idArg(p, "itemId")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.orderDAO.RemoveItem(p.Context, GetContextParam[int64](UserIDKey, p.Context), itemID); err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot remove item from cart", Err: err}
+	}
+
+	return true, nil
+}
+
+func (g *graphQLController) checkout(p graphql.ResolveParams) (any, error) {
+	cart, err := g.orderDAO.GetCart(p.Context, GetContextParam[int64](UserIDKey, p.Context))
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Cart not found", Err: err}
+	}
+
+	cart, err = g.orderDAO.LoadItems(p.Context, cart)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot load items", Err: err}
+	}
+
+	if err := decodeArg(p, "address", &cart.Address); err != nil {
+		return nil, err
+	}
+	cart.Address.ID = model.NullInt64JSON{}
+	cart.Status = model.InProgress
+
+	model.NormalizeOrder(cart)
+	if err := model.ValidateOrder(cart, true); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid order", Err: err}
+	}
+
+	order, err := g.orderDAO.Update(p.Context, cart)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Order update error", Err: err}
+	}
+
+	return order, nil
+}
+
+func (g *graphQLController) createProduct(p graphql.ResolveParams) (any, error) {
+	product := &model.Product{}
+	if err := decodeArg(p, "input", product); err != nil {
+		return nil, err
+	}
+	product.UserID.Scan(GetContextParam[int64](UserIDKey, p.Context))
+
+	model.NormalizeProduct(product)
+	if err := model.ValidateProduct(product, false); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid product", Err: err}
+	}
+
+	product, err := g.productDAO.Create(p.Context, product)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Product creation error", Err: err}
+	}
+
+	return product, nil
+}
+
+func (g *graphQLController) updateProduct(p graphql.ResolveParams) (any, error) {
+	id, err := idArg(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := g.productDAO.GetByID(p.Context, id)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Product not found", Err: err}
+	}
+
+	if err := checkSeller(p.Context, existing); err != nil {
+		return nil, err
+	}
+
+	product := &model.Product{}
+	if err := decodeArg(p, "input", product); err != nil {
+		return nil, err
+	}
+	product.ID.Scan(id)
+	if version, ok := p.Args["version"].(int); ok {
+		product.Version = int64(version)
+	}
+
+	model.NormalizeProduct(product)
+	if err := model.ValidateProduct(product, true); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid product", Err: err}
+	}
+
+	product, err = g.productDAO.Update(p.Context, product)
+	if errors.Is(err, dao.ErrVersionMismatch) {
+		return nil, &HTTPError{Code: http.StatusPreconditionFailed, Message: "Product was modified", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Could not update product", Err: err}
+	}
+
+	return product, nil
+}
+
+func (g *graphQLController) rateProduct(p graphql.ResolveParams) (any, error) {
+	id, err := idArg(p, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	rating := &model.Rating{}
+	rating.UserID.Scan(GetContextParam[int64](UserIDKey, p.Context))
+	rating.ProductID.Scan(id)
+	rating.Rating.Scan(int64(p.Args["rating"].(int)))
+
+	if err := model.ValidateRating(rating); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid rating", Err: err}
+	}
+
+	product, err := g.productDAO.AddRating(p.Context, rating)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot add rating", Err: err}
+	}
+
+	return product, nil
+}
+
+// scalarField resolves a scalar by its JSON name, unwrapping the model's
+// nullable types into plain values.
+func scalarField(t graphql.Output) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			value, err := graphql.DefaultResolveFn(p)
+			if valuer, ok := value.(driver.Valuer); ok && err == nil {
+				return valuer.Value()
+			}
+			return value, err
+		},
+	}
+}
+
+func withArgs(args ...graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	result := graphql.FieldConfigArgument{}
+	for _, a := range args {
+		for name, arg := range a {
+			result[name] = arg
+		}
+	}
+	return result
+}
+
+func idArg(p graphql.ResolveParams, name string) (int64, error) {
+	id, err := toInt(p.Args[name].(string))
+	if err != nil {
+		return 0, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid " + name, Err: err}
+	}
+	return id, nil
+}
+
+// decodeArg converts an input object argument into its model type through JSON.
+func decodeArg(p graphql.ResolveParams, name string, target any) error {
+	bytes, err := json.Marshal(p.Args[name])
+	if err == nil {
+		err = json.Unmarshal(bytes, target)
+	}
+	if err != nil {
+		return &HTTPError{Code: http.StatusBadRequest, Message: "Invalid " + name, Err: err}
+	}
+	return nil
+}
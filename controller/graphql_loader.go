@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	graphQLLoadersKey = "graphQLLoaders"
+
+	// Images are loaded in batches, so the per-field limit is applied after
+	// loading up to this many images of every product.
+	maxGraphQLImages = 10
+)
+
+// batchLoader collects the IDs requested while one level of a GraphQL query
+// is resolved and loads all of them with a single DAO call once the first
+// value is needed.
+type batchLoader[V any] struct {
+	fetch   func([]int64) (map[int64]V, error)
+	mu      sync.Mutex
+	pending map[int64]struct{}
+	results map[int64]V
+	errors  map[int64]error
+}
+
+func newBatchLoader[V any](fetch func([]int64) (map[int64]V, error)) *batchLoader[V] {
+	return &batchLoader[V]{
+		fetch:   fetch,
+		pending: map[int64]struct{}{},
+		results: map[int64]V{},
+		errors:  map[int64]error{},
+	}
+}
+
+// load schedules id for loading and returns a thunk, which graphql-go
+// resolves after all fields of the current level have been visited.
+func (l *batchLoader[V]) load(id int64) func() (interface{}, error) {
+	l.mu.Lock()
+	if _, ok := l.results[id]; !ok {
+		l.pending[id] = struct{}{}
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if _, ok := l.pending[id]; ok {
+			l.flush()
+		}
+
+		if err, ok := l.errors[id]; ok {
+			return nil, err
+		}
+
+		value, ok := l.results[id]
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	}
+}
+
+func (l *batchLoader[V]) flush() {
+	ids := make([]int64, 0, len(l.pending))
+	for id := range l.pending {
+		ids = append(ids, id)
+	}
+	l.pending = map[int64]struct{}{}
+
+	results, err := l.fetch(ids)
+	for _, id := range ids {
+		if err != nil {
+			l.errors[id] = err
+		} else if value, ok := results[id]; ok {
+			l.results[id] = value
+		}
+	}
+}
+
+type graphQLLoaders struct {
+	users    *batchLoader[*model.User]
+	products *batchLoader[*model.Product]
+	comments *batchLoader[[]*model.Comment]
+	images   *batchLoader[[]*model.Image]
+	ratings  *batchLoader[[]*model.Rating]
+	items    *batchLoader[[]*model.Item]
+	invoices *batchLoader[*model.Invoice]
+}
+
+// newGraphQLLoaders creates the loaders of a single request, whose context is
+// used for all batched DAO calls.
+func newGraphQLLoaders(ctx context.Context, g *graphQLController) *graphQLLoaders {
+	return &graphQLLoaders{
+		users: newBatchLoader(func(ids []int64) (map[int64]*model.User, error) {
+			users, err := g.userDAO.GetByIDs(ctx, ids)
+			return indexByID(users, func(u *model.User) int64 { return u.ID.Int64 }), err
+		}),
+		products: newBatchLoader(func(ids []int64) (map[int64]*model.Product, error) {
+			products, err := g.productDAO.GetByIDs(ctx, ids)
+			return indexByID(products, func(p *model.Product) int64 { return p.ID.Int64 }), err
+		}),
+		comments: newBatchLoader(func(ids []int64) (map[int64][]*model.Comment, error) {
+			comments, err := g.commentDAO.GetByProductIDs(ctx, ids)
+			return groupByID(ids, comments, func(c *model.Comment) int64 { return c.ProductID.Int64 }), err
+		}),
+		images: newBatchLoader(func(ids []int64) (map[int64][]*model.Image, error) {
+			images, err := g.imageDAO.GetByProductIDs(ctx, ids, maxGraphQLImages)
+			return groupByID(ids, images, func(i *model.Image) int64 { return i.ProductID.Int64 }), err
+		}),
+		ratings: newBatchLoader(func(ids []int64) (map[int64][]*model.Rating, error) {
+			ratings, err := g.productDAO.GetRatingsByProductIDs(ctx, ids)
+			return groupByID(ids, ratings, func(r *model.Rating) int64 { return r.ProductID.Int64 }), err
+		}),
+		items: newBatchLoader(func(ids []int64) (map[int64][]*model.Item, error) {
+			items, err := g.itemDAO.GetByOrderIDs(ctx, ids)
+			return groupByID(ids, items, func(i *model.Item) int64 { return i.OrderID.Int64 }), err
+		}),
+		invoices: newBatchLoader(func(ids []int64) (map[int64]*model.Invoice, error) {
+			invoices, err := g.invoiceDAO.GetByOrderIDs(ctx, ids)
+			return indexByID(invoices, func(i *model.Invoice) int64 { return i.Order.ID.Int64 }), err
+		}),
+	}
+}
+
+func getGraphQLLoaders(ctx context.Context) *graphQLLoaders {
+	return GetContextParam[*graphQLLoaders](graphQLLoadersKey, ctx)
+}
+
+func indexByID[V any](values []V, id func(V) int64) map[int64]V {
+	result := make(map[int64]V, len(values))
+	for _, v := range values {
+		result[id(v)] = v
+	}
+	return result
+}
+
+// groupByID groups values by id, with an empty group for every requested ID.
+func groupByID[V any](ids []int64, values []V, id func(V) int64) map[int64][]V {
+	result := make(map[int64][]V, len(ids))
+	for _, i := range ids {
+		result[i] = []V{}
+	}
+	for _, v := range values {
+		result[id(v)] = append(result[id(v)], v)
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vladoiliev02/online-store/ratelimit"
+)
+
+func TestBatchLoader_LoadsPendingIDsOnce(t *testing.T) {
+	var calls [][]int64
+	loader := newBatchLoader(func(ids []int64) (map[int64]string, error) {
+		sorted := append([]int64{}, ids...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		calls = append(calls, sorted)
+		return map[int64]string{1: "one", 2: "two"}, nil
+	})
+
+	first, second, missing := loader.load(1), loader.load(2), loader.load(3)
+
+	if v, err := first(); err != nil || v != "one" {
+		t.Errorf("unexpected result for 1: %v, %v", v, err)
+	}
+	if v, err := second(); err != nil || v != "two" {
+		t.Errorf("unexpected result for 2: %v, %v", v, err)
+	}
+	if v, err := missing(); err != nil || v != nil {
+		t.Errorf("unexpected result for 3: %v, %v", v, err)
+	}
+
+	if v, _ := loader.load(1)(); v != "one" {
+		t.Errorf("expected cached result for 1, got %v", v)
+	}
+
+	if len(calls) != 1 || len(calls[0]) != 3 {
+		t.Errorf("expected a single batch with 3 IDs, got %v", calls)
+	}
+}
+
+func TestGraphQL_SchemaIsValid(t *testing.T) {
+	g := newGraphQLController()
+
+	for _, name := range []string{"Product", "Order", "User", "ProductInput"} {
+		if g.schema.Type(name) == nil {
+			t.Errorf("expected type %s in the schema", name)
+		}
+	}
+
+	// Updates of products are versioned like their REST counterparts.
+	update := g.schema.MutationType().Fields()["updateProduct"]
+	hasVersion := false
+	for _, arg := range update.Args {
+		hasVersion = hasVersion || arg.Name() == "version"
+	}
+	if !hasVersion {
+		t.Error("expected updateProduct to take the version of the product")
+	}
+}
+
+func TestGraphQL_GetOnlyQueries(t *testing.T) {
+	router := newGraphQLRouter()
+
+	for query, code := range map[string]int{
+		`mutation { checkout { id } }`:                                  http.StatusMethodNotAllowed,
+		`query Q { products { count } } mutation M { checkout { id } }`: http.StatusMethodNotAllowed,
+		`{ __typename }`: http.StatusOK,
+	} {
+		values := url.Values{"query": {query}}
+		if strings.Contains(query, "mutation M") {
+			values.Set("operationName", "M")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?"+values.Encode(), nil))
+		if w.Code != code {
+			t.Errorf("expected %d for %q over GET, got %d: %s", code, query, w.Code, w.Body)
+		}
+	}
+}
+
+func TestGraphQL_RateLimitsMutationsAndSearches(t *testing.T) {
+	SetRateLimiter(ratelimit.NewLimiter(ratelimit.NewMemoryStore()), RateLimits{
+		Search: ratelimit.PerMinute(1),
+		Write:  ratelimit.PerMinute(1),
+	})
+	t.Cleanup(func() { SetRateLimiter(nil, RateLimits{}) })
+	router := newGraphQLRouter()
+
+	post := func(query string) int {
+		body := `{"query":` + strconv.Quote(query) + `}`
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return w.Code
+	}
+
+	// The queries select missing fields, so that they fail validation
+	// instead of reaching the database.
+	for _, request := range []struct {
+		query string
+		code  int
+	}{
+		{`mutation { checkout { missing } }`, http.StatusOK},
+		{`mutation { checkout { missing } }`, http.StatusTooManyRequests},
+		{`{ search(name: "lamp") { missing } }`, http.StatusOK},
+		{`{ ...Search } fragment Search on Query { found: search(name: "lamp") { missing } }`, http.StatusTooManyRequests},
+	} {
+		if code := post(request.query); code != request.code {
+			t.Errorf("expected %d for %q, got %d", request.code, request.query, code)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if code := post(`{ __typename }`); code != http.StatusOK {
+			t.Errorf("expected other queries not to be limited, got %d", code)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const identityIdCtxKey = "identityId"
+
+// newIdentityRouter serves the identities of the current user. Identities are
+// linked by logging in with another provider at /login/{provider}.
+func newIdentityRouter() chi.Router {
+	identityController := newIdentityController()
+	r := chi.NewRouter()
+
+	r.Get("/", ControllerHandler(identityController.getAll))
+
+	r.Route("/{identityId}", func(r chi.Router) {
+		r.Use(numericPathVariableExtractor(identityIdCtxKey))
+		r.Delete("/", ControllerHandler(identityController.delete))
+	})
+
+	return r
+}
+
+type identityController struct {
+	identityDAO *dao.IdentityDAO
+}
+
+func newIdentityController() *identityController {
+	return &identityController{
+		identityDAO: dao.NewIdentityDAO(),
+	}
+}
+
+func (i *identityController) getAll(r *http.Request) (*HTTPResponse[[]*model.Identity], error) {
+	identities, err := i.identityDAO.GetByUserID(r.Context(), GetContextParam[int64](UserIDKey, r.Context()))
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get identities", Err: err}
+	}
+
+	return NewOKResponse(identities), nil
+}
+
+func (i *identityController) delete(r *http.Request) (*HTTPResponse[any], error) {
+	id := GetContextParam[int64](identityIdCtxKey, r.Context())
+
+	err := i.identityDAO.Delete(r.Context(), GetContextParam[int64](UserIDKey, r.Context()), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &HTTPError{Code: http.StatusConflict, Message: "Identity not found or the last way of the user to log in", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot unlink identity", Err: err}
+	}
+
+	return NewStatusResponse[any](http.StatusNoContent), nil
+}
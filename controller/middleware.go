@@ -2,12 +2,30 @@ package controller
 
 import (
 	"context"
-	"log"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/ratelimit"
+
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 const (
 	UserIDKey = "userID"
+	// SessionIDKey holds the ID of the session of the current user.
+	SessionIDKey = "sessionID"
+	// TwoFactorKey is true if the current user passed two-factor
+	// authentication when logging in.
+	TwoFactorKey = "twoFactor"
+	// APITokenIDKey holds the ID of the API token a request is authenticated
+	// with, if it is not authenticated with a session.
+	APITokenIDKey = "apiTokenID"
+
+	// readYourWritesCookie holds the time until which the client reads from
+	// the primary database, as Unix seconds.
+	readYourWritesCookie = "read_primary_until"
 )
 
 type CtxKey string
@@ -17,12 +35,11 @@ func numericPathVariableExtractor(varName string) func(next http.Handler) http.H
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			value, err := getNumericPathVariable(r, varName)
 			if err != nil {
-				log.Println(varName + " not found in request path")
 				writeError(&HTTPError{
 					Code:    http.StatusBadRequest,
-					Message: "Invalid path parameter",
+					Message: "Invalid path parameter " + varName,
 					Err:     err,
-				}, w)
+				}, w, r)
 				return
 			}
 			ctx := context.WithValue(r.Context(), CtxKey(varName), value)
@@ -43,3 +60,61 @@ func GetContextParam[T any](varName string, ctx context.Context) T {
 func SetContextParam(varName string, value any, ctx context.Context) context.Context {
 	return context.WithValue(ctx, CtxKey(varName), value)
 }
+
+// RequestTimeout cancels the context of every request after timeout, which
+// aborts the database queries still running for it.
+func RequestTimeout(timeout time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ReadYourWrites routes the reads of a client to the primary database for
+// window after it sent a request that may write, so that it sees its own
+// changes despite replication lag. The deadline is kept in a cookie, which
+// works across instances of the store.
+func ReadYourWrites(window time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				if cookie, err := r.Cookie(readYourWritesCookie); err == nil {
+					if until, err := strconv.ParseInt(cookie.Value, 10, 64); err == nil && time.Now().Unix() < until {
+						r = r.WithContext(dao.WithPrimary(r.Context()))
+					}
+				}
+			default:
+				until := time.Now().Add(window)
+				http.SetCookie(w, &http.Cookie{
+					Name:     readYourWritesCookie,
+					Value:    strconv.FormatInt(until.Unix(), 10),
+					Path:     "/",
+					Expires:  until,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+				r = r.WithContext(dao.WithPrimary(r.Context()))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// auditActor makes the changes of a request be recorded in the audit log as
+// made by its user, from its client.
+func auditActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := dao.WithAuditActor(r.Context(), dao.AuditActor{
+			UserID:    GetContextParam[int64](UserIDKey, r.Context()),
+			RequestID: middleware.GetReqID(r.Context()),
+			IPAddress: ratelimit.ClientIP(r),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
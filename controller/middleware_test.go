@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/dao"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	handler := RequestTimeout(time.Millisecond)(ControllerHandler(func(r *http.Request) (*HTTPResponse[any], error) {
+		<-r.Context().Done()
+		return nil, &dao.DAOError{Query: "SELECT", Message: "Query canceled", Err: r.Context().Err()}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 after the timeout, got %d", w.Code)
+	}
+}
+
+func TestWriteError_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	writeError(&HTTPError{Code: http.StatusNotFound, Message: "Product not found", Err: ctx.Err()}, w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != statusClientClosedRequest {
+		t.Errorf("expected %d for a canceled request, got %d", statusClientClosedRequest, w.Code)
+	}
+}
+
+func TestReadYourWrites_SetsCookieOnWrites(t *testing.T) {
+	handler := ReadYourWrites(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("expected no cookie for a read, got %v", cookies)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != readYourWritesCookie || !cookies[0].Expires.After(time.Now()) {
+		t.Errorf("expected a read-your-writes cookie for a write, got %v", cookies)
+	}
+}
@@ -0,0 +1,617 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	openAPIVersion = "3.0.3"
+	apiVersion     = "1.0.0"
+	apiBasePath    = "/api/v1"
+)
+
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+// apiOperation documents a single route of the API. Request and Response hold
+// a value of the body type, or nil when there is no body. Patch operations
+// accept a merge patch of Request or a JSON Patch instead of Request itself.
+type apiOperation struct {
+	Summary  string
+	Query    []apiParam
+	Request  any
+	Patch    bool
+	Response any
+	Status   int
+}
+
+var pageParams = []apiParam{
+	{"page", "Page number, starting from 1"},
+	{"pageSize", "Number of results per page"},
+}
+
+// apiOperations describes every route registered in Router, keyed by
+// "METHOD /path". Keep it in sync when adding routes.
+var apiOperations = map[string]apiOperation{
+	"GET /products": {
+		Summary: "List available products",
+		Query: append([]apiParam{
+			{"name", "Case-insensitive substring of the product name"},
+			{"categories", "Bitset of product categories"},
+			{"userId", "Only products sold by this user"},
+		}, pageParams...),
+		Response: &productPage{},
+	},
+	"POST /products": {
+		Summary:  "Create a product sold by the current user",
+		Request:  &model.Product{},
+		Response: &model.Product{},
+	},
+	"GET /products/{productId}": {
+		Summary:  "Get a product",
+		Response: &model.Product{},
+	},
+	"PUT /products/{productId}": {
+		Summary:  "Replace a product",
+		Request:  &model.Product{},
+		Response: &model.Product{},
+	},
+	"PATCH /products/{productId}": {
+		Summary:  "Update some fields of a product",
+		Request:  &model.Product{},
+		Patch:    true,
+		Response: &model.Product{},
+	},
+	"PUT /products/{productId}/rating": {
+		Summary:  "Rate a product",
+		Request:  &model.Rating{},
+		Response: &model.Product{},
+	},
+	"GET /products/{productId}/comments": {
+		Summary:  "List the comments of a product",
+		Response: []*model.Comment{},
+	},
+	"POST /products/{productId}/comments": {
+		Summary:  "Comment on a product",
+		Request:  &model.Comment{},
+		Response: &model.Comment{},
+		Status:   http.StatusCreated,
+	},
+	"DELETE /products/{productId}/comments/{commentId}": {
+		Summary: "Delete a comment",
+	},
+	"GET /products/{productId}/images": {
+		Summary:  "List the images of a product",
+		Query:    []apiParam{{"limit", "Maximum number of images, defaults to 1"}},
+		Response: []*model.Image{},
+	},
+	"POST /products/{productId}/images": {
+		Summary:  "Upload a product image",
+		Request:  &model.Image{},
+		Response: &model.Image{},
+	},
+	"DELETE /products/{productId}/images/{imageId}": {
+		Summary: "Delete a product image",
+	},
+	"GET /orders": {
+		Summary:  "List the orders of the current user",
+		Query:    []apiParam{{"status", "Only orders with this status"}},
+		Response: []*model.Order{},
+	},
+	"POST /orders": {
+		Summary:  "Create an order",
+		Request:  &model.Order{},
+		Response: &model.Order{},
+		Status:   http.StatusCreated,
+	},
+	"GET /orders/{orderId}": {
+		Summary:  "Get an order",
+		Response: &model.Order{},
+	},
+	"PUT /orders/{orderId}": {
+		Summary:  "Update an order, e.g. check out the cart",
+		Request:  &model.Order{},
+		Response: &model.Order{},
+	},
+	"PATCH /orders/{orderId}": {
+		Summary:  "Update some fields of an order",
+		Request:  &model.Order{},
+		Patch:    true,
+		Response: &model.Order{},
+	},
+	"GET /orders/{orderId}/invoice": {
+		Summary:  "Get the invoice of an order",
+		Response: &model.Invoice{},
+	},
+	"GET /orders/{orderId}/items": {
+		Summary:  "Get an order together with its items",
+		Response: &model.Order{},
+	},
+	"POST /orders/{orderId}/items": {
+		Summary:  "Add an item to the cart",
+		Request:  &model.Item{},
+		Response: &model.Item{},
+	},
+	"DELETE /orders/{orderId}/items/{itemID}": {
+		Summary: "Remove an item from the cart",
+	},
+	"GET /users": {
+		Summary:  "List users",
+		Response: []*model.User{},
+	},
+	"GET /users/me": {
+		Summary:  "Get the current user",
+		Response: &model.User{},
+	},
+	"DELETE /users/me": {
+		Summary:  "Delete the current user after the grace period, which anonymises the account but keeps its orders and invoices",
+		Status:   http.StatusAccepted,
+		Response: &model.User{},
+	},
+	"DELETE /users/me/deletion": {
+		Summary: "Cancel the scheduled deletion of the current user",
+		Status:  http.StatusNoContent,
+	},
+	"GET /users/me/export": {
+		Summary:  "Download the data kept about the current user",
+		Response: &model.UserExport{},
+	},
+	"GET /users/me/sessions": {
+		Summary:  "List the active sessions of the current user",
+		Response: []*model.Session{},
+	},
+	"DELETE /users/me/sessions": {
+		Summary: "Log the current user out on every device",
+		Status:  http.StatusNoContent,
+	},
+	"DELETE /users/me/sessions/{sessionId}": {
+		Summary: "Revoke a session of the current user",
+		Status:  http.StatusNoContent,
+	},
+	"GET /users/me/identities": {
+		Summary:  "List the identities the current user can log in with",
+		Response: []*model.Identity{},
+	},
+	"DELETE /users/me/identities/{identityId}": {
+		Summary: "Unlink an identity from the current user, except the last one",
+		Status:  http.StatusNoContent,
+	},
+	"GET /users/me/two-factor": {
+		Summary:  "Get the two-factor authentication of the current user",
+		Response: &model.TwoFactor{},
+	},
+	"POST /users/me/two-factor": {
+		Summary:  "Start enrolling an authenticator app, returning its secret and provisioning URI",
+		Status:   http.StatusCreated,
+		Response: &twoFactorEnrolment{},
+	},
+	"POST /users/me/two-factor/confirm": {
+		Summary:  "Enable two-factor authentication with a code of the authenticator app, returning the recovery codes",
+		Request:  &twoFactorCode{},
+		Response: &recoveryCodes{},
+	},
+	"POST /users/me/two-factor/recovery-codes": {
+		Summary:  "Replace the recovery codes, given a code of the authenticator app",
+		Request:  &twoFactorCode{},
+		Response: &recoveryCodes{},
+	},
+	"POST /users/me/two-factor/disable": {
+		Summary: "Disable two-factor authentication with a code, except for administrators",
+		Request: &twoFactorCode{},
+		Status:  http.StatusNoContent,
+	},
+	"GET /users/me/tokens": {
+		Summary:  "List the personal API tokens of the current user",
+		Response: []*model.APIToken{},
+	},
+	"POST /users/me/tokens": {
+		Summary:  "Create a personal API token, which is returned only in this response",
+		Request:  &model.APIToken{},
+		Status:   http.StatusCreated,
+		Response: &createdAPIToken{},
+	},
+	"DELETE /users/me/tokens/{apiTokenId}": {
+		Summary: "Revoke a personal API token of the current user",
+		Status:  http.StatusNoContent,
+	},
+	"GET /users/{id}": {
+		Summary:  "Get a user",
+		Response: &model.User{},
+	},
+	"PUT /users/{id}": {
+		Summary:  "Update the current user",
+		Request:  &model.User{},
+		Response: &model.User{},
+	},
+	"PATCH /users/{id}": {
+		Summary:  "Update some fields of the current user",
+		Request:  &model.User{},
+		Patch:    true,
+		Response: &model.User{},
+	},
+	"GET /admin/audit": {
+		Summary: "List the audit log of privileged and financial changes, newest first, administrators only",
+		Query: append([]apiParam{
+			{"actorId", "Only changes made by this user"},
+			{"entityType", "Only changes of this type of entity: product, order, user, comment or image"},
+			{"entityId", "Only changes of the entity with this id"},
+			{"action", "Only this action, e.g. product.update or order.update"},
+			{"from", "Only changes at or after this RFC 3339 time"},
+			{"to", "Only changes before this RFC 3339 time"},
+		}, pageParams...),
+		Response: &auditPage{},
+	},
+	"GET /admin/health": {
+		Summary:  "Report the health of the database behind the readiness probe, administrators only",
+		Response: &dao.Health{},
+	},
+	"DELETE /admin/users/{id}": {
+		Summary: "Delete a user right away, administrators only",
+		Status:  http.StatusNoContent,
+	},
+	"DELETE /admin/users/{id}/sessions": {
+		Summary: "Log a user out on every device, administrators only",
+		Status:  http.StatusNoContent,
+	},
+	"GET /graphql": {
+		Summary:  "Execute a GraphQL query passed in the query string",
+		Query:    []apiParam{{"query", "GraphQL document"}, {"operationName", "Operation to execute"}, {"variables", "JSON encoded variables"}},
+		Response: map[string]any{},
+	},
+	"POST /graphql": {
+		Summary:  "Execute a GraphQL query or mutation",
+		Request:  &graphQLRequest{},
+		Response: map[string]any{},
+	},
+	"GET /liveness": {
+		Summary: "Liveness probe",
+	},
+	"GET /readiness": {
+		Summary:  "Readiness probe, responds with 503 while the database is unavailable or the server shuts down",
+		Response: &readinessStatus{},
+	},
+	"GET /openapi.json": {
+		Summary: "This document",
+	},
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                               `json:"openapi"`
+	Info       openAPIInfo                          `json:"info"`
+	Servers    []openAPIServer                      `json:"servers"`
+	Paths      map[string]map[string]*openAPIMethod `json:"paths"`
+	Components openAPIComponents                    `json:"components"`
+
+	// componentTypes are the types of the component schemas, so that
+	// types of the same name in different packages get different ones.
+	componentTypes map[string]reflect.Type
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPIMethod struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref         string                    `json:"$ref,omitempty"`
+	Type        string                    `json:"type,omitempty"`
+	Format      string                    `json:"format,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Nullable    bool                      `json:"nullable,omitempty"`
+	Enum        []any                     `json:"enum,omitempty"`
+	Items       *openAPISchema            `json:"items,omitempty"`
+	Properties  map[string]*openAPISchema `json:"properties,omitempty"`
+	// AdditionalProperties is the schema of the values of a map.
+	AdditionalProperties *openAPISchema `json:"additionalProperties,omitempty"`
+}
+
+var (
+	pathParamRegex = regexp.MustCompile(`{([^}]+)}`)
+
+	// Types that do not map to a plain JSON schema by their Go kind.
+	knownSchemas = map[reflect.Type]*openAPISchema{
+		reflect.TypeOf(model.NullInt64JSON{}):   {Type: "integer", Format: "int64", Nullable: true},
+		reflect.TypeOf(model.NullStringJSON{}):  {Type: "string", Nullable: true},
+		reflect.TypeOf(model.NullBoolJSON{}):    {Type: "boolean", Nullable: true},
+		reflect.TypeOf(model.NullFloat64JSON{}): {Type: "number", Format: "double", Nullable: true},
+		reflect.TypeOf(json.RawMessage{}):       {Description: "Any JSON value"},
+		reflect.TypeOf(time.Time{}):             {Type: "string", Format: "date-time"},
+		reflect.TypeOf(model.OrderStatus(0)): {
+			Type:        "integer",
+			Description: "1 - in cart, 2 - in progress, 3 - completed, 4 - canceled",
+			Enum:        []any{model.InCart, model.InProgress, model.Completed, model.Canceled},
+		},
+		reflect.TypeOf(model.Currency(0)): {
+			Type:        "integer",
+			Description: "1 - BGN",
+			Enum:        []any{model.BGN},
+		},
+		reflect.TypeOf(model.ProductCategory(0)): {
+			Type:        "integer",
+			Description: productCategoriesDescription(),
+		},
+	}
+)
+
+// openAPIHandler serves the specification of the routes registered in r. The
+// document is generated on the first request, after all routes are in place.
+func openAPIHandler(r chi.Routes) http.HandlerFunc {
+	var once sync.Once
+	var document []byte
+	var err error
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() {
+			document, err = json.Marshal(newOpenAPIDocument(r))
+		})
+		if err != nil {
+			internalError(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	}
+}
+
+func newOpenAPIDocument(r chi.Routes) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI:    openAPIVersion,
+		Info:       openAPIInfo{Title: "Online Store API", Version: apiVersion},
+		Servers:    []openAPIServer{{URL: apiBasePath}},
+		Paths:      map[string]map[string]*openAPIMethod{},
+		Components: openAPIComponents{Schemas: map[string]*openAPISchema{"HTTPError": httpErrorSchema()}},
+	}
+
+	chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		path := normalizeRoute(route)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*openAPIMethod{}
+		}
+
+		doc.Paths[path][strings.ToLower(method)] = doc.newMethod(path, apiOperations[method+" "+path])
+		return nil
+	})
+
+	return doc
+}
+
+func (doc *openAPIDocument) newMethod(path string, op apiOperation) *openAPIMethod {
+	method := &openAPIMethod{
+		Summary: op.Summary,
+		Responses: map[string]*openAPIResponse{
+			"default": {
+				Description: "Error",
+				Content:     jsonContent(&openAPISchema{Ref: "#/components/schemas/HTTPError"}),
+			},
+		},
+	}
+
+	for _, match := range pathParamRegex.FindAllStringSubmatch(path, -1) {
+		method.Parameters = append(method.Parameters, &openAPIParameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &openAPISchema{Type: "integer", Format: "int64"},
+		})
+	}
+
+	for _, param := range op.Query {
+		method.Parameters = append(method.Parameters, &openAPIParameter{
+			Name:        param.Name,
+			In:          "query",
+			Description: param.Description,
+			Schema:      &openAPISchema{Type: "string"},
+		})
+	}
+
+	if op.Request != nil && op.Patch {
+		method.RequestBody = &openAPIBody{
+			Required: true,
+			Content: map[string]*openAPIMediaType{
+				mergePatchContentType: {Schema: doc.schemaOf(reflect.TypeOf(op.Request))},
+				jsonPatchContentType:  {Schema: &openAPISchema{Type: "array", Items: doc.schemaOf(reflect.TypeOf(jsonPatchOperation{}))}},
+			},
+		}
+	} else if op.Request != nil {
+		method.RequestBody = &openAPIBody{
+			Required: true,
+			Content:  jsonContent(doc.schemaOf(reflect.TypeOf(op.Request))),
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	response := &openAPIResponse{Description: http.StatusText(status)}
+	if op.Response != nil {
+		response.Content = jsonContent(doc.schemaOf(reflect.TypeOf(op.Response)))
+	}
+	method.Responses[strconv.Itoa(status)] = response
+
+	return method
+}
+
+// schemaOf returns the schema of t, registering named structs as components.
+// Anonymous structs are inlined.
+func (doc *openAPIDocument) schemaOf(t reflect.Type) *openAPISchema {
+	if schema, ok := knownSchemas[t]; ok {
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return doc.schemaOf(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: doc.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: doc.schemaOf(t.Elem())}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return doc.structSchema(t)
+		}
+		name := doc.componentName(t)
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			// Register first, so that recursive types terminate.
+			doc.Components.Schemas[name] = &openAPISchema{}
+			*doc.Components.Schemas[name] = *doc.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+// componentName names the schema of the struct t after it, qualified with its
+// package if another type has the name already.
+func (doc *openAPIDocument) componentName(t reflect.Type) string {
+	name := exportedName(t.Name())
+	if other, ok := doc.componentTypes[name]; ok && other != t {
+		name = exportedName(path.Base(t.PkgPath())) + name
+	}
+
+	if doc.componentTypes == nil {
+		doc.componentTypes = map[string]reflect.Type{}
+	}
+	doc.componentTypes[name] = t
+	return name
+}
+
+func exportedName(name string) string {
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (doc *openAPIDocument) structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			// encoding/json promotes the fields of embedded structs.
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for name, property := range doc.structSchema(embedded).Properties {
+					schema.Properties[name] = property
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = doc.schemaOf(field.Type)
+	}
+
+	return schema
+}
+
+func httpErrorSchema() *openAPISchema {
+	return &openAPISchema{
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"code":    {Type: "integer", Format: "int32"},
+			"message": {Type: "string"},
+			"error":   {Description: "Details about the cause, e.g. a list of field validation errors"},
+		},
+	}
+}
+
+func jsonContent(schema *openAPISchema) map[string]*openAPIMediaType {
+	return map[string]*openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// normalizeRoute turns a chi route pattern into an OpenAPI path: chi reports
+// the index route of a mounted router with a trailing slash and wildcards as "/*".
+func normalizeRoute(route string) string {
+	route = strings.ReplaceAll(route, "/*", "")
+	if len(route) > 1 {
+		route = strings.TrimSuffix(route, "/")
+	}
+	return route
+}
+
+func productCategoriesDescription() string {
+	categories := make([]int, 0, len(model.ProductCategories))
+	for category := range model.ProductCategories {
+		categories = append(categories, int(category))
+	}
+	sort.Ints(categories)
+
+	parts := make([]string, 0, len(categories))
+	for _, category := range categories {
+		parts = append(parts, strconv.Itoa(category)+" - "+model.ProductCategories[model.ProductCategory(category)])
+	}
+	return "Bitset of: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vladoiliev02/online-store/dao"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// stubDriver accepts every statement, so that Router can be built without a database.
+type stubDriver struct{}
+
+type stubConn struct{}
+
+type stubStmt struct{}
+
+func (stubDriver) Open(string) (driver.Conn, error)         { return stubConn{}, nil }
+func (stubConn) Prepare(string) (driver.Stmt, error)        { return stubStmt{}, nil }
+func (stubConn) Close() error                               { return nil }
+func (stubConn) Begin() (driver.Tx, error)                  { return nil, driver.ErrSkip }
+func (stubStmt) Close() error                               { return nil }
+func (stubStmt) NumInput() int                              { return -1 }
+func (stubStmt) Exec([]driver.Value) (driver.Result, error) { return driver.ResultNoRows, nil }
+func (stubStmt) Query([]driver.Value) (driver.Rows, error)  { return nil, driver.ErrSkip }
+
+func init() {
+	sql.Register("openapi-stub", stubDriver{})
+	dao.Init(&dao.DBOptions{DriverName: "openapi-stub"})
+}
+
+func TestOpenAPI_DocumentsEveryRoute(t *testing.T) {
+	routes := map[string]bool{}
+	chi.Walk(Router(), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		key := method + " " + normalizeRoute(route)
+		routes[key] = true
+
+		if _, ok := apiOperations[key]; !ok {
+			t.Errorf("route %q is missing from apiOperations", key)
+		}
+		return nil
+	})
+
+	for key := range apiOperations {
+		if !routes[key] {
+			t.Errorf("apiOperations documents %q, which is not a registered route", key)
+		}
+	}
+}
+
+func TestOpenAPI_ServesDocument(t *testing.T) {
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid document: %v", err)
+	}
+
+	if op := doc.Paths["/products/{productId}"]["get"]; op == nil || len(op.Parameters) != 1 || op.Parameters[0].Name != "productId" {
+		t.Errorf("unexpected product operation: %+v", op)
+	}
+
+	product := doc.Components.Schemas["Product"]
+	if product == nil || product.Properties["id"] == nil || !product.Properties["id"].Nullable {
+		t.Fatalf("expected a nullable product id, got %+v", product)
+	}
+
+	if _, ok := product.Properties["Ratings"]; ok {
+		t.Errorf("fields excluded from JSON should not be documented")
+	}
+}
+
+// Health has the name of dao.Health, whose schema it must not replace.
+type Health struct {
+	Up bool `json:"up"`
+}
+
+func TestOpenAPI_SchemaNames(t *testing.T) {
+	doc := &openAPIDocument{Components: openAPIComponents{Schemas: map[string]*openAPISchema{}}}
+
+	inline := doc.schemaOf(reflect.TypeOf(struct {
+		Count int `json:"count"`
+	}{}))
+	if inline.Ref != "" || inline.Properties["count"] == nil {
+		t.Errorf("expected an anonymous struct to be inlined, got %+v", inline)
+	}
+
+	daoHealth, health := doc.schemaOf(reflect.TypeOf(dao.Health{})), doc.schemaOf(reflect.TypeOf(Health{}))
+	if daoHealth.Ref == health.Ref {
+		t.Fatalf("expected types of the same name to get different schemas, got %s", health.Ref)
+	}
+	if again := doc.schemaOf(reflect.TypeOf(Health{})); again.Ref != health.Ref {
+		t.Errorf("expected a type to keep its schema, got %s and %s", health.Ref, again.Ref)
+	}
+	if doc.Components.Schemas["Health"].Properties["ready"] == nil || doc.Components.Schemas["ControllerHealth"].Properties["up"] == nil {
+		t.Errorf("expected both schemas, got %+v", doc.Components.Schemas)
+	}
+}
@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/vladoiliev02/online-store/dao"
@@ -20,6 +22,7 @@ func newOrderRouter() chi.Router {
 		r.Get("/", ControllerHandler(orderController.getByID))
 		r.Get("/invoice", ControllerHandler(orderController.getInvoice))
 		r.Put("/", ControllerHandler(orderController.put))
+		r.Patch("/", ControllerHandler(orderController.patch))
 		r.Mount("/items", newItemRouter(orderController))
 	})
 
@@ -41,18 +44,18 @@ func newOrderController() *orderController {
 func (o *orderController) getByID(r *http.Request) (*HTTPResponse[*model.Order], error) {
 	id := GetContextParam[int64]("orderId", r.Context())
 
-	order, err := o.orderDao.GetByID(id)
+	order, err := o.orderDao.GetByID(r.Context(), id)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Order not found", Err: err}
 	}
 
-	return NewOKResponse(order), nil
+	return conditionalGet(r, order, order.Version), nil
 }
 
 func (o *orderController) getInvoice(r *http.Request) (*HTTPResponse[*model.Invoice], error) {
 	orderId := GetContextParam[int64]("orderId", r.Context())
 
-	invoice, err := o.invoiceDao.GetByOrderID(orderId)
+	invoice, err := o.invoiceDao.GetByOrderID(r.Context(), orderId)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Invoice not found", Err: err}
 	}
@@ -70,9 +73,9 @@ func (o *orderController) getAll(r *http.Request) (*HTTPResponse[[]*model.Order]
 
 	var orders []*model.Order
 	if status == 0 {
-		orders, err = o.orderDao.GetByUserID(userID)
+		orders, err = o.orderDao.GetByUserID(r.Context(), userID)
 	} else {
-		orders, err = o.orderDao.GetByUserIDAndStatus(userID, model.OrderStatus(status))
+		orders, err = o.orderDao.GetByUserIDAndStatus(r.Context(), userID, model.OrderStatus(status))
 	}
 
 	if err != nil {
@@ -87,11 +90,12 @@ func (o *orderController) post(r *http.Request) (*HTTPResponse[*model.Order], er
 		return nil, err
 	}
 
+	model.NormalizeOrder(order)
 	if err := model.ValidateOrder(order, false); err != nil {
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid order", Err: err}
 	}
 
-	order, err = o.orderDao.Create(order)
+	order, err = o.orderDao.Create(r.Context(), order)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Order creation error", Err: err}
 	}
@@ -102,22 +106,74 @@ func (o *orderController) post(r *http.Request) (*HTTPResponse[*model.Order], er
 func (o *orderController) put(r *http.Request) (*HTTPResponse[*model.Order], error) {
 	id := GetContextParam[int64]("orderId", r.Context())
 
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := o.orderDao.GetByID(r.Context(), id)
+	if err != nil || !ownsOrder(r, existing) {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Order not found", Err: err}
+	}
+
 	newOrder, err := jsonUnmarshalBody[model.Order](r)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid order", Err: err}
 	}
 
 	newOrder.ID.Scan(id)
+	newOrder.Version = version
+	model.NormalizeOrder(newOrder)
 	if err := model.ValidateOrder(newOrder, true); err != nil {
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid order", Err: err}
 	}
 
-	result, err := o.orderDao.Update(newOrder)
+	return o.update(r.Context(), newOrder)
+}
+
+func (o *orderController) patch(r *http.Request) (*HTTPResponse[*model.Order], error) {
+	id := GetContextParam[int64]("orderId", r.Context())
+
+	// The order is read with its items, so that patching its status checks
+	// out the cart. The items themselves change through their own routes.
+	existing, err := o.orderDao.GetByIDWithItems(r.Context(), id)
+	if err != nil || !ownsOrder(r, existing) {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Order not found", Err: err}
+	}
+
+	order, err := patchBody(r, existing, existing.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	order.ID = existing.ID
+	order.UserID = existing.UserID
+	order.Version = existing.Version
+	order.Products = existing.Products
+	model.NormalizeOrder(order)
+	if err := model.ValidateOrder(order, true); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid order", Err: err}
+	}
+
+	return o.update(r.Context(), order)
+}
+
+// ownsOrder tells if order belongs to the current user. The orders of others
+// are reported as not found, so that their ids are not revealed.
+func ownsOrder(r *http.Request, order *model.Order) bool {
+	return order.UserID.Int64 == GetContextParam[int64](UserIDKey, r.Context())
+}
+
+func (o *orderController) update(ctx context.Context, order *model.Order) (*HTTPResponse[*model.Order], error) {
+	result, err := o.orderDao.Update(ctx, order)
+	if errors.Is(err, dao.ErrVersionMismatch) {
+		return nil, &HTTPError{Code: http.StatusPreconditionFailed, Message: "Order was modified", Err: err}
+	}
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Order update error", Err: err}
 	}
 
-	return NewOKResponse(result), nil
+	return withETag(NewOKResponse(result), result.Version), nil
 }
 
 func newItemRouter(orderController *orderController) chi.Router {
@@ -153,7 +209,7 @@ func (i *itemController) getAll(r *http.Request) (*HTTPResponse[*model.Order], e
 		return nil, err
 	}
 
-	cart, err := i.orderDAO.LoadItems(cartResponse.Body)
+	cart, err := i.orderDAO.LoadItems(r.Context(), cartResponse.Body)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot load items", Err: err}
 	}
@@ -175,7 +231,7 @@ func (i *itemController) post(r *http.Request) (*HTTPResponse[*model.Item], erro
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid item", Err: err}
 	}
 
-	item, err = i.orderDAO.AddItem(userID, item)
+	item, err = i.orderDAO.AddItem(r.Context(), userID, item)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot add item", Err: err}
 	}
@@ -187,7 +243,7 @@ func (i *itemController) delete(r *http.Request) (*HTTPResponse[any], error) {
 	userID := GetContextParam[int64](UserIDKey, r.Context())
 	itemID := GetContextParam[int64]("itemID", r.Context())
 
-	err := i.orderDAO.RemoveItem(userID, itemID)
+	err := i.orderDAO.RemoveItem(r.Context(), userID, itemID)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot remove item from cart", Err: err}
 	}
@@ -0,0 +1,362 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// jsonPatchOperation is a single operation of an RFC 6902 JSON Patch.
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// patchBody applies the patch in the request body to current and returns the
+// patched copy. The body is either an RFC 7396 merge patch or an RFC 6902 JSON
+// Patch, depending on the Content-Type. If the request has an If-Match header,
+// it must match version.
+func patchBody[T any](r *http.Request, current *T, version int64) (*T, error) {
+	required, err := ifMatchVersion(r)
+	if err != nil {
+		return nil, err
+	}
+	if required != 0 && required != version {
+		return nil, &HTTPError{Code: http.StatusPreconditionFailed, Message: "Resource was modified"}
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != mergePatchContentType && contentType != jsonPatchContentType {
+		return nil, &HTTPError{
+			Code:    http.StatusUnsupportedMediaType,
+			Message: "Expected " + mergePatchContentType + " or " + jsonPatchContentType,
+		}
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid request body", Err: err}
+	}
+
+	document, err := json.Marshal(current)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot apply patch", Err: err}
+	}
+
+	if contentType == mergePatchContentType {
+		document, err = applyMergePatch(document, patch)
+	} else {
+		document, err = applyJSONPatch(document, patch)
+	}
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, errPatchTestFailed) {
+			code = http.StatusConflict
+		}
+		return nil, &HTTPError{Code: code, Message: "Cannot apply patch", Err: err}
+	}
+
+	var patched T
+	if err := json.Unmarshal(document, &patched); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid patched document", Err: err}
+	}
+
+	return &patched, nil
+}
+
+func applyMergePatch(document, patch []byte) ([]byte, error) {
+	var target, merge any
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &merge); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(target, merge))
+}
+
+// mergePatch implements the MergePatch function of RFC 7396: objects are merged
+// recursively, null removes a member and every other value replaces the target.
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+
+	return targetObject
+}
+
+var errPatchTestFailed = errors.New("test operation failed")
+
+func applyJSONPatch(document, patch []byte) ([]byte, error) {
+	var target any
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+
+	var operations []jsonPatchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, err
+	}
+
+	for i, operation := range operations {
+		var err error
+		target, err = applyJSONPatchOperation(target, operation)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, operation.Op, operation.Path, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyJSONPatchOperation(target any, operation jsonPatchOperation) (any, error) {
+	var value any
+	if operation.Op == "add" || operation.Op == "replace" || operation.Op == "test" {
+		if operation.Value == nil {
+			return nil, errors.New("missing value")
+		}
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch operation.Op {
+	case "add":
+		return addValue(target, operation.Path, value)
+	case "remove":
+		target, _, err := removeValue(target, operation.Path)
+		return target, err
+	case "replace":
+		target, _, err := removeValue(target, operation.Path)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(target, operation.Path, value)
+	case "move":
+		if strings.HasPrefix(operation.Path, operation.From+"/") {
+			return nil, errors.New("cannot move a value into itself")
+		}
+		target, moved, err := removeValue(target, operation.From)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(target, operation.Path, moved)
+	case "copy":
+		copied, err := getValue(target, operation.From)
+		if err != nil {
+			return nil, err
+		}
+		// The copy must not share objects and arrays with the original,
+		// which later operations on either would change both.
+		data, err := json.Marshal(copied)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &copied); err != nil {
+			return nil, err
+		}
+		return addValue(target, operation.Path, copied)
+	case "test":
+		actual, err := getValue(target, operation.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(actual, value) {
+			return nil, errPatchTestFailed
+		}
+		return target, nil
+	default:
+		return nil, errors.New("unknown operation")
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("invalid pointer")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > length || (index == length && !allowEnd) || (len(token) > 1 && token[0] == '0') {
+		return 0, errors.New("invalid array index " + token)
+	}
+
+	return index, nil
+}
+
+func getValue(target any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		switch node := target.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, errors.New("no member " + token)
+			}
+			target = value
+		case []any:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			target = node[index]
+		default:
+			return nil, errors.New("cannot traverse into a scalar")
+		}
+	}
+
+	return target, nil
+}
+
+// addValue returns target with value added at pointer, modifying the parent
+// container in place.
+func addValue(target any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := getValue(target, parentPointer)
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return target, nil
+	case []any:
+		index, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node[:index], append([]any{value}, node[index:]...)...)
+		return setValue(target, parentPointer, node)
+	default:
+		return nil, errors.New("cannot add to a scalar")
+	}
+}
+
+// removeValue returns target without the value at pointer, along with the
+// removed value.
+func removeValue(target any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, target, nil
+	}
+
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := getValue(target, parentPointer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, errors.New("no member " + last)
+		}
+		delete(node, last)
+		return target, value, nil
+	case []any:
+		index, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[index]
+		node = append(node[:index:index], node[index+1:]...)
+		target, err = setValue(target, parentPointer, node)
+		return target, value, err
+	default:
+		return nil, nil, errors.New("cannot remove from a scalar")
+	}
+}
+
+// setValue replaces the existing value at pointer. Arrays change length when
+// values are added or removed, so their parent has to be updated as well.
+func setValue(target any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := getValue(target, pointer[:strings.LastIndex(pointer, "/")])
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+	case []any:
+		index, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = value
+	}
+
+	return target, nil
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+func assertJSONEqual(t *testing.T, actual []byte, expected string) {
+	t.Helper()
+
+	var a, e any
+	if err := json.Unmarshal(actual, &a); err != nil {
+		t.Fatalf("invalid JSON %s: %v", actual, err)
+	}
+	json.Unmarshal([]byte(expected), &e)
+	if !reflect.DeepEqual(a, e) {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	// Examples from appendix A of RFC 7396.
+	tests := []struct{ target, patch, expected string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	}
+
+	for _, test := range tests {
+		result, err := applyMergePatch([]byte(test.target), []byte(test.patch))
+		if err != nil {
+			t.Fatalf("%s + %s: %v", test.target, test.patch, err)
+		}
+		assertJSONEqual(t, result, test.expected)
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	target := `{"name":"a","tags":["x","y"],"address":{"city":"Sofia"}}`
+	patch := `[
+		{"op":"test","path":"/name","value":"a"},
+		{"op":"replace","path":"/name","value":"b"},
+		{"op":"add","path":"/tags/1","value":"z"},
+		{"op":"remove","path":"/tags/0"},
+		{"op":"add","path":"/tags/-","value":"w"},
+		{"op":"copy","from":"/address/city","path":"/city"},
+		{"op":"move","from":"/address","path":"/location"}
+	]`
+
+	result, err := applyJSONPatch([]byte(target), []byte(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSONEqual(t, result, `{"name":"b","tags":["z","y","w"],"city":"Sofia","location":{"city":"Sofia"}}`)
+
+	// Changing a copy leaves the original as it was.
+	result, err = applyJSONPatch([]byte(target), []byte(`[
+		{"op":"copy","from":"/address","path":"/billing"},
+		{"op":"add","path":"/billing/city","value":"Varna"},
+		{"op":"copy","from":"/tags","path":"/labels"},
+		{"op":"replace","path":"/labels/0","value":"q"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSONEqual(t, result, `{"name":"a","tags":["x","y"],"labels":["q","y"],"address":{"city":"Sofia"},"billing":{"city":"Varna"}}`)
+
+	_, err = applyJSONPatch([]byte(target), []byte(`[{"op":"test","path":"/name","value":"b"}]`))
+	if !errors.Is(err, errPatchTestFailed) {
+		t.Errorf("expected a failed test, got %v", err)
+	}
+
+	_, err = applyJSONPatch([]byte(target), []byte(`[{"op":"remove","path":"/missing"}]`))
+	if err == nil {
+		t.Errorf("expected an error when removing a missing member")
+	}
+}
+
+func TestPatchBody(t *testing.T) {
+	current := &model.Product{}
+	current.Name.Scan("old")
+	current.Description.Scan("description")
+
+	r := httptest.NewRequest(http.MethodPatch, "/products/1", strings.NewReader(`{"name":"new"}`))
+	r.Header.Set("Content-Type", mergePatchContentType)
+	patched, err := patchBody(r, current, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Name.String != "new" || patched.Description.String != "description" || current.Name.String != "old" {
+		t.Errorf("unexpected patch result %+v", patched)
+	}
+
+	r = httptest.NewRequest(http.MethodPatch, "/products/1", strings.NewReader(`{"name":"new"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if _, err := patchBody(r, current, 2); err == nil || err.(*HTTPError).Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415, got %v", err)
+	}
+
+	r = httptest.NewRequest(http.MethodPatch, "/products/1", strings.NewReader(`{"name":"new"}`))
+	r.Header.Set("Content-Type", mergePatchContentType)
+	r.Header.Set("If-Match", `"1"`)
+	if _, err := patchBody(r, current, 2); err == nil || err.(*HTTPError).Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412, got %v", err)
+	}
+}
+
+func TestPatch_OnlyOwnersChange(t *testing.T) {
+	ctx := SetContextParam(UserIDKey, int64(7), context.Background())
+	r := httptest.NewRequest(http.MethodPatch, "/orders/1", nil).WithContext(ctx)
+
+	product, order := &model.Product{}, &model.Order{}
+	product.UserID.Scan(int64(7))
+	order.UserID.Scan(int64(7))
+	if err := checkSeller(ctx, product); err != nil || !ownsOrder(r, order) {
+		t.Errorf("expected the owner to be allowed, got %v", err)
+	}
+
+	product.UserID.Scan(int64(8))
+	order.UserID.Scan(int64(8))
+	if err := checkSeller(ctx, product); err == nil || err.(*HTTPError).Code != http.StatusForbidden {
+		t.Errorf("expected 403 for the product of another seller, got %v", err)
+	}
+	if ownsOrder(r, order) {
+		t.Error("expected the order of another user not to be owned")
+	}
+}
@@ -1,10 +1,12 @@
 package controller
 
 import (
-	"log"
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/logging"
 	"github.com/vladoiliev02/online-store/model"
 
 	"github.com/go-chi/chi/v5"
@@ -20,14 +22,15 @@ func newProductRouter() chi.Router {
 	productController := newProductController()
 	r := chi.NewRouter()
 
-	r.Get("/", ControllerHandler(productController.getAll))
+	r.With(rateLimit("search", rateLimits.Search)).Get("/", ControllerHandler(productController.getAll))
 	r.Post("/", ControllerHandler(productController.post))
 
 	r.Route("/{productId}", func(r chi.Router) {
 		r.Use(numericPathVariableExtractor(productIdCtxKey))
 		r.Get("/", ControllerHandler(productController.getById))
 		r.Put("/", ControllerHandler(productController.put))
-		r.Patch("/", ControllerHandler(productController.rateProduct))
+		r.Patch("/", ControllerHandler(productController.patch))
+		r.Put("/rating", ControllerHandler(productController.rateProduct))
 		r.Mount("/comments", newCommentRouter())
 		r.Mount("/images", newImageRouter())
 	})
@@ -35,6 +38,11 @@ func newProductRouter() chi.Router {
 	return r
 }
 
+type productPage struct {
+	Products []*model.Product `json:"products"`
+	Count    int64            `json:"count"`
+}
+
 type productController struct {
 	productDAO *dao.ProductDAO
 }
@@ -48,15 +56,15 @@ func newProductController() *productController {
 func (p *productController) getById(r *http.Request) (*HTTPResponse[*model.Product], error) {
 	id := GetContextParam[int64](productIdCtxKey, r.Context())
 
-	product, err := p.productDAO.GetByID(id)
+	product, err := p.productDAO.GetByID(r.Context(), id)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Product not found", Err: err}
 	}
 
-	return NewOKResponse(product), nil
+	return conditionalGet(r, product, product.Version), nil
 }
 
-func (p *productController) getAll(r *http.Request) (*HTTPResponse[any], error) {
+func (p *productController) getAll(r *http.Request) (*HTTPResponse[*productPage], error) {
 	page, pageSize, err := getPageAndPageSize(r)
 	if err != nil {
 		return nil, err
@@ -80,22 +88,24 @@ func (p *productController) getAll(r *http.Request) (*HTTPResponse[any], error)
 	}
 
 	if userID != 0 {
-		result, count, err = p.productDAO.GetByUserID(userID, page, pageSize)
+		ctx := r.Context()
+		if userID == GetContextParam[int64](UserIDKey, ctx) {
+			// Sellers expect to see the products they just listed.
+			ctx = dao.WithPrimary(ctx)
+		}
+		result, count, err = p.productDAO.GetByUserID(ctx, userID, page, pageSize)
 	} else if name != "" {
-		log.Println("searching by name:", name)
-		result, count, err = p.productDAO.GetByNameLike(name, page, pageSize, model.ProductCategory(category))
+		logging.FromContext(r.Context()).Debug("searching products by name", "name", name)
+		result, count, err = p.productDAO.GetByNameLike(r.Context(), name, page, pageSize, model.ProductCategory(category))
 	} else {
-		result, count, err = p.productDAO.GetAll(page, pageSize, model.ProductCategory(category))
+		result, count, err = p.productDAO.GetAll(r.Context(), page, pageSize, model.ProductCategory(category))
 	}
 
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Products not found", Err: err}
 	}
 
-	return NewOKResponse[any](struct {
-		Products []*model.Product `json:"products"`
-		Count    int64            `json:"count"`
-	}{
+	return NewOKResponse(&productPage{
 		Products: result,
 		Count:    count,
 	}), nil
@@ -110,6 +120,7 @@ func (p *productController) post(r *http.Request) (*HTTPResponse[*model.Product]
 	userID := GetContextParam[int64](UserIDKey, r.Context())
 
 	product.UserID.Scan(userID)
+	model.NormalizeProduct(product)
 	err = model.ValidateProduct(product, false)
 	if err != nil {
 		return nil, &HTTPError{
@@ -119,7 +130,7 @@ func (p *productController) post(r *http.Request) (*HTTPResponse[*model.Product]
 		}
 	}
 
-	product, err = p.productDAO.Create(product)
+	product, err = p.productDAO.Create(r.Context(), product)
 	if err != nil {
 		return nil, &HTTPError{
 			Code:    http.StatusInternalServerError,
@@ -134,23 +145,80 @@ func (p *productController) post(r *http.Request) (*HTTPResponse[*model.Product]
 func (p *productController) put(r *http.Request) (*HTTPResponse[*model.Product], error) {
 	id := GetContextParam[int64](productIdCtxKey, r.Context())
 
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := p.productDAO.GetByID(r.Context(), id)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Product not found", Err: err}
+	}
+	if err := checkSeller(r.Context(), existing); err != nil {
+		return nil, err
+	}
+
 	product, err := jsonUnmarshalBody[model.Product](r)
 	if err != nil {
 		return nil, err
 	}
 
 	product.ID.Scan(id)
+	product.Version = version
+	model.NormalizeProduct(product)
 	err = model.ValidateProduct(product, true)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid product", Err: err}
 	}
 
-	product, err = p.productDAO.Update(product)
+	return p.update(r.Context(), product)
+}
+
+// checkSeller refuses changes of a product by anyone but its seller.
+func checkSeller(ctx context.Context, product *model.Product) error {
+	if product.UserID.Int64 != GetContextParam[int64](UserIDKey, ctx) {
+		return &HTTPError{Code: http.StatusForbidden, Message: "Only the seller can update a product"}
+	}
+	return nil
+}
+
+func (p *productController) update(ctx context.Context, product *model.Product) (*HTTPResponse[*model.Product], error) {
+	product, err := p.productDAO.Update(ctx, product)
+	if errors.Is(err, dao.ErrVersionMismatch) {
+		return nil, &HTTPError{Code: http.StatusPreconditionFailed, Message: "Product was modified", Err: err}
+	}
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Could not update product", Err: err}
 	}
 
-	return NewOKResponse(product), nil
+	return withETag(NewOKResponse(product), product.Version), nil
+}
+
+func (p *productController) patch(r *http.Request) (*HTTPResponse[*model.Product], error) {
+	id := GetContextParam[int64](productIdCtxKey, r.Context())
+
+	existing, err := p.productDAO.GetByID(r.Context(), id)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Product not found", Err: err}
+	}
+	if err := checkSeller(r.Context(), existing); err != nil {
+		return nil, err
+	}
+
+	product, err := patchBody(r, existing, existing.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	product.ID = existing.ID
+	product.UserID = existing.UserID
+	product.Version = existing.Version
+	model.NormalizeProduct(product)
+	if err := model.ValidateProduct(product, true); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid product", Err: err}
+	}
+
+	return p.update(r.Context(), product)
 }
 
 func (p *productController) rateProduct(r *http.Request) (*HTTPResponse[*model.Product], error) {
@@ -168,7 +236,7 @@ func (p *productController) rateProduct(r *http.Request) (*HTTPResponse[*model.P
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid rating", Err: err}
 	}
 
-	product, err := p.productDAO.AddRating(rating)
+	product, err := p.productDAO.AddRating(r.Context(), rating)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot add rating", Err: err}
 	}
@@ -204,7 +272,7 @@ func newCommentController() *commentController {
 func (c *commentController) getAll(r *http.Request) (*HTTPResponse[[]*model.Comment], error) {
 	productId := GetContextParam[int64](productIdCtxKey, r.Context())
 
-	comments, err := c.commentDAO.GetByProductID(productId)
+	comments, err := c.commentDAO.GetByProductID(r.Context(), productId)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Comments not found", Err: err}
 	}
@@ -222,11 +290,12 @@ func (c *commentController) post(r *http.Request) (*HTTPResponse[*model.Comment]
 	comment.User.ID.Scan(GetContextParam[int64](UserIDKey, r.Context()))
 	comment.ProductID.Scan(productId)
 
+	model.NormalizeComment(comment)
 	if err := model.ValidateComment(comment, false); err != nil {
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid comment", Err: err}
 	}
 
-	comment, err = c.commentDAO.Create(comment)
+	comment, err = c.commentDAO.Create(r.Context(), comment)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot create comment", Err: err}
 	}
@@ -237,7 +306,7 @@ func (c *commentController) post(r *http.Request) (*HTTPResponse[*model.Comment]
 func (c *commentController) delete(r *http.Request) (*HTTPResponse[any], error) {
 	id := GetContextParam[int64](commentIdCtxKey, r.Context())
 
-	err := c.commentDAO.Delete(id)
+	err := c.commentDAO.Delete(r.Context(), id)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot delete comment", Err: err}
 	}
@@ -295,7 +364,7 @@ func (i *imageController) getByProductId(r *http.Request) (*HTTPResponse[[]*mode
 		limit = 1
 	}
 
-	images, err := i.imageDAO.GetByProductID(productId, limit)
+	images, err := i.imageDAO.GetByProductID(r.Context(), productId, limit)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Cannot find images", Err: err}
 	}
@@ -316,7 +385,7 @@ func (i *imageController) post(r *http.Request) (*HTTPResponse[*model.Image], er
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid image", Err: err}
 	}
 
-	image, err = i.imageDAO.Create(image)
+	image, err = i.imageDAO.Create(r.Context(), image)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot save image", Err: err}
 	}
@@ -327,7 +396,7 @@ func (i *imageController) post(r *http.Request) (*HTTPResponse[*model.Image], er
 func (i *imageController) delete(r *http.Request) (*HTTPResponse[any], error) {
 	id := GetContextParam[int64](imageIdCtxKey, r.Context())
 
-	err := i.imageDAO.Delete(id)
+	err := i.imageDAO.Delete(r.Context(), id)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Cannot delete image", Err: err}
 	}
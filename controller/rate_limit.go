@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/vladoiliev02/online-store/ratelimit"
+)
+
+// RateLimits are the limits of every user, or IP address for anonymous
+// requests. A zero limit is disabled.
+type RateLimits struct {
+	// API limits every request.
+	API ratelimit.Limit
+	// Search limits the listing of products, which searches them by name,
+	// and the GraphQL queries that search them.
+	Search ratelimit.Limit
+	// Write limits the requests that change something, such as comments,
+	// GraphQL mutations included.
+	Write ratelimit.Limit
+}
+
+var (
+	rateLimiter *ratelimit.Limiter
+	rateLimits  RateLimits
+)
+
+// SetRateLimiter limits the requests to the API. It has to be called before
+// Router.
+func SetRateLimiter(limiter *ratelimit.Limiter, limits RateLimits) {
+	rateLimiter, rateLimits = limiter, limits
+}
+
+// rateLimit limits the requests of group, per user if they are logged in.
+func rateLimit(group string, limit ratelimit.Limit) func(http.Handler) http.Handler {
+	return rateLimiter.Middleware(group, limit, rateLimitKey, func(w http.ResponseWriter, r *http.Request) {
+		writeError(&HTTPError{Code: http.StatusTooManyRequests, Message: "Too many requests, please try again later"}, w, r)
+	})
+}
+
+func rateLimitKey(r *http.Request) string {
+	if userID := GetContextParam[int64](UserIDKey, r.Context()); userID != 0 {
+		return "user:" + strconv.FormatInt(userID, 10)
+	}
+	return "ip:" + ratelimit.ClientIP(r)
+}
+
+// rateLimitWrites limits the requests with unsafe methods.
+func rateLimitWrites(next http.Handler) http.Handler {
+	limited := rateLimit("write", rateLimits.Write)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			limited.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,358 @@
+package security
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vladoiliev02/online-store/controller"
+	"github.com/vladoiliev02/online-store/logging"
+	"github.com/vladoiliev02/online-store/model"
+	"github.com/vladoiliev02/online-store/ratelimit"
+)
+
+const (
+	verifyEmailPurpose   = "verify_email"
+	resetPasswordPurpose = "reset_password"
+
+	registerPath       = "/account/register"
+	verifyEmailPath    = "/account/verify"
+	passwordLoginPath  = "/account/login"
+	forgotPasswordPath = "/account/password/forgot"
+	resetPasswordPath  = "/account/password/reset"
+	resetPasswordPage  = "/store/reset-password"
+
+	maxAccountRequestBytes = 4096
+)
+
+// AccountConfiguration configures local accounts, which log in with an email
+// and a password instead of an identity provider.
+type AccountConfiguration struct {
+	// BaseURL is the public URL of the store, which the links in emails
+	// point to.
+	BaseURL string
+	Mailer  Mailer
+	// MaxFailedLogins in a row lock an account for LockoutDuration.
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+	// VerificationTTL and ResetTTL are how long the emailed links are valid.
+	VerificationTTL time.Duration
+	ResetTTL        time.Duration
+	// RateLimiter limits the login attempts of every IP address to
+	// LoginRateLimit, logins with providers included. It may be nil.
+	RateLimiter    *ratelimit.Limiter
+	LoginRateLimit ratelimit.Limit
+}
+
+type passwordLogin struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type passwordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type passwordReset struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// register creates a local account and emails a link verifying its email.
+// Whether the email has an account already is not revealed, its owner is
+// told by email to reset their password instead.
+func (sc *SecurityConfiguration) register(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	registration, err := decodeAccountRequest[model.Registration](w, r)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+	registration.Email.String = normalizeEmail(registration.Email.String)
+	if err := model.ValidateRegistration(registration); err != nil {
+		controller.WriteError(&controller.HTTPError{Code: http.StatusBadRequest, Message: "Invalid registration", Err: err}, w, r)
+		return
+	}
+	email := registration.Email.String
+
+	// The password is hashed either way, so that the response time does not
+	// tell whether the email has an account.
+	passwordHash, err := hashPassword(registration.Password.String)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+
+	_, err = sc.userDAO.GetByEmail(ctx, email)
+	switch {
+	case err == nil:
+		sc.sendMail(ctx, email, "Your account at the store",
+			"Someone tried to create an account with your email, which has one already.\n"+
+				"If it was you, you can set a password at "+sc.accountURL("/store/login")+".")
+	case errors.Is(err, sql.ErrNoRows):
+		user := &model.User{}
+		user.FirstName.Scan(strings.TrimSpace(registration.FirstName.String))
+		user.LastName.Scan(strings.TrimSpace(registration.LastName.String))
+		user.Name.Scan(user.FirstName.String + " " + user.LastName.String)
+		user.PictureURL.Scan("")
+		user.Email.Scan(email)
+
+		user, err = sc.credentialDAO.CreateWithUser(ctx, user, &model.Credential{PasswordHash: passwordHash})
+		if err != nil {
+			controller.WriteError(&controller.HTTPError{Code: http.StatusInternalServerError, Message: "Cannot create account", Err: err}, w, r)
+			return
+		}
+
+		logging.FromContext(ctx).Info("account registered", "user_id", user.ID.Int64)
+		sc.sendVerification(ctx, user.ID.Int64, email)
+	default:
+		controller.WriteError(&controller.HTTPError{Code: http.StatusInternalServerError, Message: "Cannot create account", Err: err}, w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verifyEmail follows a verification link and redirects to the login page,
+// telling it whether the link was valid.
+func (sc *SecurityConfiguration) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, err := sc.accountTokenDAO.Consume(ctx, verifyEmailPurpose, hashToken(r.URL.Query().Get("token")), time.Now().UTC())
+	if err == nil {
+		err = sc.credentialDAO.Verify(ctx, userID)
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logging.FromContext(ctx).Error("cannot verify email", "error", err)
+		}
+		http.Redirect(w, r, "/store/login?verified=false", http.StatusSeeOther)
+		return
+	}
+
+	logging.FromContext(ctx).Info("email verified", "user_id", userID)
+	http.Redirect(w, r, "/store/login?verified=true", http.StatusSeeOther)
+}
+
+// passwordLogin logs a user in with the email and password of their local
+// account. The account is locked after too many failed attempts, which is
+// only told to someone who knows the password.
+func (sc *SecurityConfiguration) passwordLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	invalid := &controller.HTTPError{Code: http.StatusUnauthorized, Message: "Invalid email or password"}
+
+	login, err := decodeAccountRequest[passwordLogin](w, r)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+
+	credential, err := sc.credentialDAO.GetByEmail(ctx, normalizeEmail(login.Email))
+	if errors.Is(err, sql.ErrNoRows) {
+		verifyPassword(login.Password, dummyPasswordHash())
+		controller.WriteError(invalid, w, r)
+		return
+	}
+	if err != nil {
+		controller.WriteError(&controller.HTTPError{Code: http.StatusInternalServerError, Message: "Cannot log in", Err: err}, w, r)
+		return
+	}
+	userID := credential.UserID.Int64
+
+	now := time.Now().UTC()
+	locked := credential.LockedUntil != nil && now.Before(*credential.LockedUntil)
+
+	matches, err := verifyPassword(login.Password, credential.PasswordHash)
+	if err != nil {
+		controller.WriteError(&controller.HTTPError{Code: http.StatusInternalServerError, Message: "Cannot log in", Err: err}, w, r)
+		return
+	}
+	if !matches {
+		if !locked {
+			if err := sc.credentialDAO.RecordFailure(ctx, userID, sc.accounts.MaxFailedLogins, now.Add(sc.accounts.LockoutDuration)); err != nil {
+				logging.FromContext(ctx).Error("cannot record failed login", "user_id", userID, "error", err)
+			}
+		}
+		logging.FromContext(ctx).Warn("failed login", "user_id", userID)
+		controller.WriteError(invalid, w, r)
+		return
+	}
+
+	if locked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(credential.LockedUntil.Sub(now).Seconds())+1))
+		controller.WriteError(&controller.HTTPError{Code: http.StatusForbidden, Message: "Account locked after too many failed logins, try again later or reset the password"}, w, r)
+		return
+	}
+
+	if !credential.EmailVerified {
+		sc.sendVerification(ctx, userID, normalizeEmail(login.Email))
+		controller.WriteError(&controller.HTTPError{Code: http.StatusForbidden, Message: "Email not verified, a new verification link was sent"}, w, r)
+		return
+	}
+
+	if err := sc.credentialDAO.RecordSuccess(ctx, userID); err != nil {
+		logging.FromContext(ctx).Warn("cannot reset failed logins", "user_id", userID, "error", err)
+	}
+
+	session, err := sc.store.Get(r, sessionName)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+	pending, err := sc.firstFactorPassed(w, r, session, userID)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+	if pending {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(&twoFactorRequired{TwoFactorRequired: true, Next: twoFactorPage})
+		return
+	}
+
+	logging.FromContext(ctx).Info("user logged in", "user_id", userID, "provider", "password")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forgotPassword emails a link for setting a new password. Users that log in
+// with an identity provider can set a password this way as well. Whether the
+// email has an account is not revealed.
+func (sc *SecurityConfiguration) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	request, err := decodeAccountRequest[passwordResetRequest](w, r)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+	email := normalizeEmail(request.Email)
+
+	user, err := sc.userDAO.GetByEmail(ctx, email)
+	if err == nil {
+		token, err := sc.createAccountToken(ctx, user.ID.Int64, resetPasswordPurpose, sc.accounts.ResetTTL)
+		if err != nil {
+			logging.FromContext(ctx).Error("cannot create password reset token", "user_id", user.ID.Int64, "error", err)
+		} else {
+			sc.sendMail(ctx, email, "Reset your password",
+				"Set a new password at "+sc.accountURL(resetPasswordPage+"?token="+url.QueryEscape(token))+"\n"+
+					"The link is valid for "+sc.accounts.ResetTTL.String()+". If you did not ask for it, ignore this email.")
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		logging.FromContext(ctx).Error("cannot look up user", "error", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// resetPassword sets a new password with the token of a reset link. The link
+// proves that the user owns their email, which is thereby verified, and all
+// their sessions are revoked.
+func (sc *SecurityConfiguration) resetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	reset, err := decodeAccountRequest[passwordReset](w, r)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+	if err := model.ValidatePassword(reset.Password); err != nil {
+		controller.WriteError(&controller.HTTPError{Code: http.StatusBadRequest, Message: "Invalid password", Err: err}, w, r)
+		return
+	}
+
+	passwordHash, err := hashPassword(reset.Password)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+
+	userID, err := sc.accountTokenDAO.Consume(ctx, resetPasswordPurpose, hashToken(reset.Token), time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		controller.WriteError(&controller.HTTPError{Code: http.StatusBadRequest, Message: "Invalid or expired password reset link"}, w, r)
+		return
+	}
+	if err != nil {
+		controller.WriteError(&controller.HTTPError{Code: http.StatusInternalServerError, Message: "Cannot reset password", Err: err}, w, r)
+		return
+	}
+
+	if err := sc.credentialDAO.SetPassword(ctx, userID, passwordHash); err != nil {
+		controller.WriteError(&controller.HTTPError{Code: http.StatusInternalServerError, Message: "Cannot reset password", Err: err}, w, r)
+		return
+	}
+	if err := sc.store.RevokeAll(ctx, userID); err != nil {
+		logging.FromContext(ctx).Error("cannot revoke sessions", "user_id", userID, "error", err)
+	}
+
+	logging.FromContext(ctx).Info("password reset", "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sc *SecurityConfiguration) sendVerification(ctx context.Context, userID int64, email string) {
+	token, err := sc.createAccountToken(ctx, userID, verifyEmailPurpose, sc.accounts.VerificationTTL)
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot create verification token", "user_id", userID, "error", err)
+		return
+	}
+
+	sc.sendMail(ctx, email, "Verify your email",
+		"Verify your email at "+sc.accountURL(verifyEmailPath+"?token="+url.QueryEscape(token))+"\n"+
+			"The link is valid for "+sc.accounts.VerificationTTL.String()+".")
+}
+
+// createAccountToken creates a one-time token, of which only the hash is
+// stored.
+func (sc *SecurityConfiguration) createAccountToken(ctx context.Context, userID int64, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	accountToken := &model.AccountToken{Purpose: purpose, TokenHash: hashToken(token), ExpiresAt: time.Now().UTC().Add(ttl)}
+	accountToken.UserID.Scan(userID)
+	if _, err := sc.accountTokenDAO.Create(ctx, accountToken); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendMail logs failures, which are not told to the client so that it cannot
+// learn whether an email has an account.
+func (sc *SecurityConfiguration) sendMail(ctx context.Context, to, subject, body string) {
+	if err := sc.accounts.Mailer.Send(ctx, to, subject, body); err != nil {
+		logging.FromContext(ctx).Error("cannot send email", "subject", subject, "error", err)
+	}
+}
+
+func (sc *SecurityConfiguration) accountURL(path string) string {
+	return strings.TrimSuffix(sc.accounts.BaseURL, "/") + path
+}
+
+// decodeAccountRequest reads a JSON request body. Requiring the JSON
+// Content-Type keeps other sites from posting to the account routes, which
+// have no CSRF token, since browsers only send it cross-site after a CORS
+// preflight.
+func decodeAccountRequest[T any](w http.ResponseWriter, r *http.Request) (*T, error) {
+	if contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); contentType != "application/json" {
+		return nil, &controller.HTTPError{Code: http.StatusUnsupportedMediaType, Message: "Expected application/json"}
+	}
+
+	var request T
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAccountRequestBytes)).Decode(&request); err != nil {
+		return nil, &controller.HTTPError{Code: http.StatusBadRequest, Message: "Invalid json in request body", Err: err}
+	}
+	return &request, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
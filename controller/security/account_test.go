@@ -0,0 +1,235 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vladoiliev02/online-store/controller"
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type sentEmail struct {
+	to, subject, body string
+}
+
+// recordingMailer keeps the emails it is asked to send.
+type recordingMailer struct {
+	mu     sync.Mutex
+	emails []sentEmail
+}
+
+func (m *recordingMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emails = append(m.emails, sentEmail{to, subject, body})
+	return nil
+}
+
+var linkRegex = regexp.MustCompile(`http://store\.test(\S+)`)
+
+// lastLink returns the path of the link in the last email sent to an address.
+func (m *recordingMailer) lastLink(t *testing.T, to string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.emails) - 1; i >= 0; i-- {
+		if m.emails[i].to == to {
+			if match := linkRegex.FindStringSubmatch(m.emails[i].body); match != nil {
+				return match[1]
+			}
+		}
+	}
+	t.Fatalf("expected an email with a link to %s, got %+v", to, m.emails)
+	return ""
+}
+
+func newAccountTestRouter(mailer Mailer) chi.Router {
+	router := newTestSecurityWithMailer(mailer)
+	router.Get("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strconv.FormatInt(controller.GetContextParam[int64](controller.UserIDKey, r.Context()), 10)))
+	})
+	return router
+}
+
+func postJSON(router chi.Router, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func passwordLoginAs(router chi.Router, email, password string) *httptest.ResponseRecorder {
+	return postJSON(router, passwordLoginPath, `{"email":"`+email+`","password":"`+password+`"}`)
+}
+
+func createVerifiedAccount(t *testing.T, email, password string) int64 {
+	t.Helper()
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &model.User{}
+	user.Name.Scan("Local User")
+	user.FirstName.Scan("Local")
+	user.LastName.Scan("User")
+	user.PictureURL.Scan("")
+	user.Email.Scan(email)
+	user, err = dao.NewCredentialDAO().CreateWithUser(context.Background(), user, &model.Credential{PasswordHash: hash, EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID.Int64
+}
+
+func TestPasswordAccount_RegisterVerifyLogin(t *testing.T) {
+	mailer := &recordingMailer{}
+	router := newAccountTestRouter(mailer)
+
+	w := postJSON(router, registerPath, `{"email":" Buyer@Example.com ","password":"correct horse","firstName":"Test","lastName":"Buyer"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected the registration to be accepted, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := passwordLoginAs(router, "buyer@example.com", "correct horse"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected an unverified email to be refused, got %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, mailer.lastLink(t, "buyer@example.com"), nil))
+	if location := w.Header().Get("Location"); location != "/store/login?verified=true" {
+		t.Fatalf("expected the email to be verified, got %d to %q", w.Code, location)
+	}
+
+	w = passwordLoginAs(router, "buyer@example.com", "correct horse")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected to be logged in, got %d: %s", w.Code, w.Body)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	credential, err := dao.NewCredentialDAO().GetByEmail(context.Background(), "buyer@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != strconv.FormatInt(credential.UserID.Int64, 10) {
+		t.Errorf("expected the session of user %d, got %d: %s", credential.UserID.Int64, w.Code, w.Body)
+	}
+
+	if w := postJSON(router, registerPath, `{"email":"buyer@example.com","password":"another one","firstName":"Test","lastName":"Buyer"}`); w.Code != http.StatusAccepted {
+		t.Errorf("expected a registration of a taken email to look accepted, got %d", w.Code)
+	}
+	if w := passwordLoginAs(router, "buyer@example.com", "another one"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the taken email to keep its password, got %d", w.Code)
+	}
+}
+
+func TestPasswordAccount_LocksAfterFailedLogins(t *testing.T) {
+	router := newAccountTestRouter(LogMailer{})
+	createVerifiedAccount(t, "locked@example.com", "correct horse")
+
+	for i := 0; i < 3; i++ {
+		if w := passwordLoginAs(router, "locked@example.com", "wrong horse"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected a wrong password to be refused, got %d", w.Code)
+		}
+	}
+
+	w := passwordLoginAs(router, "locked@example.com", "correct horse")
+	if w.Code != http.StatusForbidden || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the account to be locked, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := passwordLoginAs(router, "nobody@example.com", "correct horse"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown email to be refused, got %d", w.Code)
+	}
+}
+
+func TestPasswordAccount_ResetPassword(t *testing.T) {
+	mailer := &recordingMailer{}
+	router := newAccountTestRouter(mailer)
+	createVerifiedAccount(t, "forgetful@example.com", "correct horse")
+
+	if w := postJSON(router, forgotPasswordPath, `{"email":"forgetful@example.com"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("expected the reset to be accepted, got %d", w.Code)
+	}
+	if w := postJSON(router, forgotPasswordPath, `{"email":"nobody@example.com"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("expected a reset of an unknown email to look accepted, got %d", w.Code)
+	}
+
+	link, err := url.Parse(mailer.lastLink(t, "forgetful@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Path != resetPasswordPage {
+		t.Fatalf("expected a link to the reset page, got %s", link)
+	}
+	reset := `{"token":"` + link.Query().Get("token") + `","password":"battery staple"}`
+
+	if w := postJSON(router, resetPasswordPath, reset); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the password to be reset, got %d: %s", w.Code, w.Body)
+	}
+	if w := postJSON(router, resetPasswordPath, reset); w.Code != http.StatusBadRequest {
+		t.Errorf("expected the reset link to work once, got %d", w.Code)
+	}
+
+	if w := passwordLoginAs(router, "forgetful@example.com", "correct horse"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the old password to be refused, got %d", w.Code)
+	}
+	if w := passwordLoginAs(router, "forgetful@example.com", "battery staple"); w.Code != http.StatusNoContent {
+		t.Errorf("expected the new password to log in, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestPasswordAccount_RequiresJSON(t *testing.T) {
+	router := newAccountTestRouter(&recordingMailer{})
+	createVerifiedAccount(t, "form@example.com", "correct horse")
+
+	// A form on another site can post text/plain without a preflight.
+	r := httptest.NewRequest(http.MethodPost, passwordLoginPath,
+		strings.NewReader(`{"email":"form@example.com","password":"correct horse"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected %d, got %d: %s", http.StatusUnsupportedMediaType, w.Code, w.Body)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("expected no session cookie, got %v", w.Result().Cookies())
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := verifyPassword("correct horse", hash); !ok || err != nil {
+		t.Errorf("expected the password to match, got %v, %v", ok, err)
+	}
+	if ok, err := verifyPassword("wrong horse", hash); ok || err != nil {
+		t.Errorf("expected another password not to match, got %v, %v", ok, err)
+	}
+	if _, err := verifyPassword("correct horse", "$2a$10$bcrypt"); err == nil {
+		t.Error("expected an unknown hash to be rejected")
+	}
+}
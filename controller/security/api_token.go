@@ -0,0 +1,88 @@
+package security
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vladoiliev02/online-store/controller"
+	"github.com/vladoiliev02/online-store/logging"
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	apiPathPrefix = "/api/"
+
+	// lastUsedPrecision limits how often the last use of a token is written,
+	// so that scripts do not cause a write per request.
+	lastUsedPrecision = time.Minute
+)
+
+// isAPIRequest tells if a request is answered with JSON rather than a page.
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiPathPrefix)
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// bearerAuthentication authenticates an API request with a personal access
+// token instead of a session. Tokens with the read scope are limited to safe
+// methods.
+func (sc *SecurityConfiguration) bearerAuthentication(w http.ResponseWriter, r *http.Request, next http.Handler, secret string) {
+	now := time.Now()
+
+	token, err := sc.apiTokenDAO.GetByTokenHash(r.Context(), controller.HashAPIToken(secret))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		controller.WriteError(err, w, r)
+		return
+	}
+	if err != nil || !token.ExpiresAt.After(now) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		controller.WriteError(&controller.HTTPError{Code: http.StatusUnauthorized, Message: "Invalid or expired API token", Err: err}, w, r)
+		return
+	}
+
+	if !allowsMethod(token.Scopes, r.Method) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+model.WriteScope+`"`)
+		controller.WriteError(&controller.HTTPError{Code: http.StatusForbidden, Message: "The API token does not have the write scope"}, w, r)
+		return
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedPrecision {
+		if err := sc.apiTokenDAO.UpdateLastUsedAt(r.Context(), token.ID.Int64, now.UTC()); err != nil {
+			logging.FromContext(r.Context()).Warn("cannot record API token use", "error", err)
+		}
+	}
+
+	logging.AddAttrs(r.Context(), "user_id", token.UserID.Int64, "api_token_id", token.ID.Int64)
+	ctx := controller.SetContextParam(controller.UserIDKey, token.UserID.Int64, r.Context())
+	ctx = controller.SetContextParam(controller.APITokenIDKey, token.ID.Int64, ctx)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func allowsMethod(scopes []string, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return slices.Contains(scopes, model.ReadScope) || slices.Contains(scopes, model.WriteScope)
+	default:
+		return slices.Contains(scopes, model.WriteScope)
+	}
+}
+
+// unauthenticatedAPIRequest responds with 401 to API clients, which cannot
+// follow the redirect to the login page.
+func unauthenticatedAPIRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	controller.WriteError(&controller.HTTPError{Code: http.StatusUnauthorized, Message: "Authentication required"}, w, r)
+}
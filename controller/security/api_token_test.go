@@ -0,0 +1,112 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/controller"
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func newAPITokenTestRouter() chi.Router {
+	router := newTestSecurity()
+	whoami := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strconv.FormatInt(controller.GetContextParam[int64](controller.UserIDKey, r.Context()), 10)))
+	}
+	router.Get("/api/v1/whoami", whoami)
+	router.Post("/api/v1/whoami", whoami)
+	router.With(controller.RequireSession).Get("/api/v1/account", whoami)
+	router.Get("/whoami", whoami)
+	return router
+}
+
+func createAPIToken(t *testing.T, userID int64, secret string, expiresAt time.Time, scopes ...string) {
+	t.Helper()
+
+	token := &model.APIToken{
+		UserID:    model.NullInt64JSON{Int64: userID, Valid: true},
+		TokenHash: controller.HashAPIToken(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	}
+	token.Name.Scan(secret)
+	if _, err := dao.NewAPITokenDAO().Create(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func bearerRequest(router chi.Router, method, path, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestBearerAuthentication(t *testing.T) {
+	router := newAPITokenTestRouter()
+	userID := createVerifiedAccount(t, "script@example.com", "correct horse")
+	createAPIToken(t, userID, "store_pat_read", time.Now().Add(time.Hour), model.ReadScope)
+	createAPIToken(t, userID, "store_pat_write", time.Now().Add(time.Hour), model.WriteScope)
+	createAPIToken(t, userID, "store_pat_expired", time.Now().Add(-time.Hour), model.ReadScope, model.WriteScope)
+
+	w := bearerRequest(router, http.MethodGet, "/api/v1/whoami", "store_pat_read")
+	if w.Code != http.StatusOK || w.Body.String() != strconv.FormatInt(userID, 10) {
+		t.Errorf("expected the read token to authenticate user %d, got %d: %s", userID, w.Code, w.Body)
+	}
+
+	w = bearerRequest(router, http.MethodPost, "/api/v1/whoami", "store_pat_read")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Header().Get("WWW-Authenticate"), "insufficient_scope") {
+		t.Errorf("expected the read token not to write, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := bearerRequest(router, http.MethodPost, "/api/v1/whoami", "store_pat_write"); w.Code != http.StatusOK {
+		t.Errorf("expected the write token to write, got %d: %s", w.Code, w.Body)
+	}
+
+	for _, token := range []string{"store_pat_expired", "store_pat_unknown"} {
+		w := bearerRequest(router, http.MethodGet, "/api/v1/whoami", token)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+			t.Errorf("expected %s to be refused, got %d: %s", token, w.Code, w.Body)
+		}
+	}
+
+	if w := bearerRequest(router, http.MethodGet, "/api/v1/account", "store_pat_write"); w.Code != http.StatusForbidden {
+		t.Errorf("expected the account routes to require a session, got %d: %s", w.Code, w.Body)
+	}
+
+	tokens, err := dao.NewAPITokenDAO().GetByUserID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if token.Name.String == "store_pat_read" && token.LastUsedAt == nil {
+			t.Error("expected the use of the token to be recorded")
+		}
+	}
+}
+
+func TestUnauthenticatedRequests(t *testing.T) {
+	router := newAPITokenTestRouter()
+
+	w := bearerRequest(router, http.MethodGet, "/api/v1/whoami", "")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a JSON 401 for the API, got %d %v: %s", w.Code, w.Header(), w.Body)
+	}
+
+	w = bearerRequest(router, http.MethodGet, "/whoami", "")
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/store/login?redirect=%2Fwhoami" {
+		t.Errorf("expected pages to redirect to the login, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
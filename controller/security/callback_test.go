@@ -0,0 +1,99 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// startLogin sends the user to the mock provider, returning the callback the
+// provider would redirect back to and the cookies of the browser.
+func startLogin(t *testing.T, router chi.Router, mock *mockOIDCServer, path string) (url.Values, []*http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected a redirect to the provider, got %d: %s", w.Code, w.Body)
+	}
+
+	code, state := mock.authorize(t, w.Header().Get("Location"))
+	return url.Values{"code": {code}, "state": {state}}, w.Result().Cookies()
+}
+
+func callback(router chi.Router, query url.Values, cookies []*http.Cookie, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/oauth/code?"+query.Encode(), nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestCallback_RedirectsBackToSameOriginPaths(t *testing.T) {
+	mock := newMockOIDCServer(t, map[string]any{"sub": "returning", "email": "returning@example.com"})
+	router := newTestSecurity(mockProviderConfig(mock, false))
+
+	for redirect, expected := range map[string]string{
+		"/store/orders/5?tab=items": "/store/orders/5?tab=items",
+		"https://evil.example":      "/store/",
+		"//evil.example/store":      "/store/",
+		"/\\evil.example":           "/store/",
+	} {
+		query, cookies := startLogin(t, router, mock, "/login/mock?"+url.Values{"redirect": {redirect}}.Encode())
+		w := callback(router, query, cookies, "")
+		if location := w.Header().Get("Location"); w.Code != http.StatusTemporaryRedirect || location != expected {
+			t.Errorf("expected %q to redirect to %q, got %d to %q", redirect, expected, w.Code, location)
+		}
+	}
+}
+
+func TestCallback_StateWorksOnce(t *testing.T) {
+	mock := newMockOIDCServer(t, map[string]any{"sub": "once", "email": "once@example.com"})
+	router := newTestSecurity(mockProviderConfig(mock, false))
+
+	query, cookies := startLogin(t, router, mock, "/login/mock")
+	wrong := url.Values{"code": query["code"], "state": {"forged"}}
+	if w := callback(router, wrong, cookies, ""); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/store/login?error=expired" {
+		t.Fatalf("expected a forged state to be refused, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := callback(router, query, cookies, ""); w.Code != http.StatusSeeOther {
+		t.Errorf("expected the state to be consumed by the failed attempt, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	query, cookies = startLogin(t, router, mock, "/login/mock")
+	if w := callback(router, query, cookies, ""); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected to be logged in, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := callback(router, query, cookies, ""); w.Code != http.StatusSeeOther {
+		t.Errorf("expected a replayed callback to be refused, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestCallback_Errors(t *testing.T) {
+	mock := newMockOIDCServer(t, map[string]any{"sub": "failing", "email": "failing@example.com"})
+	router := newTestSecurity(mockProviderConfig(mock, false))
+
+	query, cookies := startLogin(t, router, mock, "/login/mock")
+	denied := url.Values{"error": {"access_denied"}, "state": query["state"]}
+	if w := callback(router, denied, cookies, "application/json"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a refused login to be reported as JSON, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := callback(router, url.Values{"code": {"code"}, "state": {"state"}}, nil, ""); w.Header().Get("Location") != "/store/login?error=expired" {
+		t.Errorf("expected a callback without a login to send the user back, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	query, cookies = startLogin(t, router, mock, "/login/mock")
+	query.Set("code", "stolen")
+	if w := callback(router, query, cookies, ""); w.Header().Get("Location") != "/store/login?error=provider" {
+		t.Errorf("expected a code the provider refuses to fail the login, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
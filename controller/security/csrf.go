@@ -0,0 +1,62 @@
+package security
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/vladoiliev02/online-store/controller"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	csrfTokenKey = "csrfToken"
+	// csrfHeader carries the token of the session with every request that
+	// changes state, which other sites cannot read and therefore not send.
+	csrfHeader    = "X-CSRF-Token"
+	csrfTokenPath = "/account/csrf-token"
+)
+
+type csrfTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// getCSRFToken returns the CSRF token of the session, creating it on first use.
+func (sc *SecurityConfiguration) getCSRFToken(w http.ResponseWriter, r *http.Request) {
+	session, err := sc.store.Get(r, sessionName)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+
+	token, _ := session.Values[csrfTokenKey].(string)
+	if token == "" {
+		if token, err = generateRandomString(32); err != nil {
+			controller.WriteError(err, w, r)
+			return
+		}
+		session.Values[csrfTokenKey] = token
+		if err := session.Save(r, w); err != nil {
+			controller.WriteError(err, w, r)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&csrfTokenResponse{Token: token})
+}
+
+// validCSRF tells if a request authenticated with a session may proceed.
+// Requests that do not change state need no token.
+func validCSRF(r *http.Request, session *sessions.Session) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	token, _ := session.Values[csrfTokenKey].(string)
+	sent := r.Header.Get(csrfHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(sent)) == 1
+}
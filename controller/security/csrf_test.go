@@ -0,0 +1,65 @@
+package security
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRF_RequiredForSessionMutations(t *testing.T) {
+	router := newAccountTestRouter(LogMailer{})
+	router.Post("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	createVerifiedAccount(t, "csrf@example.com", "correct horse")
+
+	login := passwordLoginAs(router, "csrf@example.com", "correct horse")
+	cookies := login.Result().Cookies()
+	for _, cookie := range cookies {
+		if cookie.Name == sessionName && (!cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode) {
+			t.Errorf("expected a secure session cookie, got %+v", cookie)
+		}
+	}
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		if token != "" {
+			r.Header.Set(csrfHeader, token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request(http.MethodGet, "/whoami", ""); w.Code != http.StatusOK {
+		t.Errorf("expected reads not to need a CSRF token, got %d: %s", w.Code, w.Body)
+	}
+	if w := request(http.MethodPost, "/whoami", ""); w.Code != http.StatusForbidden {
+		t.Errorf("expected a missing CSRF token to be refused, got %d: %s", w.Code, w.Body)
+	}
+
+	token := csrfTokenOf(request(http.MethodGet, csrfTokenPath, ""))
+	if token == "" {
+		t.Fatal("expected a CSRF token")
+	}
+	if again := csrfTokenOf(request(http.MethodGet, csrfTokenPath, "")); again != token {
+		t.Errorf("expected the same CSRF token for the session, got %q and %q", token, again)
+	}
+
+	if w := request(http.MethodPost, "/whoami", "wrong"); w.Code != http.StatusForbidden {
+		t.Errorf("expected a wrong CSRF token to be refused, got %d", w.Code)
+	}
+	if w := request(http.MethodPost, "/whoami", token); w.Code != http.StatusNoContent {
+		t.Errorf("expected the CSRF token to be accepted, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func csrfTokenOf(w *httptest.ResponseRecorder) string {
+	var body csrfTokenResponse
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return body.Token
+}
@@ -0,0 +1,69 @@
+package security
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vladoiliev02/online-store/controller"
+	"github.com/vladoiliev02/online-store/logging"
+)
+
+const loginPage = "/store/login"
+
+// Reasons of failed logins, which the login page explains.
+const (
+	loginDenied   = "denied"
+	loginExpired  = "expired"
+	loginProvider = "provider"
+	loginLinked   = "linked"
+	loginInternal = "internal"
+)
+
+var loginErrorMessages = map[string]string{
+	loginDenied:   "The identity provider did not log you in",
+	loginExpired:  "The login expired or was already used, please try again",
+	loginProvider: "Cannot log in with the identity provider",
+	loginLinked:   "The identity is linked to another user",
+	loginInternal: "Cannot log in",
+}
+
+var errIdentityLinked = errors.New("the identity is linked to another user")
+
+// loginFailed answers a callback of a provider that cannot log the user in.
+// Clients that accept JSON get an error, browsers are sent back to the login
+// page, which explains the reason.
+func loginFailed(w http.ResponseWriter, r *http.Request, code int, reason string, err error) {
+	httpError := &controller.HTTPError{Code: code, Message: loginErrorMessages[reason], Err: err}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		controller.WriteError(httpError, w, r)
+		return
+	}
+
+	logging.FromContext(r.Context()).Warn(httpError.Message, "status", code, "error", err)
+	http.Redirect(w, r, loginPage+"?error="+reason, http.StatusSeeOther)
+}
+
+// loginPageFor returns the login page, which brings the user back to the page
+// of r after logging in.
+func loginPageFor(r *http.Request) string {
+	if r.Method != http.MethodGet {
+		return loginPage
+	}
+	return loginPage + "?" + url.Values{"redirect": {r.URL.RequestURI()}}.Encode()
+}
+
+// safeRedirect returns target if it is a path of the store, or "" otherwise,
+// so that logins cannot be used to send users to other sites.
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.ContainsAny(target, "\\\r\n\t") {
+		return ""
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return ""
+	}
+	return target
+}
@@ -0,0 +1,53 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer sends the emails of local accounts.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// LogMailer logs emails instead of sending them, for development.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	slog.InfoContext(ctx, "Email not sent, no SMTP server configured", "to", to, "subject", subject, "body", body)
+	return nil
+}
+
+// SMTPMailer sends plain text emails through an SMTP server, authenticating
+// if a username is set.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	mailer := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return errors.New("invalid email header")
+	}
+
+	message := "From: " + s.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(message))
+}
@@ -0,0 +1,121 @@
+package security
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+// MemorySessionBackend keeps sessions in the process. They are lost on
+// restart and not shared between instances, so it suits development and
+// single instance deployments.
+type MemorySessionBackend struct {
+	mu       sync.Mutex
+	sessions map[int64]*model.Session
+	nextID   int64
+}
+
+func NewMemorySessionBackend() *MemorySessionBackend {
+	return &MemorySessionBackend{sessions: map[int64]*model.Session{}}
+}
+
+func (m *MemorySessionBackend) GetByTokenHash(ctx context.Context, tokenHash string) (*model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, session := range m.sessions {
+		if session.TokenHash == tokenHash {
+			return copySession(session), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemorySessionBackend) GetActiveByUserID(ctx context.Context, userID int64, now, idleSince time.Time) ([]*model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := []*model.Session{}
+	for _, session := range m.sessions {
+		if session.UserID.Int64 == userID && session.ExpiresAt.After(now) && session.LastSeenAt.After(idleSince) {
+			sessions = append(sessions, copySession(session))
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+func (m *MemorySessionBackend) Create(ctx context.Context, session *model.Session) (*model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	session.ID = model.NullInt64JSON{Int64: m.nextID, Valid: true}
+	m.sessions[m.nextID] = copySession(session)
+	return session, nil
+}
+
+func (m *MemorySessionBackend) Update(ctx context.Context, session *model.Session) (*model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[session.ID.Int64]; !ok {
+		return nil, sql.ErrNoRows
+	}
+	m.sessions[session.ID.Int64] = copySession(session)
+	return session, nil
+}
+
+func (m *MemorySessionBackend) Delete(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemorySessionBackend) DeleteByUser(ctx context.Context, userID, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[id]; !ok || session.UserID.Int64 != userID {
+		return sql.ErrNoRows
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemorySessionBackend) DeleteByUserID(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.UserID.Valid && session.UserID.Int64 == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemorySessionBackend) DeleteExpired(ctx context.Context, now, idleSince time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if !session.ExpiresAt.After(now) || !session.LastSeenAt.After(idleSince) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func copySession(session *model.Session) *model.Session {
+	copied := *session
+	copied.Data = append([]byte(nil), session.Data...)
+	return &copied
+}
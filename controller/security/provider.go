@@ -0,0 +1,310 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ProviderConfig configures an identity provider. Providers with an Issuer
+// are OpenID Connect providers, whose endpoints are discovered from
+// <Issuer>/.well-known/openid-configuration and whose ID tokens are verified.
+// Endpoints that are set explicitly take precedence over discovered ones.
+// Providers without an Issuer, such as GitHub, are plain OAuth2 providers that
+// are identified through their user info endpoint.
+type ProviderConfig struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+	// TrustEmail links a first login to the existing user with the same
+	// email, if the ID token asserts that the email is verified. New users
+	// only get the email of trusted providers.
+	TrustEmail bool
+	Claims     ClaimNames
+}
+
+// ClaimNames names the claims holding the profile of a user. Empty names
+// default to the standard OpenID Connect claims.
+type ClaimNames struct {
+	Subject       string
+	Email         string
+	EmailVerified string
+	Name          string
+	FirstName     string
+	LastName      string
+	Picture       string
+}
+
+var defaultClaimNames = ClaimNames{
+	Subject:       "sub",
+	Email:         "email",
+	EmailVerified: "email_verified",
+	Name:          "name",
+	FirstName:     "given_name",
+	LastName:      "family_name",
+	Picture:       "picture",
+}
+
+// Provider logs users in with an identity provider.
+type Provider struct {
+	config *ProviderConfig
+	claims ClaimNames
+
+	mu          sync.Mutex
+	oauth2      *oauth2.Config
+	userInfoURL string
+	verifier    *oidc.IDTokenVerifier
+}
+
+// identity is the account of a user at a provider.
+type identity struct {
+	provider      string
+	subject       string
+	email         string
+	emailVerified bool
+	name          string
+	firstName     string
+	lastName      string
+	pictureURL    string
+}
+
+func NewProvider(config *ProviderConfig, redirectURL string) *Provider {
+	claims := config.Claims
+	setDefault(&claims.Subject, defaultClaimNames.Subject)
+	setDefault(&claims.Email, defaultClaimNames.Email)
+	setDefault(&claims.EmailVerified, defaultClaimNames.EmailVerified)
+	setDefault(&claims.Name, defaultClaimNames.Name)
+	setDefault(&claims.FirstName, defaultClaimNames.FirstName)
+	setDefault(&claims.LastName, defaultClaimNames.LastName)
+	setDefault(&claims.Picture, defaultClaimNames.Picture)
+
+	provider := &Provider{config: config, claims: claims, userInfoURL: config.UserInfoURL}
+	provider.oauth2 = provider.oauth2Config(redirectURL, oauth2.Endpoint{AuthURL: config.AuthURL, TokenURL: config.TokenURL})
+	return provider
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+func (p *Provider) DisplayName() string {
+	if p.config.DisplayName != "" {
+		return p.config.DisplayName
+	}
+	return p.config.Name
+}
+
+// AuthCodeURL returns the URL of the login page of the provider. The nonce is
+// echoed in the ID token of OpenID Connect providers. The PKCE verifier has to
+// be presented with the code, so that a stolen code is of no use.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	config, _, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// identity exchanges an authorization code for the identity of the user. The
+// subject and email of OpenID Connect providers are taken from the verified
+// ID token, which has to carry nonce. The user info endpoint only completes
+// the profile.
+func (p *Provider) identity(ctx context.Context, code, nonce, pkceVerifier string) (*identity, error) {
+	config, verifier, userInfoURL, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(pkceVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %w", err)
+	}
+
+	userInfo, err := p.userInfo(ctx, config, token, userInfoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := userInfo
+	if verifier != nil {
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			return nil, errors.New("token response without an ID token")
+		}
+
+		idToken, err := verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID token: %w", err)
+		}
+		if idToken.Nonce != nonce {
+			return nil, errors.New("invalid ID token nonce")
+		}
+
+		claims = map[string]any{}
+		if err := idToken.Claims(&claims); err != nil {
+			return nil, err
+		}
+		// The profile may come from the user info, the identity may not.
+		for name, value := range userInfo {
+			if _, ok := claims[name]; !ok && name != p.claims.Email && name != p.claims.EmailVerified {
+				claims[name] = value
+			}
+		}
+		claims[p.claims.Subject] = idToken.Subject
+	}
+
+	identity := &identity{
+		provider:   p.config.Name,
+		subject:    claimString(claims, p.claims.Subject),
+		email:      claimString(claims, p.claims.Email),
+		name:       claimString(claims, p.claims.Name),
+		firstName:  claimString(claims, p.claims.FirstName),
+		lastName:   claimString(claims, p.claims.LastName),
+		pictureURL: claimString(claims, p.claims.Picture),
+	}
+	// Plain OAuth2 providers only vouch for what their user info returns.
+	identity.emailVerified = verifier != nil && claimBool(claims, p.claims.EmailVerified)
+
+	if identity.subject == "" {
+		return nil, errors.New("identity without a subject")
+	}
+	if identity.name == "" {
+		identity.name = strings.TrimSpace(identity.firstName + " " + identity.lastName)
+	}
+
+	return identity, nil
+}
+
+// discover returns the OAuth2 configuration, the ID token verifier, which is
+// nil for plain OAuth2 providers, and the user info endpoint. OpenID Connect
+// providers are discovered on first use and again after a failure, so that a
+// provider that is down does not keep the store from starting.
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config.Issuer == "" || p.verifier != nil {
+		return p.oauth2, p.verifier, p.userInfoURL, nil
+	}
+
+	discovered, err := oidc.NewProvider(ctx, p.config.Issuer)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("discovery of %s failed: %w", p.config.Name, err)
+	}
+
+	endpoint := discovered.Endpoint()
+	if p.config.AuthURL != "" {
+		endpoint.AuthURL = p.config.AuthURL
+	}
+	if p.config.TokenURL != "" {
+		endpoint.TokenURL = p.config.TokenURL
+	}
+	if p.userInfoURL == "" {
+		p.userInfoURL = discovered.UserInfoEndpoint()
+	}
+
+	p.oauth2 = p.oauth2Config(p.oauth2.RedirectURL, endpoint)
+	p.verifier = discovered.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	return p.oauth2, p.verifier, p.userInfoURL, nil
+}
+
+func (p *Provider) oauth2Config(redirectURL string, endpoint oauth2.Endpoint) *oauth2.Config {
+	scopes := p.config.Scopes
+	if len(scopes) == 0 && p.config.Issuer != "" {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint:     endpoint,
+		Scopes:       scopes,
+	}
+}
+
+func (p *Provider) userInfo(ctx context.Context, config *oauth2.Config, token *oauth2.Token, userInfoURL string) (map[string]any, error) {
+	if userInfoURL == "" {
+		return map[string]any{}, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := config.Client(ctx, token).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("user info request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user info request failed with status %d", resp.StatusCode)
+	}
+
+	userInfo := map[string]any{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&userInfo); err != nil {
+		return nil, fmt.Errorf("invalid user info: %w", err)
+	}
+	return userInfo, nil
+}
+
+// claimString returns a string or numeric claim, e.g. the numeric user IDs of
+// GitHub.
+func claimString(claims map[string]any, name string) string {
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// claimBool also accepts "true", which some providers send for
+// email_verified.
+func claimBool(claims map[string]any, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		verified, _ := strconv.ParseBool(value)
+		return verified
+	default:
+		return false
+	}
+}
+
+func setDefault(value *string, defaultValue string) {
+	if *value == "" {
+		*value = defaultValue
+	}
+}
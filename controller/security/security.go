@@ -3,18 +3,20 @@ package security
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
 	"net/url"
-	"time"
 
 	"github.com/vladoiliev02/online-store/controller"
 	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/logging"
 	"github.com/vladoiliev02/online-store/model"
+	"github.com/vladoiliev02/online-store/ratelimit"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/sessions"
@@ -22,38 +24,65 @@ import (
 )
 
 const (
-	sessionName     = "authentication"
-	oauthStateKey   = "state"
-	redirectBackKey = "redirectBack"
+	sessionName   = "authentication"
+	oauthStateKey = "state"
+	oauthNonceKey = "nonce"
+	// oauthVerifierKey holds the PKCE verifier of the login.
+	oauthVerifierKey = "verifier"
+	providerKey      = "provider"
+	redirectBackKey  = "redirectBack"
+
+	providersPath = "/login/providers"
 )
 
 type OAuthConfiguration struct {
-	oauth2.Config
-	UserEndpoint string
-	LogoutPath   string
-	HomePath     string
+	// RedirectURL is the callback of every provider. The provider a user
+	// logs in with is kept in their session.
+	RedirectURL string
+	// Providers are the identity providers users can log in with, the first
+	// one is used by /login.
+	Providers  []*ProviderConfig
+	LogoutPath string
+	HomePath   string
 }
 
-type UserInfo struct {
-	Sub        string `json:"sub"`
-	Email      string `json:"email"`
-	Name       string `json:"name"`
-	FirstName  string `json:"given_name"`
-	LastName   string `json:"family_name"`
-	PictureURL string `json:"picture"`
+type SecurityConfiguration struct {
+	store           *SessionStore
+	oauthConfig     *OAuthConfiguration
+	providers       []*Provider
+	accounts        *AccountConfiguration
+	userDAO         dao.UserDAO
+	identityDAO     *dao.IdentityDAO
+	credentialDAO   *dao.CredentialDAO
+	accountTokenDAO *dao.AccountTokenDAO
+	twoFactorDAO    *dao.TwoFactorDAO
+	apiTokenDAO     *dao.APITokenDAO
 }
 
-type SecurityConfiguration struct {
-	store       *sessions.CookieStore
-	oauthConfig *OAuthConfiguration
-	userDAO     dao.UserDAO
+// providerInfo describes a provider to the login page.
+type providerInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	LoginURL    string `json:"loginUrl"`
 }
 
-func NewSecurityConfiguration(r chi.Router, oauthConfig *OAuthConfiguration, sessionStoreKey string) *SecurityConfiguration {
+func NewSecurityConfiguration(r chi.Router, oauthConfig *OAuthConfiguration, accounts *AccountConfiguration, store *SessionStore) *SecurityConfiguration {
+	providers := make([]*Provider, 0, len(oauthConfig.Providers))
+	for _, providerConfig := range oauthConfig.Providers {
+		providers = append(providers, NewProvider(providerConfig, oauthConfig.RedirectURL))
+	}
+
 	return &SecurityConfiguration{
-		store:       sessions.NewCookieStore([]byte(sessionStoreKey)),
-		oauthConfig: oauthConfig,
-		userDAO:     *dao.NewUserDAO(),
+		store:           store,
+		oauthConfig:     oauthConfig,
+		providers:       providers,
+		accounts:        accounts,
+		userDAO:         *dao.NewUserDAO(),
+		identityDAO:     dao.NewIdentityDAO(),
+		credentialDAO:   dao.NewCredentialDAO(),
+		accountTokenDAO: dao.NewAccountTokenDAO(),
+		twoFactorDAO:    dao.NewTwoFactorDAO(),
+		apiTokenDAO:     dao.NewAPITokenDAO(),
 	}
 }
 
@@ -63,17 +92,31 @@ func (sc *SecurityConfiguration) ConfigureRouter(r chi.Router) {
 		panic(err.Error())
 	}
 
+	noAuthPaths := map[string]struct{}{
+		redirectUrl.Path:          {},
+		sc.oauthConfig.LogoutPath: {},
+		"/api/v1/liveness":        {},
+		"/api/v1/readiness":       {},
+		"/api/v1/openapi.json":    {},
+		"/metrics":                {},
+		loginPage:                 {},
+		resetPasswordPage:         {},
+		"/login":                  {},
+		providersPath:             {},
+		registerPath:              {},
+		verifyEmailPath:           {},
+		passwordLoginPath:         {},
+		forgotPasswordPath:        {},
+		resetPasswordPath:         {},
+		twoFactorPath:             {},
+		twoFactorPage:             {},
+	}
+	for _, provider := range sc.providers {
+		noAuthPaths[loginPath(provider)] = struct{}{}
+	}
+
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			noAuthPaths := map[string]struct{}{
-				redirectUrl.Path:          {},
-				sc.oauthConfig.LogoutPath: {},
-				"/api/v1/liveness":        {},
-				"/api/v1/readiness":       {},
-				"/store/login":            {},
-				"/login":                  {},
-			}
-
 			if _, found := noAuthPaths[r.URL.Path]; !found {
 				sc.oauthCodeGrantMiddleware(next).ServeHTTP(w, r)
 			} else {
@@ -82,9 +125,28 @@ func (sc *SecurityConfiguration) ConfigureRouter(r chi.Router) {
 		})
 	})
 
-	r.Get(redirectUrl.Path, sc.codeExchange)
 	r.Get(sc.oauthConfig.LogoutPath, sc.logout)
-	r.Get("/login", sc.login)
+	r.Get(providersPath, sc.listProviders)
+	r.Get(csrfTokenPath, sc.getCSRFToken)
+
+	r.Group(func(r chi.Router) {
+		r.Use(sc.accounts.RateLimiter.Middleware("login", sc.accounts.LoginRateLimit, ratelimit.ClientIP, tooManyLogins))
+
+		r.Get(redirectUrl.Path, sc.codeExchange)
+		r.Get("/login", sc.login)
+		r.Get("/login/{provider}", sc.login)
+
+		r.Post(registerPath, sc.register)
+		r.Get(verifyEmailPath, sc.verifyEmail)
+		r.Post(passwordLoginPath, sc.passwordLogin)
+		r.Post(forgotPasswordPath, sc.forgotPassword)
+		r.Post(resetPasswordPath, sc.resetPassword)
+		r.Post(twoFactorPath, sc.verifyTwoFactor)
+	})
+}
+
+func tooManyLogins(w http.ResponseWriter, r *http.Request) {
+	controller.WriteError(&controller.HTTPError{Code: http.StatusTooManyRequests, Message: "Too many login attempts, please try again later"}, w, r)
 }
 
 func (sc *SecurityConfiguration) logout(w http.ResponseWriter, r *http.Request) {
@@ -102,146 +164,292 @@ func (sc *SecurityConfiguration) logout(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	http.Redirect(w, r, "/store/login", http.StatusSeeOther)
+	http.Redirect(w, r, loginPage, http.StatusSeeOther)
 }
 
+// login redirects to the login page of a provider, named in the path or the
+// first one. Users that are logged in already are sent to the home page,
+// unless they name a provider, whose identity is then linked to them.
 func (sc *SecurityConfiguration) login(w http.ResponseWriter, r *http.Request) {
+	if len(sc.providers) == 0 {
+		http.Error(w, "No identity provider configured", http.StatusNotFound)
+		return
+	}
+
+	provider := sc.providers[0]
+	name := chi.URLParam(r, "provider")
+	if name != "" {
+		if provider = sc.provider(name); provider == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
 	session, err := sc.store.Get(r, sessionName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if !isAuthenticated(session) {
-		oauthStateString, err := generateRandomString(32)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	if isAuthenticated(session) && name == "" {
+		http.Redirect(w, r, sc.oauthConfig.HomePath, http.StatusTemporaryRedirect)
+		return
+	}
 
-		session.Values[oauthStateKey] = oauthStateString
-		session.Values[redirectBackKey] = r.URL.String()
-		err = session.Save(r, w)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	oauthStateString, err := generateRandomString(32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := generateRandomString(32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
 
-		url := sc.oauthConfig.AuthCodeURL(oauthStateString, oauth2.ApprovalForce)
-		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	url, err := provider.AuthCodeURL(r.Context(), oauthStateString, nonce, verifier)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("identity provider unavailable", "provider", provider.Name(), "error", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	session.Values[oauthStateKey] = oauthStateString
+	session.Values[oauthNonceKey] = nonce
+	session.Values[oauthVerifierKey] = verifier
+	session.Values[providerKey] = provider.Name()
+	session.Values[redirectBackKey] = safeRedirect(r.URL.Query().Get("redirect"))
+	err = session.Save(r, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
-	} else {
-		http.Redirect(w, r, sc.oauthConfig.HomePath, http.StatusTemporaryRedirect)
 	}
+
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+func (sc *SecurityConfiguration) listProviders(w http.ResponseWriter, r *http.Request) {
+	providers := make([]providerInfo, 0, len(sc.providers))
+	for _, provider := range sc.providers {
+		providers = append(providers, providerInfo{Name: provider.Name(), DisplayName: provider.DisplayName(), LoginURL: loginPath(provider)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers)
 }
 
 func (sc *SecurityConfiguration) oauthCodeGrantMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok && isAPIRequest(r) {
+			sc.bearerAuthentication(w, r, next, token)
+			return
+		}
+
 		session, err := sc.store.Get(r, sessionName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		if !isAuthenticated(session) && isAPIRequest(r) {
+			unauthenticatedAPIRequest(w, r)
+			return
+		}
 		if !isAuthenticated(session) {
-			http.Redirect(w, r, "/store/login", http.StatusTemporaryRedirect)
+			http.Redirect(w, r, loginPageFor(r), http.StatusTemporaryRedirect)
 			return
 		}
 
-		next.ServeHTTP(w, r.WithContext(controller.SetContextParam(controller.UserIDKey, session.Values[controller.UserIDKey], r.Context())))
+		if !validCSRF(r, session) {
+			controller.WriteError(&controller.HTTPError{Code: http.StatusForbidden, Message: "Missing or invalid CSRF token"}, w, r)
+			return
+		}
+
+		if err := sc.store.Touch(r, w, session); err != nil {
+			logging.FromContext(r.Context()).Warn("cannot record session use", "error", err)
+		}
+
+		logging.AddAttrs(r.Context(), "user_id", session.Values[controller.UserIDKey])
+		ctx := controller.SetContextParam(controller.UserIDKey, session.Values[controller.UserIDKey], r.Context())
+		ctx = controller.SetContextParam(controller.SessionIDKey, sessionID(session), ctx)
+		ctx = controller.SetContextParam(controller.TwoFactorKey, session.Values[twoFactorKey] == true, ctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// codeExchange completes a login with a provider. The state of the login is
+// removed from the session whether the login succeeds or not, so that a
+// callback works once.
 func (sc *SecurityConfiguration) codeExchange(w http.ResponseWriter, r *http.Request) {
 	session, err := sc.store.Get(r, sessionName)
 	if err != nil {
-		log.Println(err)
+		loginFailed(w, r, http.StatusInternalServerError, loginInternal, err)
 		return
 	}
-	state := session.Values[oauthStateKey].(string)
 
-	receivedState := r.FormValue(oauthStateKey)
-	if receivedState != state {
-		log.Println("invalid oauth state ", err)
-		return
+	state, _ := session.Values[oauthStateKey].(string)
+	nonce, _ := session.Values[oauthNonceKey].(string)
+	verifier, _ := session.Values[oauthVerifierKey].(string)
+	providerName, _ := session.Values[providerKey].(string)
+	redirectBack, _ := session.Values[redirectBackKey].(string)
+	clearOAuthLogin(session)
+
+	// fail saves the session first, logging in saves it otherwise.
+	fail := func(code int, reason string, err error) {
+		if state != "" {
+			if err := session.Save(r, w); err != nil {
+				logging.FromContext(r.Context()).Error("cannot save session", "error", err)
+			}
+		}
+		loginFailed(w, r, code, reason, err)
 	}
 
-	code := r.FormValue("code")
-	token, err := sc.oauthConfig.Exchange(context.Background(), code)
-	if err != nil {
-		log.Println("oauth error:", err)
+	if reason := r.FormValue("error"); reason != "" {
+		fail(http.StatusUnauthorized, loginDenied, fmt.Errorf("identity provider error %s: %s", reason, r.FormValue("error_description")))
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(r.FormValue(oauthStateKey))) != 1 {
+		fail(http.StatusBadRequest, loginExpired, errors.New("invalid oauth state"))
 		return
 	}
 
-	log.Println("Access Token:", token.AccessToken)
+	provider := sc.provider(providerName)
+	if provider == nil {
+		fail(http.StatusBadRequest, loginExpired, fmt.Errorf("unknown identity provider %q", providerName))
+		return
+	}
 
-	err = sc.saveUserInSession(r, token, session)
+	identity, err := provider.identity(r.Context(), r.FormValue("code"), nonce, verifier)
 	if err != nil {
-		log.Println("error retrieving user info:", err)
+		fail(http.StatusBadGateway, loginProvider, fmt.Errorf("cannot identify user with %s: %w", provider.Name(), err))
 		return
 	}
 
-	now := time.Now().Unix()
-	validityInSeconds := int64(token.Expiry.Sub(time.Unix(now, 0)).Seconds())
-	session.Options.MaxAge = int(float64(validityInSeconds) * 0.95)
+	userID, err := sc.resolveUser(r.Context(), session, provider, identity)
+	if errors.Is(err, errIdentityLinked) {
+		fail(http.StatusConflict, loginLinked, err)
+		return
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, loginInternal, fmt.Errorf("cannot resolve user: %w", err))
+		return
+	}
 
-	session.Values[controller.UserIDKey] = controller.GetContextParam[int64](controller.UserIDKey, r.Context())
+	logging.FromContext(r.Context()).Info("user logged in", "user_id", userID, "provider", provider.Name())
 
-	err = sc.store.Save(r, w, session)
+	pending, err := sc.firstFactorPassed(w, r, session, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		fail(http.StatusInternalServerError, loginInternal, err)
+		return
+	}
+	if pending {
+		http.Redirect(w, r, twoFactorPage, http.StatusTemporaryRedirect)
 		return
 	}
 
-	redirectBack := session.Values[redirectBackKey].(string)
 	if redirectBack == "" {
 		redirectBack = sc.oauthConfig.HomePath
 	}
 	http.Redirect(w, r, redirectBack, http.StatusTemporaryRedirect)
 }
 
-func (sc *SecurityConfiguration) saveUserInSession(r *http.Request, token *oauth2.Token, session *sessions.Session) error {
-	userInfo, err := sc.getUserInfoFromToken(r, token)
-	if err != nil {
-		return err
-	}
+// logIn makes session the session of a user, who passed two-factor
+// authentication or not. Its token and CSRF token are replaced, so that
+// tokens obtained before logging in cannot be used afterwards.
+func (sc *SecurityConfiguration) logIn(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID int64, twoFactor bool) error {
+	sc.store.Renew(session)
+	clearPendingLogin(session)
+	delete(session.Values, csrfTokenKey)
+	session.Values[controller.UserIDKey] = userID
+	session.Values[twoFactorKey] = twoFactor
+	return sc.store.Save(r, w, session)
+}
 
-	user, err := sc.userDAO.GetByEmail(userInfo.Email)
-	if errors.Is(err, sql.ErrNoRows) {
-		user = &model.User{}
-		user.FirstName.Scan(userInfo.FirstName)
-		user.LastName.Scan(userInfo.LastName)
-		user.Name.Scan(userInfo.Name)
-		user.PictureURL.Scan(userInfo.PictureURL)
-		user.Email.Scan(userInfo.Email)
+// resolveUser returns the user of an identity. An identity seen for the first
+// time is linked to the user that is logged in, to the user with the same
+// email if the provider is trusted to verify emails, or to a new user.
+func (sc *SecurityConfiguration) resolveUser(ctx context.Context, session *sessions.Session, provider *Provider, identity *identity) (int64, error) {
+	currentUserID, _ := session.Values[controller.UserIDKey].(int64)
 
-		user, err = sc.userDAO.Create(user)
-		if err != nil {
-			return err
+	linked, err := sc.identityDAO.GetByProviderSubject(ctx, identity.provider, identity.subject)
+	if err == nil {
+		if currentUserID != 0 && currentUserID != linked.UserID.Int64 {
+			return 0, errIdentityLinked
 		}
-	} else if err != nil {
-		return err
+		return linked.UserID.Int64, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
 	}
 
-	*r = *r.WithContext(controller.SetContextParam(controller.UserIDKey, user.ID.Int64, r.Context()))
-	return nil
-}
+	newIdentity := &model.Identity{}
+	newIdentity.Provider.Scan(identity.provider)
+	newIdentity.Subject.Scan(identity.subject)
+	newIdentity.Email.Scan(identity.email)
+
+	userID := currentUserID
+	if userID == 0 && provider.config.TrustEmail && identity.emailVerified && identity.email != "" {
+		user, err := sc.userDAO.GetByEmail(ctx, identity.email)
+		if err == nil {
+			// A password registered with the email but never verified may
+			// be someone else's, who must not share the account.
+			if err := sc.credentialDAO.DeleteUnverified(ctx, user.ID.Int64); err != nil {
+				return 0, err
+			}
+			userID = user.ID.Int64
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
 
-func (sc *SecurityConfiguration) getUserInfoFromToken(r *http.Request, token *oauth2.Token) (*UserInfo, error) {
-	client := sc.oauthConfig.Client(context.Background(), token)
-	resp, err := client.Get(sc.oauthConfig.UserEndpoint)
-	if err != nil {
-		return nil, err
+	if userID != 0 {
+		newIdentity.UserID.Scan(userID)
+		_, err := sc.identityDAO.Create(ctx, newIdentity)
+		return userID, err
+	}
+
+	user := &model.User{}
+	user.FirstName.Scan(identity.firstName)
+	user.LastName.Scan(identity.lastName)
+	user.Name.Scan(identity.name)
+	user.PictureURL.Scan(identity.pictureURL)
+	// Emails grant administration and link other identities, so users only
+	// get the ones proven to be theirs.
+	if provider.config.TrustEmail && identity.emailVerified {
+		user.Email.Scan(identity.email)
+	} else {
+		user.Email.Scan("")
 	}
-	defer resp.Body.Close()
 
-	var userInfo UserInfo
-	err = json.NewDecoder(resp.Body).Decode(&userInfo)
+	user, err = sc.identityDAO.CreateWithUser(ctx, user, newIdentity)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	return user.ID.Int64, nil
+}
+
+func (sc *SecurityConfiguration) provider(name string) *Provider {
+	for _, provider := range sc.providers {
+		if provider.Name() == name {
+			return provider
+		}
 	}
+	return nil
+}
+
+func loginPath(provider *Provider) string {
+	return "/login/" + provider.Name()
+}
 
-	return &userInfo, nil
+// clearOAuthLogin removes the state of a login with a provider.
+func clearOAuthLogin(session *sessions.Session) {
+	delete(session.Values, oauthStateKey)
+	delete(session.Values, oauthNonceKey)
+	delete(session.Values, oauthVerifierKey)
+	delete(session.Values, providerKey)
+	delete(session.Values, redirectBackKey)
 }
 
 func isAuthenticated(session *sessions.Session) bool {
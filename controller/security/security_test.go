@@ -0,0 +1,407 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	testClientID    = "store"
+	testRedirectURL = "http://store.test/oauth/code"
+)
+
+// TestMain runs the tests against a fresh SQLite database, in which the
+// logins create users and identities.
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "security-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	dao.Init(&dao.DBOptions{DriverName: "sqlite", ConnStr: "file:" + filepath.Join(dir, "store.db")})
+	defer dao.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := dao.Connect(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return m.Run()
+}
+
+// mockOIDCServer is an OpenID Connect provider, which requires PKCE. Its user
+// info endpoint returns a verified email that differs from the one in the ID
+// token, which is the only one to be trusted.
+type mockOIDCServer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	claims     map[string]any
+	nonces     map[string]string
+	challenges map[string]string
+	issued     int
+	wrongNonce bool
+}
+
+func newMockOIDCServer(t *testing.T, claims map[string]any) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &mockOIDCServer{key: key, claims: claims, nonces: map[string]string{}, challenges: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", mock.discovery)
+	mux.HandleFunc("/keys", mock.keys)
+	mux.HandleFunc("/token", mock.token)
+	mux.HandleFunc("/userinfo", mock.userInfo)
+	mock.Server = httptest.NewServer(mux)
+	t.Cleanup(mock.Close)
+
+	return mock
+}
+
+func (m *mockOIDCServer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                m.URL,
+		"authorization_endpoint":                m.URL + "/authorize",
+		"token_endpoint":                        m.URL + "/token",
+		"userinfo_endpoint":                     m.URL + "/userinfo",
+		"jwks_uri":                              m.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *mockOIDCServer) keys(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize plays the login page of the provider, returning the code it
+// would redirect the user back with.
+func (m *mockOIDCServer) authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	location, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, m.URL+"/authorize") {
+		t.Fatalf("expected a redirect to the provider, got %q", authURL)
+	}
+
+	if location.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected a PKCE challenge, got %q", authURL)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.issued++
+	code = fmt.Sprintf("code-%d", m.issued)
+	m.nonces[code] = location.Query().Get("nonce")
+	m.challenges[code] = location.Query().Get("code_challenge")
+	return code, location.Query().Get("state")
+}
+
+func (m *mockOIDCServer) token(w http.ResponseWriter, r *http.Request) {
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+
+	m.mu.Lock()
+	code := r.FormValue("code")
+	nonce, ok := m.nonces[code]
+	ok = ok && m.challenges[code] == base64.RawURLEncoding.EncodeToString(verifier[:])
+	delete(m.nonces, code)
+	if m.wrongNonce {
+		nonce = "another nonce"
+	}
+	m.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   m.URL,
+		"aud":   testClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range m.claims {
+		claims[name] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     m.sign(claims),
+	})
+}
+
+func (m *mockOIDCServer) userInfo(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"sub":            m.claims["sub"],
+		"email":          "attacker@example.com",
+		"email_verified": true,
+		"picture":        "https://example.com/picture.png",
+	})
+}
+
+func (m *mockOIDCServer) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestSecurity(providers ...*ProviderConfig) chi.Router {
+	return newTestSecurityWithMailer(LogMailer{}, providers...)
+}
+
+func newTestSecurityWithMailer(mailer Mailer, providers ...*ProviderConfig) chi.Router {
+	store := NewSessionStore(NewMemorySessionBackend(), &SessionOptions{
+		Keys:         []string{currentKey},
+		MaxAge:       time.Hour,
+		IdleTimeout:  time.Hour,
+		SecureCookie: true,
+	})
+
+	sc := NewSecurityConfiguration(nil, &OAuthConfiguration{
+		RedirectURL: testRedirectURL,
+		Providers:   providers,
+		LogoutPath:  "/logout",
+		HomePath:    "/store/",
+	}, &AccountConfiguration{
+		BaseURL:         "http://store.test",
+		Mailer:          mailer,
+		MaxFailedLogins: 3,
+		LockoutDuration: time.Hour,
+		VerificationTTL: time.Hour,
+		ResetTTL:        time.Hour,
+	}, store)
+
+	router := chi.NewMux()
+	sc.ConfigureRouter(router)
+	return router
+}
+
+func mockProviderConfig(mock *mockOIDCServer, trustEmail bool) *ProviderConfig {
+	return &ProviderConfig{Name: "mock", Issuer: mock.URL, ClientID: testClientID, ClientSecret: "secret", TrustEmail: trustEmail}
+}
+
+// login logs in through the mock provider, returning the response to the
+// callback.
+func login(t *testing.T, router chi.Router, mock *mockOIDCServer, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/login/mock", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected a redirect to the provider, got %d: %s", w.Code, w.Body)
+	}
+
+	code, state := mock.authorize(t, w.Header().Get("Location"))
+
+	callback := httptest.NewRequest(http.MethodGet, "/oauth/code?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	for _, cookie := range w.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, callback)
+	return w
+}
+
+func identityOf(t *testing.T, subject string) *model.Identity {
+	t.Helper()
+	identity, err := dao.NewIdentityDAO().GetByProviderSubject(context.Background(), "mock", subject)
+	if err != nil {
+		t.Fatalf("expected an identity for %s: %v", subject, err)
+	}
+	return identity
+}
+
+func TestLogin_CreatesUserFromIDToken(t *testing.T) {
+	mock := newMockOIDCServer(t, map[string]any{
+		"sub":            "new-user",
+		"email":          "new-user@example.com",
+		"email_verified": true,
+		"name":           "New User",
+	})
+	router := newTestSecurity(mockProviderConfig(mock, true))
+
+	if w := login(t, router, mock); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected to be logged in, got %d", w.Code)
+	}
+
+	user, err := dao.NewUserDAO().GetByID(context.Background(), identityOf(t, "new-user").UserID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email.String != "new-user@example.com" || user.Name.String != "New User" {
+		t.Errorf("expected the profile of the ID token, got %+v", user)
+	}
+	if user.PictureURL.String != "https://example.com/picture.png" {
+		t.Errorf("expected the picture from the user info, got %q", user.PictureURL.String)
+	}
+}
+
+func TestLogin_LinksVerifiedEmailOfTrustedProvider(t *testing.T) {
+	existing, err := dao.NewUserDAO().Create(context.Background(), &model.User{
+		Name:       model.NullStringJSON{String: "Existing", Valid: true},
+		FirstName:  model.NullStringJSON{String: "Existing", Valid: true},
+		LastName:   model.NullStringJSON{String: "User", Valid: true},
+		PictureURL: model.NullStringJSON{String: "", Valid: true},
+		Email:      model.NullStringJSON{String: "existing@example.com", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]any{"sub": "existing", "email": "existing@example.com", "email_verified": true}
+	mock := newMockOIDCServer(t, claims)
+	login(t, newTestSecurity(mockProviderConfig(mock, true)), mock)
+	if identityOf(t, "existing").UserID != existing.ID {
+		t.Error("expected the identity to be linked to the user with the verified email")
+	}
+
+	claims["sub"], claims["email_verified"] = "unverified", false
+	login(t, newTestSecurity(mockProviderConfig(mock, true)), mock)
+	if identityOf(t, "unverified").UserID == existing.ID {
+		t.Error("expected an unverified email not to be linked")
+	}
+}
+
+func TestLogin_KeepsUnprovenEmailsOffUsers(t *testing.T) {
+	// An untrusted provider could claim any email, an administrator's too.
+	mock := newMockOIDCServer(t, map[string]any{"sub": "claimer", "email": "admin@example.com", "email_verified": true})
+	login(t, newTestSecurity(mockProviderConfig(mock, false)), mock)
+
+	user, err := dao.NewUserDAO().GetByID(context.Background(), identityOf(t, "claimer").UserID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email.String != "" {
+		t.Errorf("expected no email for the user, got %q", user.Email.String)
+	}
+	if identityOf(t, "claimer").Email.String != "admin@example.com" {
+		t.Error("expected the identity to keep the email it claims")
+	}
+}
+
+func TestLogin_DropsUnverifiedPasswordWhenLinking(t *testing.T) {
+	mailer := &recordingMailer{}
+	mock := newMockOIDCServer(t, map[string]any{"sub": "victim", "email": "victim@example.com", "email_verified": true})
+	router := newTestSecurityWithMailer(mailer, mockProviderConfig(mock, true))
+
+	// Someone else registers the email first, without being able to verify it.
+	if w := postJSON(router, registerPath, `{"firstName":"Not","lastName":"Victim","email":"victim@example.com","password":"attacker password"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("expected the registration to be accepted, got %d: %s", w.Code, w.Body)
+	}
+
+	login(t, router, mock)
+	userID := identityOf(t, "victim").UserID.Int64
+	if _, err := dao.NewCredentialDAO().GetByUserID(context.Background(), userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the unverified password to be dropped, got %v", err)
+	}
+
+	// The link emailed before the identity was linked must not bring it back.
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, mailer.lastLink(t, "victim@example.com"), nil))
+	if w := passwordLoginAs(router, "victim@example.com", "attacker password"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the unverified password not to log in, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestLogin_LinksIdentityToLoggedInUser(t *testing.T) {
+	mock := newMockOIDCServer(t, map[string]any{"sub": "first", "email": "first@example.com"})
+	router := newTestSecurity(mockProviderConfig(mock, false))
+	w := login(t, router, mock)
+
+	mock.claims = map[string]any{"sub": "second", "email": "second@example.com"}
+	login(t, router, mock, w.Result().Cookies()...)
+
+	if identityOf(t, "first").UserID != identityOf(t, "second").UserID {
+		t.Error("expected both identities to belong to one user")
+	}
+}
+
+func TestLogin_RejectsWrongNonce(t *testing.T) {
+	mock := newMockOIDCServer(t, map[string]any{"sub": "replayed", "email": "replayed@example.com"})
+	mock.wrongNonce = true
+
+	if w := login(t, newTestSecurity(mockProviderConfig(mock, false)), mock); w.Code == http.StatusTemporaryRedirect {
+		t.Error("expected the login to fail")
+	}
+	if _, err := dao.NewIdentityDAO().GetByProviderSubject(context.Background(), "mock", "replayed"); err == nil {
+		t.Error("expected no identity to be created")
+	}
+}
+
+func TestListProviders(t *testing.T) {
+	router := newTestSecurity(&ProviderConfig{Name: "github", DisplayName: "GitHub"}, &ProviderConfig{Name: "keycloak"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login/providers", nil))
+
+	var providers []providerInfo
+	if err := json.NewDecoder(w.Body).Decode(&providers); err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 2 || providers[0].DisplayName != "GitHub" || providers[1].LoginURL != "/login/keycloak" {
+		t.Errorf("unexpected providers %+v", providers)
+	}
+}
+
+func TestClaimString_NumericSubject(t *testing.T) {
+	if subject := claimString(map[string]any{"id": json.Number("12345678901")}, "id"); subject != "12345678901" {
+		t.Errorf("expected the numeric ID as a string, got %q", subject)
+	}
+	if subject := claimString(map[string]any{"id": float64(42)}, "id"); subject != "42" {
+		t.Errorf("expected 42, got %q", subject)
+	}
+}
@@ -0,0 +1,347 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vladoiliev02/online-store/controller"
+	"github.com/vladoiliev02/online-store/logging"
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	maxUserAgentLength = 512
+
+	// maxTouchInterval bounds how often the last use of a session is written.
+	maxTouchInterval = time.Minute
+)
+
+// SessionBackend persists the sessions of a SessionStore. Lookups of unknown
+// sessions fail with sql.ErrNoRows.
+type SessionBackend interface {
+	GetByTokenHash(ctx context.Context, tokenHash string) (*model.Session, error)
+	GetActiveByUserID(ctx context.Context, userID int64, now, idleSince time.Time) ([]*model.Session, error)
+	Create(ctx context.Context, session *model.Session) (*model.Session, error)
+	Update(ctx context.Context, session *model.Session) (*model.Session, error)
+	Delete(ctx context.Context, id int64) error
+	DeleteByUser(ctx context.Context, userID, id int64) error
+	DeleteByUserID(ctx context.Context, userID int64) error
+	DeleteExpired(ctx context.Context, now, idleSince time.Time) error
+}
+
+type SessionOptions struct {
+	// Keys authenticate the session cookies. The first one signs new cookies,
+	// the others are previous keys that are still accepted, so that the key
+	// can be rotated without logging everyone out.
+	Keys []string
+	// MaxAge is the lifetime of a session, IdleTimeout ends it earlier when
+	// it is not used.
+	MaxAge      time.Duration
+	IdleTimeout time.Duration
+	// SecureCookie sends the cookie over HTTPS only.
+	SecureCookie bool
+}
+
+// SessionStore is a sessions.Store that keeps the values of the sessions in a
+// SessionBackend. The cookie only holds a random token, so that sessions can
+// be listed and revoked.
+type SessionStore struct {
+	backend      SessionBackend
+	codecs       []securecookie.Codec
+	maxAge       time.Duration
+	idleTimeout  time.Duration
+	secureCookie bool
+}
+
+// sessionState is what the store knows about a loaded session. It is kept in
+// the values of the session under stateKey and is not persisted.
+type sessionState struct {
+	record *model.Session
+	// reissue is set when the cookie is signed by a previous key.
+	reissue bool
+	// renew replaces the token on the next save, see Renew.
+	renew bool
+}
+
+type stateKey struct{}
+
+func NewSessionStore(backend SessionBackend, options *SessionOptions) *SessionStore {
+	codecs := make([]securecookie.Codec, 0, len(options.Keys))
+	for _, key := range options.Keys {
+		codec := securecookie.New([]byte(key), nil)
+		codec.MaxAge(int(options.MaxAge.Seconds()))
+		codecs = append(codecs, codec)
+	}
+
+	return &SessionStore{
+		backend:      backend,
+		codecs:       codecs,
+		maxAge:       options.MaxAge,
+		idleTimeout:  options.IdleTimeout,
+		secureCookie: options.SecureCookie,
+	}
+}
+
+// Get returns the session of the request, loading it once per request.
+func (s *SessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the cookie of the request. A missing,
+// invalid, expired or revoked cookie results in a new session.
+//
+// The cookie is not sent with cross-site requests other than top-level
+// navigations, which the identity providers redirect back with.
+func (s *SessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   int(s.maxAge.Seconds()),
+		Secure:   s.secureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	token, keyIndex, ok := s.decodeToken(name, cookie.Value)
+	if !ok {
+		return session, nil
+	}
+
+	record, err := s.backend.GetByTokenHash(r.Context(), hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	if s.expired(record, time.Now().UTC()) {
+		if err := s.backend.Delete(r.Context(), record.ID.Int64); err != nil {
+			logging.FromContext(r.Context()).Warn("cannot delete expired session", "error", err)
+		}
+		return session, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(record.Data)).Decode(&session.Values); err != nil {
+		logging.FromContext(r.Context()).Warn("cannot decode session", "error", err)
+		return session, nil
+	}
+
+	session.ID = token
+	session.IsNew = false
+	session.Values[stateKey{}] = &sessionState{record: record, reissue: keyIndex > 0}
+	return session, nil
+}
+
+// Save stores the session and sets its cookie. A negative MaxAge deletes it.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	ctx := r.Context()
+	state, _ := session.Values[stateKey{}].(*sessionState)
+
+	if session.Options.MaxAge < 0 {
+		if state != nil {
+			if err := s.backend.Delete(ctx, state.record.ID.Int64); err != nil {
+				return err
+			}
+			delete(session.Values, stateKey{})
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	data, err := encodeValues(session.Values)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var record *model.Session
+	if state == nil || state.renew {
+		if state != nil {
+			if err := s.backend.Delete(ctx, state.record.ID.Int64); err != nil {
+				return err
+			}
+		}
+
+		token, err := generateRandomString(32)
+		if err != nil {
+			return err
+		}
+
+		record = &model.Session{
+			TokenHash:  hashToken(token),
+			UserAgent:  truncate(r.UserAgent(), maxUserAgentLength),
+			IPAddress:  clientIP(r),
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(time.Duration(session.Options.MaxAge) * time.Second),
+		}
+		setSessionUser(record, session)
+		record.Data = data
+
+		if record, err = s.backend.Create(ctx, record); err != nil {
+			return err
+		}
+		session.ID = token
+	} else {
+		record = state.record
+		setSessionUser(record, session)
+		record.Data = data
+		record.LastSeenAt = now
+
+		if _, err := s.backend.Update(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	session.Values[stateKey{}] = &sessionState{record: record}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	options := *session.Options
+	options.MaxAge = int(record.ExpiresAt.Sub(now).Seconds())
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, &options))
+	return nil
+}
+
+// Renew replaces the token of the session when it is saved next, so that a
+// token obtained before logging in cannot be used afterwards.
+func (s *SessionStore) Renew(session *sessions.Session) {
+	if state, ok := session.Values[stateKey{}].(*sessionState); ok {
+		state.renew = true
+	}
+}
+
+// Touch records that the session is in use, which postpones its idle timeout.
+// The backend is written at most once per touch interval, or right away to
+// sign the cookie with the current key.
+func (s *SessionStore) Touch(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	state, ok := session.Values[stateKey{}].(*sessionState)
+	if !ok || (!state.reissue && time.Since(state.record.LastSeenAt) < s.touchInterval()) {
+		return nil
+	}
+
+	return s.Save(r, w, session)
+}
+
+// GetByUserID returns the active sessions of a user.
+func (s *SessionStore) GetByUserID(ctx context.Context, userID int64) ([]*model.Session, error) {
+	now := time.Now().UTC()
+	return s.backend.GetActiveByUserID(ctx, userID, now, now.Add(-s.idleTimeout))
+}
+
+// Revoke ends a session of a user. It fails with sql.ErrNoRows if the user has
+// no such session.
+func (s *SessionStore) Revoke(ctx context.Context, userID, id int64) error {
+	return s.backend.DeleteByUser(ctx, userID, id)
+}
+
+// RevokeAll logs a user out everywhere.
+func (s *SessionStore) RevokeAll(ctx context.Context, userID int64) error {
+	return s.backend.DeleteByUserID(ctx, userID)
+}
+
+// DeleteExpiredEvery deletes the expired sessions every interval until done
+// is closed.
+func (s *SessionStore) DeleteExpiredEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UTC()
+		if err := s.backend.DeleteExpired(context.Background(), now, now.Add(-s.idleTimeout)); err != nil {
+			slog.Warn("Cannot delete expired sessions", "error", err)
+		}
+	}
+}
+
+func (s *SessionStore) decodeToken(name, value string) (token string, keyIndex int, ok bool) {
+	for i, codec := range s.codecs {
+		if err := codec.Decode(name, value, &token); err == nil {
+			return token, i, true
+		}
+	}
+	return "", 0, false
+}
+
+func (s *SessionStore) expired(record *model.Session, now time.Time) bool {
+	return !now.Before(record.ExpiresAt) || !now.Before(record.LastSeenAt.Add(s.idleTimeout))
+}
+
+func (s *SessionStore) touchInterval() time.Duration {
+	return min(maxTouchInterval, s.idleTimeout/10)
+}
+
+// sessionID returns the ID of a stored session, or 0 for a new one.
+func sessionID(session *sessions.Session) int64 {
+	if state, ok := session.Values[stateKey{}].(*sessionState); ok {
+		return state.record.ID.Int64
+	}
+	return 0
+}
+
+func setSessionUser(record *model.Session, session *sessions.Session) {
+	userID, ok := session.Values[controller.UserIDKey].(int64)
+	record.UserID = model.NullInt64JSON{Int64: userID, Valid: ok && userID != 0}
+}
+
+func encodeValues(values map[interface{}]interface{}) ([]byte, error) {
+	persisted := make(map[interface{}]interface{}, len(values))
+	for key, value := range values {
+		if _, ok := key.(stateKey); !ok {
+			persisted[key] = value
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(persisted); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func truncate(s string, length int) string {
+	if len(s) > length {
+		return s[:length]
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/controller"
+)
+
+const (
+	currentKey  = "current-key-0123456789abcdef0123"
+	previousKey = "previous-key-0123456789abcdef012"
+)
+
+func newTestStore(backend SessionBackend, keys ...string) *SessionStore {
+	return NewSessionStore(backend, &SessionOptions{Keys: keys, MaxAge: time.Hour, IdleTimeout: 10 * time.Minute})
+}
+
+// roundTrip saves a session with a user, returning the cookie it sets.
+func roundTrip(t *testing.T, store *SessionStore, userID int64) *http.Cookie {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values[controller.UserIDKey] = userID
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a session cookie, got %v", cookies)
+	}
+	return cookies[0]
+}
+
+func load(t *testing.T, store *SessionStore, cookie *http.Cookie) (*http.Request, map[interface{}]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+
+	session, err := store.Get(r, sessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, session.Values
+}
+
+func TestSessionStore_LoadsAndRevokes(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	store := newTestStore(backend, currentKey)
+	cookie := roundTrip(t, store, 7)
+
+	if _, values := load(t, store, cookie); values[controller.UserIDKey] != int64(7) {
+		t.Fatalf("expected the user of the session, got %v", values)
+	}
+
+	sessions, err := store.GetByUserID(context.Background(), 7)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("expected one session, got %v, %v", sessions, err)
+	}
+	if err := store.Revoke(context.Background(), 8, sessions[0].ID.Int64); err == nil {
+		t.Error("expected revoking the session of another user to fail")
+	}
+	if err := store.RevokeAll(context.Background(), 7); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, values := load(t, store, cookie); values[controller.UserIDKey] != nil {
+		t.Errorf("expected a new session after revoking, got %v", values)
+	}
+}
+
+func TestSessionStore_IdleTimeout(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	store := newTestStore(backend, currentKey)
+	cookie := roundTrip(t, store, 7)
+
+	backend.sessions[1].LastSeenAt = time.Now().UTC().Add(-11 * time.Minute)
+
+	if _, values := load(t, store, cookie); values[controller.UserIDKey] != nil {
+		t.Errorf("expected the idle session to have expired, got %v", values)
+	}
+	if len(backend.sessions) != 0 {
+		t.Error("expected the idle session to be deleted")
+	}
+}
+
+func TestSessionStore_RotatesKeys(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	cookie := roundTrip(t, newTestStore(backend, previousKey), 7)
+
+	if _, values := load(t, newTestStore(backend, currentKey), cookie); values[controller.UserIDKey] != nil {
+		t.Fatal("expected a cookie signed with an unknown key to be rejected")
+	}
+
+	store := newTestStore(backend, currentKey, previousKey)
+	r, values := load(t, store, cookie)
+	if values[controller.UserIDKey] != int64(7) {
+		t.Fatal("expected a cookie signed with a previous key to be accepted")
+	}
+
+	w := httptest.NewRecorder()
+	session, _ := store.Get(r, sessionName)
+	if err := store.Touch(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	reissued := w.Result().Cookies()
+	if len(reissued) != 1 {
+		t.Fatal("expected the cookie to be signed with the current key")
+	}
+	if _, values := load(t, newTestStore(backend, currentKey), reissued[0]); values[controller.UserIDKey] != int64(7) {
+		t.Error("expected the reissued cookie to be accepted with the current key only")
+	}
+}
+
+func TestSessionStore_RenewReplacesToken(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	store := newTestStore(backend, currentKey)
+	cookie := roundTrip(t, store, 7)
+
+	r, _ := load(t, store, cookie)
+	session, _ := store.Get(r, sessionName)
+	store.Renew(session)
+	w := httptest.NewRecorder()
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, values := load(t, store, cookie); values[controller.UserIDKey] != nil {
+		t.Error("expected the previous token to be invalid")
+	}
+	if _, values := load(t, store, w.Result().Cookies()[0]); values[controller.UserIDKey] != int64(7) {
+		t.Error("expected the new token to carry the session")
+	}
+}
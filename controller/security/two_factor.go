@@ -0,0 +1,124 @@
+package security
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/vladoiliev02/online-store/controller"
+	"github.com/vladoiliev02/online-store/logging"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	twoFactorKey        = "twoFactor"
+	pendingUserKey      = "pendingUser"
+	pendingSinceKey     = "pendingSince"
+	pendingAttemptsKey  = "pendingAttempts"
+	twoFactorPath       = "/account/two-factor"
+	twoFactorPage       = "/store/two-factor"
+	pendingLoginTimeout = 5 * time.Minute
+	// maxTwoFactorAttempts bounds the codes that can be guessed per login
+	// with the first factor.
+	maxTwoFactorAttempts = 5
+)
+
+type twoFactorRequired struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	Next              string `json:"next"`
+}
+
+type twoFactorLogin struct {
+	Code string `json:"code"`
+}
+
+// firstFactorPassed logs in a user that proved their identity with a provider
+// or a password. If they enabled two-factor authentication, the session waits
+// for their code instead, and pending is true.
+func (sc *SecurityConfiguration) firstFactorPassed(w http.ResponseWriter, r *http.Request, session *sessions.Session, userID int64) (pending bool, err error) {
+	// Linking an identity does not ask a logged in user for their code again.
+	if current, _ := session.Values[controller.UserIDKey].(int64); current == userID {
+		passed, _ := session.Values[twoFactorKey].(bool)
+		return false, sc.logIn(w, r, session, userID, passed)
+	}
+
+	twoFactor, err := sc.twoFactorDAO.GetByUserID(r.Context(), userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if err != nil || !twoFactor.Enabled {
+		return false, sc.logIn(w, r, session, userID, false)
+	}
+
+	sc.store.Renew(session)
+	delete(session.Values, controller.UserIDKey)
+	delete(session.Values, twoFactorKey)
+	session.Values[pendingUserKey] = userID
+	session.Values[pendingSinceKey] = time.Now().Unix()
+	session.Values[pendingAttemptsKey] = 0
+	return true, sc.store.Save(r, w, session)
+}
+
+// verifyTwoFactor completes a pending login with a code of the authenticator
+// of the user or one of their recovery codes.
+func (sc *SecurityConfiguration) verifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	login, err := decodeAccountRequest[twoFactorLogin](w, r)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+
+	session, err := sc.store.Get(r, sessionName)
+	if err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+
+	userID, ok := session.Values[pendingUserKey].(int64)
+	since, _ := session.Values[pendingSinceKey].(int64)
+	attempts, _ := session.Values[pendingAttemptsKey].(int)
+	if !ok || time.Since(time.Unix(since, 0)) > pendingLoginTimeout || attempts >= maxTwoFactorAttempts {
+		if ok {
+			clearPendingLogin(session)
+			if err := sc.store.Save(r, w, session); err != nil {
+				logging.FromContext(ctx).Warn("cannot save session", "error", err)
+			}
+		}
+		controller.WriteError(&controller.HTTPError{Code: http.StatusUnauthorized, Message: "No pending login, log in again"}, w, r)
+		return
+	}
+
+	passed, err := controller.VerifyTwoFactor(ctx, userID, login.Code)
+	if err != nil {
+		controller.WriteError(&controller.HTTPError{Code: http.StatusInternalServerError, Message: "Cannot verify code", Err: err}, w, r)
+		return
+	}
+	if !passed {
+		session.Values[pendingAttemptsKey] = attempts + 1
+		if err := sc.store.Save(r, w, session); err != nil {
+			controller.WriteError(err, w, r)
+			return
+		}
+		logging.FromContext(ctx).Warn("invalid two-factor code", "user_id", userID)
+		controller.WriteError(&controller.HTTPError{Code: http.StatusUnauthorized, Message: "Invalid code"}, w, r)
+		return
+	}
+
+	if err := sc.logIn(w, r, session, userID, true); err != nil {
+		controller.WriteError(err, w, r)
+		return
+	}
+
+	logging.FromContext(ctx).Info("user passed two-factor authentication", "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func clearPendingLogin(session *sessions.Session) {
+	delete(session.Values, pendingUserKey)
+	delete(session.Values, pendingSinceKey)
+	delete(session.Values, pendingAttemptsKey)
+}
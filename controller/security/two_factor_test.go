@@ -0,0 +1,118 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vladoiliev02/online-store/controller"
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/twofactor"
+)
+
+func TestTwoFactor_LoginWaitsForCode(t *testing.T) {
+	router := newAccountTestRouter(LogMailer{})
+	router.Get("/two-factor-passed", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strconv.FormatBool(controller.GetContextParam[bool](controller.TwoFactorKey, r.Context()))))
+	})
+
+	userID := createVerifiedAccount(t, "seller@example.com", "correct horse")
+	ctx := context.Background()
+	if err := dao.NewTwoFactorDAO().CreatePending(ctx, userID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.NewTwoFactorDAO().Enable(ctx, userID, 0, []string{twofactor.HashRecoveryCode("abcde-fghjk")}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := passwordLoginAs(router, "seller@example.com", "correct horse")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected the login to wait for a code, got %d: %s", w.Code, w.Body)
+	}
+	cookies := w.Result().Cookies()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	verify := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, twoFactorPath, strings.NewReader(`{"code":"`+code+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if len(w.Result().Cookies()) > 0 {
+			cookies = w.Result().Cookies()
+		}
+		return w
+	}
+
+	if w := get("/whoami"); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected the session not to be logged in before the code, got %d: %s", w.Code, w.Body)
+	}
+	if w := verify("000000"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong code to be refused, got %d", w.Code)
+	}
+	if w := verify("ABCDE-FGHJK"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected the recovery code to log in, got %d: %s", w.Code, w.Body)
+	}
+
+	if w := get("/whoami"); w.Body.String() != strconv.FormatInt(userID, 10) {
+		t.Errorf("expected the session of user %d, got %d: %s", userID, w.Code, w.Body)
+	}
+	if w := get("/two-factor-passed"); w.Body.String() != "true" {
+		t.Errorf("expected the session to have passed two-factor authentication, got %s", w.Body)
+	}
+
+	if w := passwordLoginAs(router, "seller@example.com", "correct horse"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected the login to wait for a code, got %d", w.Code)
+	}
+	cookies = w.Result().Cookies()
+	if w := verify("abcde-fghjk"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the recovery code to work once, got %d", w.Code)
+	}
+}
+
+func TestTwoFactor_LimitsAttempts(t *testing.T) {
+	router := newAccountTestRouter(LogMailer{})
+	userID := createVerifiedAccount(t, "guessed@example.com", "correct horse")
+	ctx := context.Background()
+	if err := dao.NewTwoFactorDAO().CreatePending(ctx, userID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.NewTwoFactorDAO().Enable(ctx, userID, 0, []string{twofactor.HashRecoveryCode("abcde-fghjk")}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := passwordLoginAs(router, "guessed@example.com", "correct horse")
+	cookies := w.Result().Cookies()
+	for i := 0; i <= maxTwoFactorAttempts; i++ {
+		r := httptest.NewRequest(http.MethodPost, twoFactorPath, strings.NewReader(`{"code":"000000"}`))
+		r.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, twoFactorPath, strings.NewReader(`{"code":"abcde-fghjk"}`))
+	r.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the pending login to end after too many codes, got %d", w.Code)
+	}
+}
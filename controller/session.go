@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/vladoiliev02/online-store/model"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const sessionIdCtxKey = "sessionId"
+
+// SessionManager lists and revokes the sessions of users. It is implemented by
+// the session store of the security package, see SetSessionManager.
+type SessionManager interface {
+	GetByUserID(ctx context.Context, userID int64) ([]*model.Session, error)
+	// Revoke fails with sql.ErrNoRows if the user has no such session.
+	Revoke(ctx context.Context, userID, id int64) error
+	RevokeAll(ctx context.Context, userID int64) error
+}
+
+var sessionManager SessionManager
+
+// SetSessionManager provides the sessions served by the session endpoints. It
+// has to be called before Router.
+func SetSessionManager(manager SessionManager) {
+	sessionManager = manager
+}
+
+func newSessionRouter() chi.Router {
+	sessionController := newSessionController()
+	r := chi.NewRouter()
+
+	r.Get("/", ControllerHandler(sessionController.getAll))
+	r.Delete("/", ControllerHandler(sessionController.deleteAll))
+
+	r.Route("/{sessionId}", func(r chi.Router) {
+		r.Use(numericPathVariableExtractor(sessionIdCtxKey))
+		r.Delete("/", ControllerHandler(sessionController.delete))
+	})
+
+	return r
+}
+
+type sessionController struct {
+	sessions SessionManager
+}
+
+func newSessionController() *sessionController {
+	return &sessionController{
+		sessions: sessionManager,
+	}
+}
+
+func (s *sessionController) getAll(r *http.Request) (*HTTPResponse[[]*model.Session], error) {
+	sessions, err := s.sessions.GetByUserID(r.Context(), GetContextParam[int64](UserIDKey, r.Context()))
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get sessions", Err: err}
+	}
+
+	currentID := GetContextParam[int64](SessionIDKey, r.Context())
+	for _, session := range sessions {
+		session.Current = session.ID.Int64 == currentID
+	}
+
+	return NewOKResponse(sessions), nil
+}
+
+func (s *sessionController) delete(r *http.Request) (*HTTPResponse[any], error) {
+	id := GetContextParam[int64](sessionIdCtxKey, r.Context())
+
+	err := s.sessions.Revoke(r.Context(), GetContextParam[int64](UserIDKey, r.Context()), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "Session not found", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot revoke session", Err: err}
+	}
+
+	return NewStatusResponse[any](http.StatusNoContent), nil
+}
+
+// deleteAll logs the current user out on every device, including this one.
+func (s *sessionController) deleteAll(r *http.Request) (*HTTPResponse[any], error) {
+	if err := s.sessions.RevokeAll(r.Context(), GetContextParam[int64](UserIDKey, r.Context())); err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot revoke sessions", Err: err}
+	}
+
+	return NewStatusResponse[any](http.StatusNoContent), nil
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestControllerHandler_TracesHandlerAndQueries(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	// The stub driver fails every query, so the product is not found.
+	w := httptest.NewRecorder()
+	Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	handler, query := spans["productController.getById"], spans["ProductDAO.GetByID"]
+	if handler == nil || query == nil {
+		t.Fatalf("expected handler and query spans, got %v", spans)
+	}
+	if query.Parent().SpanID() != handler.SpanContext().SpanID() {
+		t.Errorf("expected the query span to be a child of the handler span")
+	}
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+	"github.com/vladoiliev02/online-store/twofactor"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// twoFactorIssuer names the store in authenticator apps.
+const twoFactorIssuer = "Online Store"
+
+type twoFactorEnrolment struct {
+	Secret string `json:"secret"`
+	// URI is shown as a QR code for authenticator apps to scan.
+	URI string `json:"uri"`
+}
+
+type twoFactorCode struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// newTwoFactorRouter serves the enrolment of the current user in two-factor
+// authentication, which is then asked for after logging in, see
+// security.SecurityConfiguration.
+func newTwoFactorRouter() chi.Router {
+	twoFactorController := newTwoFactorController()
+	r := chi.NewRouter()
+
+	r.Get("/", ControllerHandler(twoFactorController.get))
+	r.Post("/", ControllerHandler(twoFactorController.enrol))
+	r.Post("/confirm", ControllerHandler(twoFactorController.confirm))
+	r.Post("/recovery-codes", ControllerHandler(twoFactorController.regenerateRecoveryCodes))
+	r.Post("/disable", ControllerHandler(twoFactorController.disable))
+
+	return r
+}
+
+type twoFactorController struct {
+	twoFactorDAO *dao.TwoFactorDAO
+	userDAO      *dao.UserDAO
+}
+
+func newTwoFactorController() *twoFactorController {
+	return &twoFactorController{
+		twoFactorDAO: dao.NewTwoFactorDAO(),
+		userDAO:      dao.NewUserDAO(),
+	}
+}
+
+func (t *twoFactorController) get(r *http.Request) (*HTTPResponse[*model.TwoFactor], error) {
+	userID := GetContextParam[int64](UserIDKey, r.Context())
+
+	twoFactor, err := t.twoFactorDAO.GetByUserID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NewOKResponse(&model.TwoFactor{UserID: model.NullInt64JSON{Int64: userID, Valid: true}}), nil
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get two-factor authentication", Err: err}
+	}
+
+	return NewOKResponse(twoFactor), nil
+}
+
+// enrol creates a secret for an authenticator app, which is enabled once the
+// user confirms it with a code. Enrolling again replaces a secret that was not
+// confirmed.
+func (t *twoFactorController) enrol(r *http.Request) (*HTTPResponse[*twoFactorEnrolment], error) {
+	userID := GetContextParam[int64](UserIDKey, r.Context())
+
+	user, err := t.userDAO.GetByID(r.Context(), userID)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get user", Err: err}
+	}
+
+	secret, err := twofactor.GenerateSecret()
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot generate secret", Err: err}
+	}
+
+	err = t.twoFactorDAO.CreatePending(r.Context(), userID, secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &HTTPError{Code: http.StatusConflict, Message: "Two-factor authentication is enabled already", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot enrol in two-factor authentication", Err: err}
+	}
+
+	return NewResponse(http.StatusCreated, &twoFactorEnrolment{
+		Secret: secret,
+		URI:    twofactor.ProvisioningURI(twoFactorIssuer, user.Email.String, secret),
+	}), nil
+}
+
+// confirm enables two-factor authentication with the first code of the
+// authenticator app and returns the recovery codes, which are not shown
+// again. It applies to the next login.
+func (t *twoFactorController) confirm(r *http.Request) (*HTTPResponse[*recoveryCodes], error) {
+	userID := GetContextParam[int64](UserIDKey, r.Context())
+
+	body, err := jsonUnmarshalBody[twoFactorCode](r)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor, err := t.twoFactorDAO.GetByUserID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && twoFactor.Enabled {
+		return nil, &HTTPError{Code: http.StatusConflict, Message: "No pending two-factor enrolment", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get two-factor authentication", Err: err}
+	}
+
+	step, ok := twofactor.Validate(twoFactor.Secret, normalizeCode(body.Code), time.Now(), 0)
+	if !ok {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid code"}
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.twoFactorDAO.Enable(r.Context(), userID, step, hashes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &HTTPError{Code: http.StatusConflict, Message: "No pending two-factor enrolment", Err: err}
+	}
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot enable two-factor authentication", Err: err}
+	}
+
+	return NewOKResponse(&recoveryCodes{RecoveryCodes: codes}), nil
+}
+
+// regenerateRecoveryCodes replaces the recovery codes of the user, who proves
+// to hold their authenticator with a code.
+func (t *twoFactorController) regenerateRecoveryCodes(r *http.Request) (*HTTPResponse[*recoveryCodes], error) {
+	userID := GetContextParam[int64](UserIDKey, r.Context())
+
+	if err := t.verify(r, userID); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.twoFactorDAO.ReplaceRecoveryCodes(r.Context(), userID, hashes); err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot replace recovery codes", Err: err}
+	}
+
+	return NewOKResponse(&recoveryCodes{RecoveryCodes: codes}), nil
+}
+
+// disable turns two-factor authentication off with a code, so that a stolen
+// session is not enough. Administrators have to keep it.
+func (t *twoFactorController) disable(r *http.Request) (*HTTPResponse[any], error) {
+	userID := GetContextParam[int64](UserIDKey, r.Context())
+
+	if IsAdmin(userID) {
+		return nil, &HTTPError{Code: http.StatusForbidden, Message: "Administrators have to use two-factor authentication"}
+	}
+
+	if err := t.verify(r, userID); err != nil {
+		return nil, err
+	}
+
+	if err := t.twoFactorDAO.Delete(r.Context(), userID); err != nil {
+		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot disable two-factor authentication", Err: err}
+	}
+
+	return NewStatusResponse[any](http.StatusNoContent), nil
+}
+
+func (t *twoFactorController) verify(r *http.Request, userID int64) error {
+	body, err := jsonUnmarshalBody[twoFactorCode](r)
+	if err != nil {
+		return err
+	}
+
+	ok, err := VerifyTwoFactor(r.Context(), userID, body.Code)
+	if err != nil {
+		return &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot verify code", Err: err}
+	}
+	if !ok {
+		return &HTTPError{Code: http.StatusBadRequest, Message: "Invalid code"}
+	}
+	return nil
+}
+
+// VerifyTwoFactor checks a code of the authenticator of a user or one of their
+// recovery codes. Every code works once.
+func VerifyTwoFactor(ctx context.Context, userID int64, code string) (bool, error) {
+	twoFactorDAO := dao.NewTwoFactorDAO()
+
+	twoFactor, err := twoFactorDAO.GetByUserID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !twoFactor.Enabled {
+		return false, nil
+	}
+
+	if step, ok := twofactor.Validate(twoFactor.Secret, normalizeCode(code), time.Now(), twoFactor.LastUsedStep); ok {
+		err = twoFactorDAO.UseStep(ctx, userID, step)
+	} else {
+		err = twoFactorDAO.UseRecoveryCode(ctx, userID, twofactor.HashRecoveryCode(code))
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	codes, err = twofactor.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot generate recovery codes", Err: err}
+	}
+
+	hashes = make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, twofactor.HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeCode removes the spaces authenticator apps show in codes.
+func normalizeCode(code string) string {
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -1,6 +1,8 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/vladoiliev02/online-store/dao"
@@ -15,11 +17,22 @@ func newUserRouter() chi.Router {
 
 	r.Get("/", ControllerHandler(userController.getAll))
 	r.Get("/me", ControllerHandler(userController.getLoggedInUser))
+	r.Group(func(r chi.Router) {
+		r.Use(RequireSession)
+		r.Delete("/me", ControllerHandler(userController.deleteLoggedInUser))
+		r.Delete("/me/deletion", ControllerHandler(userController.cancelDeletion))
+		r.Get("/me/export", ControllerHandler(userController.export))
+		r.Mount("/me/sessions", newSessionRouter())
+		r.Mount("/me/identities", newIdentityRouter())
+		r.Mount("/me/two-factor", newTwoFactorRouter())
+		r.Mount("/me/tokens", newAPITokenRouter())
+	})
 
 	r.Route("/{id}", func(r chi.Router) {
 		r.Use(numericPathVariableExtractor("id"))
 		r.Get("/", ControllerHandler(userController.getByID))
 		r.Put("/", ControllerHandler(userController.put))
+		r.Patch("/", ControllerHandler(userController.patch))
 	})
 
 	return r
@@ -38,16 +51,16 @@ func newUserController() *userController {
 func (u *userController) getByID(r *http.Request) (*HTTPResponse[*model.User], error) {
 	userId := GetContextParam[int64]("id", r.Context())
 
-	user, err := u.userDAO.GetByID(userId)
+	user, err := u.userDAO.GetByID(r.Context(), userId)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusNotFound, Message: "User not found", Err: err}
 	}
 
-	return NewOKResponse(user), nil
+	return conditionalGet(r, user, user.Version), nil
 }
 
 func (u *userController) getAll(r *http.Request) (*HTTPResponse[[]*model.User], error) {
-	users, err := u.userDAO.GetAll()
+	users, err := u.userDAO.GetAll(r.Context())
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get users", Err: err}
 	}
@@ -59,12 +72,12 @@ func (u *userController) getAll(r *http.Request) (*HTTPResponse[[]*model.User],
 func (u *userController) getLoggedInUser(r *http.Request) (*HTTPResponse[*model.User], error) {
 	userId := GetContextParam[int64](UserIDKey, r.Context())
 
-	users, err := u.userDAO.GetByID(userId)
+	user, err := u.userDAO.GetByID(r.Context(), userId)
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot get users", Err: err}
 	}
 
-	return NewOKResponse(users), nil
+	return conditionalGet(r, user, user.Version), nil
 
 }
 
@@ -75,16 +88,62 @@ func (u *userController) put(r *http.Request) (*HTTPResponse[*model.User], error
 		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Cannot update user", Err: nil}
 	}
 
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := jsonUnmarshalBody[model.User](r)
 	if err != nil {
 		return nil, err
 	}
 	user.ID.Scan(userId)
+	user.Version = version
+
+	model.NormalizeUser(user)
+	if err := model.ValidateUser(user, true); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid user", Err: err}
+	}
 
-	user, err = u.userDAO.Update(user)
+	return u.update(r.Context(), user)
+}
+
+func (u *userController) patch(r *http.Request) (*HTTPResponse[*model.User], error) {
+	userId := GetContextParam[int64](UserIDKey, r.Context())
+
+	if userId != GetContextParam[int64]("id", r.Context()) {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Cannot update user", Err: nil}
+	}
+
+	existing, err := u.userDAO.GetByID(r.Context(), userId)
+	if err != nil {
+		return nil, &HTTPError{Code: http.StatusNotFound, Message: "User not found", Err: err}
+	}
+
+	user, err := patchBody(r, existing, existing.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	user.ID = existing.ID
+	user.Email = existing.Email
+	user.Version = existing.Version
+	model.NormalizeUser(user)
+	if err := model.ValidateUser(user, true); err != nil {
+		return nil, &HTTPError{Code: http.StatusBadRequest, Message: "Invalid user", Err: err}
+	}
+
+	return u.update(r.Context(), user)
+}
+
+func (u *userController) update(ctx context.Context, user *model.User) (*HTTPResponse[*model.User], error) {
+	user, err := u.userDAO.Update(ctx, user)
+	if errors.Is(err, dao.ErrVersionMismatch) {
+		return nil, &HTTPError{Code: http.StatusPreconditionFailed, Message: "User was modified", Err: err}
+	}
 	if err != nil {
 		return nil, &HTTPError{Code: http.StatusInternalServerError, Message: "Cannot update user", Err: nil}
 	}
 
-	return NewOKResponse(user), nil
+	return withETag(NewOKResponse(user), user.Version), nil
 }
@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	insertAccountToken = `
+		INSERT INTO account_tokens(user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;
+	`
+
+	deleteAccountTokensOfUser = `
+		DELETE FROM account_tokens
+		WHERE user_id = $1 AND purpose = $2;
+	`
+
+	consumeAccountToken = `
+		DELETE FROM account_tokens
+		WHERE token_hash = $1 AND purpose = $2
+		RETURNING user_id, expires_at;
+	`
+)
+
+// AccountTokenDAO stores the one-time tokens emailed to verify an email or
+// reset a password.
+type AccountTokenDAO struct {
+	dao *DAO
+	qe  queryExecutor
+}
+
+func NewAccountTokenDAO() *AccountTokenDAO {
+	return newAccountTokenDAO(GetDAO().db)
+}
+
+func newAccountTokenDAO(qe queryExecutor) *AccountTokenDAO {
+	return &AccountTokenDAO{
+		dao: GetDAO(),
+		qe:  qe,
+	}
+}
+
+// Create stores a token, replacing the earlier tokens of the user for the same
+// purpose, so that only the latest email works and expired tokens do not pile
+// up.
+func (a *AccountTokenDAO) Create(ctx context.Context, token *model.AccountToken) (*model.AccountToken, error) {
+	if token == nil {
+		return nil, &DAOError{Query: insertAccountToken, Message: "Nil AccountToken"}
+	}
+
+	return executeInTransactionOf(ctx, a.dao.db, a.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.AccountToken, error) {
+			if err := executeNoRowsQuery(ctx, tx, deleteAccountTokensOfUser, token.UserID, token.Purpose); err != nil {
+				return nil, err
+			}
+
+			return executeSingleRowQuery(ctx, tx, propertyScanner(token, &token.ID),
+				insertAccountToken, token.UserID, token.Purpose, token.TokenHash, token.ExpiresAt)
+		})
+}
+
+// Consume deletes a token and returns the user it was issued to. It fails
+// with sql.ErrNoRows if the token is unknown, was used already or expired by
+// now.
+func (a *AccountTokenDAO) Consume(ctx context.Context, purpose, tokenHash string, now time.Time) (int64, error) {
+	token, err := executeSingleRowQuery(ctx, a.qe, func(row rowScanner) (*model.AccountToken, error) {
+		var token model.AccountToken
+		return propertyScanner(&token, &token.UserID, &token.ExpiresAt)(row)
+	}, consumeAccountToken, tokenHash, purpose)
+	if err != nil {
+		return 0, err
+	}
+
+	if !token.ExpiresAt.After(now) {
+		return 0, sql.ErrNoRows
+	}
+	return token.UserID.Int64, nil
+}
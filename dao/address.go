@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 
@@ -43,13 +44,13 @@ func newAddressDAO(qe queryExecutor) *AddressDAO {
 	}
 }
 
-func (a *AddressDAO) GetAddressByID(id int) (*model.Address, error) {
-	return executeSingleRowQuery(a.qe, scanAddress,
+func (a *AddressDAO) GetAddressByID(ctx context.Context, id int) (*model.Address, error) {
+	return executeSingleRowQuery(ctx, a.qe, scanAddress,
 		selectAddressByID, id)
 }
 
-func (a *AddressDAO) CreateAddress(address *model.Address) (*model.Address, error) {
-	existingAddress, err := a.GetAddress(address)
+func (a *AddressDAO) CreateAddress(ctx context.Context, address *model.Address) (*model.Address, error) {
+	existingAddress, err := a.GetAddress(ctx, address)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, &DAOError{Query: insertAddress, Message: "Failed to check if address already exists", Err: err}
 	}
@@ -58,12 +59,12 @@ func (a *AddressDAO) CreateAddress(address *model.Address) (*model.Address, erro
 		return existingAddress, nil
 	}
 
-	return executeSingleRowQuery(a.qe, propertyScanner(address, &address.ID),
+	return executeSingleRowQuery(ctx, a.qe, propertyScanner(address, &address.ID),
 		insertAddress, address.City, address.Country, address.Address, address.PostalCode)
 }
 
-func (a *AddressDAO) GetAddress(address *model.Address) (*model.Address, error) {
-	return executeSingleRowQuery(a.qe, scanAddress,
+func (a *AddressDAO) GetAddress(ctx context.Context, address *model.Address) (*model.Address, error) {
+	return executeSingleRowQuery(ctx, a.qe, scanAddress,
 		selectAddress, address.City, address.Country, address.Address, address.PostalCode)
 }
 
@@ -0,0 +1,100 @@
+package dao
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	selectAPITokens = `
+		SELECT id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at
+		FROM api_tokens
+	`
+
+	selectAPITokenByTokenHash = selectAPITokens +
+		"WHERE token_hash = $1;"
+
+	selectAPITokensByUserID = selectAPITokens +
+		"WHERE user_id = $1 ORDER BY id;"
+
+	insertAPIToken = `
+		INSERT INTO api_tokens(user_id, name, token_hash, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id;
+	`
+
+	updateAPITokenLastUsedAt = `
+		UPDATE api_tokens
+		SET last_used_at = $2
+		WHERE id = $1;
+	`
+
+	deleteUserAPIToken = `
+		DELETE FROM api_tokens
+		WHERE id = $1 AND user_id = $2
+		RETURNING user_id;
+	`
+)
+
+// APITokenDAO stores the personal access tokens of users.
+type APITokenDAO struct {
+	dao *DAO
+	qe  queryExecutor
+}
+
+func NewAPITokenDAO() *APITokenDAO {
+	return newAPITokenDAO(GetDAO().db)
+}
+
+func newAPITokenDAO(qe queryExecutor) *APITokenDAO {
+	return &APITokenDAO{
+		dao: GetDAO(),
+		qe:  qe,
+	}
+}
+
+func (a *APITokenDAO) GetByTokenHash(ctx context.Context, tokenHash string) (*model.APIToken, error) {
+	return executeSingleRowQuery(ctx, a.qe, scanAPIToken,
+		selectAPITokenByTokenHash, tokenHash)
+}
+
+func (a *APITokenDAO) GetByUserID(ctx context.Context, userID int64) ([]*model.APIToken, error) {
+	return executeMultiRowQuery(ctx, a.qe, scanAPIToken,
+		selectAPITokensByUserID, userID)
+}
+
+func (a *APITokenDAO) Create(ctx context.Context, token *model.APIToken) (*model.APIToken, error) {
+	if token == nil {
+		return nil, &DAOError{Query: insertAPIToken, Message: "Nil APIToken"}
+	}
+
+	return executeSingleRowQuery(ctx, a.qe, propertyScanner(token, &token.ID),
+		insertAPIToken, token.UserID, token.Name, token.TokenHash, strings.Join(token.Scopes, ","), token.CreatedAt, token.ExpiresAt)
+}
+
+func (a *APITokenDAO) UpdateLastUsedAt(ctx context.Context, id int64, lastUsedAt time.Time) error {
+	return executeNoRowsQuery(ctx, a.qe, updateAPITokenLastUsedAt, id, lastUsedAt)
+}
+
+// DeleteByUser revokes a token of a user. It fails with sql.ErrNoRows if the
+// user has no such token.
+func (a *APITokenDAO) DeleteByUser(ctx context.Context, userID, id int64) error {
+	_, err := executeSingleRowQuery(ctx, a.qe, scanUserID, deleteUserAPIToken, id, userID)
+	return err
+}
+
+func scanAPIToken(row rowScanner) (*model.APIToken, error) {
+	var token model.APIToken
+	var scopes string
+	if _, err := propertyScanner(&token,
+		&token.ID, &token.UserID, &token.Name, &token.TokenHash, &scopes,
+		&token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt)(row); err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Split(scopes, ",")
+	return &token, nil
+}
@@ -0,0 +1,50 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+func TestAPITokenDAO(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "api-token-user")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	token, err := NewAPITokenDAO().Create(ctx, &model.APIToken{
+		UserID:    user.ID,
+		Name:      model.NullStringJSON{String: "import script", Valid: true},
+		TokenHash: "api-token-hash",
+		Scopes:    []string{model.ReadScope, model.WriteScope},
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewAPITokenDAO().UpdateLastUsedAt(ctx, token.ID.Int64, now); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewAPITokenDAO().GetByTokenHash(ctx, "api-token-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Scopes) != 2 || loaded.LastUsedAt == nil || !loaded.LastUsedAt.Equal(now) || !loaded.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected token %+v", loaded)
+	}
+
+	other := createTestUser(t, "api-token-other")
+	if err := NewAPITokenDAO().DeleteByUser(ctx, other.ID.Int64, token.ID.Int64); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the token of another user to be kept, got %v", err)
+	}
+	if err := NewAPITokenDAO().DeleteByUser(ctx, user.ID.Int64, token.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, err := NewAPITokenDAO().GetByUserID(ctx, user.ID.Int64); err != nil || len(tokens) != 0 {
+		t.Errorf("expected the token to be revoked, got %v, %v", tokens, err)
+	}
+}
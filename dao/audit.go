@@ -0,0 +1,213 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	insertAuditEntry = `
+		INSERT INTO audit_log(actor_id, service, action, entity_type, entity_id, changes, request_id, ip_address, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+	`
+
+	// eraseAuditActorIPAddresses is the only change the audit log allows.
+	eraseAuditActorIPAddresses = `
+		UPDATE audit_log
+		SET ip_address = ''
+		WHERE actor_id = $1 AND ip_address != '';
+	`
+
+	// selectAuditEntries filters by the arguments that are not null, newest
+	// first. The arguments are cast, Postgres cannot tell their types from
+	// IS NULL.
+	selectAuditEntries = `
+		SELECT id, actor_id, service, action, entity_type, entity_id, changes, request_id, ip_address, created_at, COUNT(*) OVER ()
+		FROM audit_log
+		WHERE ($1::bigint IS NULL OR actor_id = $1::bigint)
+			AND ($2::text IS NULL OR entity_type = $2::text)
+			AND ($3::bigint IS NULL OR entity_id = $3::bigint)
+			AND ($4::text IS NULL OR action = $4::text)
+			AND ($5::timestamp IS NULL OR created_at >= $5::timestamp)
+			AND ($6::timestamp IS NULL OR created_at < $6::timestamp)
+		ORDER BY id DESC
+		LIMIT $7 OFFSET $8;
+	`
+)
+
+// Entity types of the audit log.
+const (
+	auditProduct = "product"
+	auditOrder   = "order"
+	auditUser    = "user"
+	auditComment = "comment"
+	auditImage   = "image"
+)
+
+// auditPersonalFields are the fields of entities that identify people. The
+// audit log records that they changed but not their values, so that deleting
+// a user erases them.
+var auditPersonalFields = map[string][]string{
+	auditUser:    {"name", "firstName", "lastName", "pictureUrl", "email", "address"},
+	auditComment: {"comment"},
+}
+
+// AuditActor is who makes the changes recorded in the audit log.
+type AuditActor struct {
+	// UserID is the user making the change, zero for services.
+	UserID    int64
+	Service   string
+	RequestID string
+	IPAddress string
+}
+
+type auditActorCtxKey struct{}
+
+// WithAuditActor records the changes made with the returned context as made
+// by actor.
+func WithAuditActor(ctx context.Context, actor AuditActor) context.Context {
+	return context.WithValue(ctx, auditActorCtxKey{}, actor)
+}
+
+func auditActorFrom(ctx context.Context) AuditActor {
+	actor, _ := ctx.Value(auditActorCtxKey{}).(AuditActor)
+	return actor
+}
+
+// AuditFilter selects entries of the audit log. Zero fields match any entry.
+type AuditFilter struct {
+	ActorID    int64
+	EntityType string
+	EntityID   int64
+	Action     string
+	// From and To bound the time of the entries, To exclusively.
+	From time.Time
+	To   time.Time
+}
+
+// AuditDAO reads the audit log, which the other DAOs append to in the
+// transactions of the changes they record.
+type AuditDAO struct {
+	dao *DAO
+	qe  queryExecutor
+}
+
+func NewAuditDAO() *AuditDAO {
+	return newAuditDAO(GetDAO().db)
+}
+
+func newAuditDAO(qe queryExecutor) *AuditDAO {
+	return &AuditDAO{
+		dao: GetDAO(),
+		qe:  qe,
+	}
+}
+
+// GetAll returns a page of the entries matching filter and the number of all
+// of them.
+func (a *AuditDAO) GetAll(ctx context.Context, filter *AuditFilter, page, pageSize int) ([]*model.AuditEntry, int64, error) {
+	pageSize, offset := getPageSizeAndOffset(pageSize, page)
+
+	var count int64
+	entries, err := executeMultiRowQuery(ctx, a.qe,
+		func(row rowScanner) (*model.AuditEntry, error) {
+			return scanAuditEntry(row, &count)
+		},
+		selectAuditEntries,
+		sql.NullInt64{Int64: filter.ActorID, Valid: filter.ActorID != 0},
+		sql.NullString{String: filter.EntityType, Valid: filter.EntityType != ""},
+		sql.NullInt64{Int64: filter.EntityID, Valid: filter.EntityID != 0},
+		sql.NullString{String: filter.Action, Valid: filter.Action != ""},
+		sql.NullTime{Time: filter.From.UTC(), Valid: !filter.From.IsZero()},
+		sql.NullTime{Time: filter.To.UTC(), Valid: !filter.To.IsZero()},
+		pageSize, offset)
+
+	return entries, count, err
+}
+
+// recordAudit appends the change of an entity from before to after to the
+// audit log, in the transaction tx that makes it. before is nil for created
+// entities and after for deleted ones. Nothing is recorded if no field
+// changed.
+func recordAudit(ctx context.Context, tx *sql.Tx, action, entityType string, entityID int64, before, after any) error {
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		return &DAOError{Query: insertAuditEntry, Message: "Cannot compare audited entity", Err: err}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, field := range auditPersonalFields[entityType] {
+		if _, ok := changes[field]; ok {
+			changes[field] = model.AuditChange{Redacted: true}
+		}
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return &DAOError{Query: insertAuditEntry, Message: "Cannot encode audited changes", Err: err}
+	}
+
+	actor := auditActorFrom(ctx)
+	return executeNoRowsQuery(ctx, tx, insertAuditEntry,
+		sql.NullInt64{Int64: actor.UserID, Valid: actor.UserID != 0}, actor.Service,
+		action, entityType, entityID, string(data),
+		actor.RequestID, actor.IPAddress, time.Now().UTC())
+}
+
+// auditChanges returns the top-level JSON fields that differ between before
+// and after.
+func auditChanges(before, after any) (map[string]model.AuditChange, error) {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]model.AuditChange{}
+	for name, value := range beforeFields {
+		if !bytes.Equal(value, afterFields[name]) {
+			changes[name] = model.AuditChange{Before: value, After: afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			changes[name] = model.AuditChange{After: value}
+		}
+	}
+	return changes, nil
+}
+
+func jsonFields(entity any) (map[string]json.RawMessage, error) {
+	if entity == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	return fields, json.Unmarshal(data, &fields)
+}
+
+func scanAuditEntry(row rowScanner, count *int64) (*model.AuditEntry, error) {
+	var entry model.AuditEntry
+	var changes string
+	if _, err := propertyScanner(&entry,
+		&entry.ID, &entry.ActorID, &entry.Service, &entry.Action, &entry.EntityType, &entry.EntityID,
+		&changes, &entry.RequestID, &entry.IPAddress, &entry.CreatedAt, count)(row); err != nil {
+		return nil, err
+	}
+
+	return &entry, json.Unmarshal([]byte(changes), &entry.Changes)
+}
@@ -0,0 +1,151 @@
+package dao
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+func TestAuditDAO_RecordsChanges(t *testing.T) {
+	ctx := testContext(t)
+	admin := createTestUser(t, "auditor")
+	product := createTestProduct(t, admin.ID.Int64, "Audited product", model.Home, 5)
+	start := time.Now().Add(-time.Minute)
+
+	actorCtx := WithAuditActor(ctx, AuditActor{UserID: admin.ID.Int64, RequestID: "audit-request", IPAddress: "192.0.2.1"})
+	if _, err := NewProductDAO().AdjustQuantity(actorCtx, product.ID.Int64, -2); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, count, err := NewAuditDAO().GetAll(ctx, &AuditFilter{EntityType: "product", EntityID: product.ID.Int64, From: start}, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(entries) != 1 {
+		t.Fatalf("expected one entry for the product, got %d of %d", len(entries), count)
+	}
+
+	entry := entries[0]
+	if entry.Action != model.AuditStockAdjust || entry.ActorID.Int64 != admin.ID.Int64 || entry.RequestID != "audit-request" || entry.IPAddress != "192.0.2.1" {
+		t.Errorf("expected the stock adjustment by the admin, got %+v", entry)
+	}
+	if change := entry.Changes["quantity"]; string(change.Before) != "5" || string(change.After) != "3" {
+		t.Errorf("expected the quantity to change from 5 to 3, got %s to %s", change.Before, change.After)
+	}
+	if _, ok := entry.Changes["name"]; ok {
+		t.Error("expected only the changed fields to be recorded")
+	}
+
+	if _, count, _ := NewAuditDAO().GetAll(ctx, &AuditFilter{EntityID: product.ID.Int64, To: start}, 1, 10); count != 0 {
+		t.Errorf("expected no entries before the change, got %d", count)
+	}
+}
+
+func TestAuditDAO_RollsBackWithTheChange(t *testing.T) {
+	ctx := testContext(t)
+	seller := createTestUser(t, "audit-rollback")
+	product := createTestProduct(t, seller.ID.Int64, "Scarce product", model.Home, 1)
+
+	if _, err := NewProductDAO().AdjustQuantity(ctx, product.ID.Int64, -2); err == nil {
+		t.Fatal("expected the stock not to become negative")
+	}
+
+	if _, count, _ := NewAuditDAO().GetAll(ctx, &AuditFilter{EntityType: "product", EntityID: product.ID.Int64}, 1, 10); count != 0 {
+		t.Errorf("expected no entry for a failed change, got %d", count)
+	}
+}
+
+func TestAuditDAO_AppendOnly(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "audit-deleted")
+
+	if err := NewUserDAO().Delete(ctx, user.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := NewAuditDAO().GetAll(ctx, &AuditFilter{Action: model.AuditUserDelete, EntityID: user.ID.Int64}, 1, 10)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected the deletion to be recorded, got %v, %v", entries, err)
+	}
+	if name := entries[0].Changes["name"]; !name.Redacted {
+		t.Errorf("expected the name to be redacted, got %+v", name)
+	}
+
+	if err := executeNoRowsQuery(ctx, GetDAO().db, "UPDATE audit_log SET action = 'none'"); err == nil {
+		t.Error("expected the audit log not to be updated")
+	}
+	if err := executeNoRowsQuery(ctx, GetDAO().db, "DELETE FROM audit_log"); err == nil {
+		t.Error("expected the audit log not to be deleted")
+	}
+}
+
+func TestAuditDAO_DeletedUserLeavesNoPersonalData(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "forgotten")
+	seller := createTestUser(t, "forgotten-seller")
+	product := createTestProduct(t, seller.ID.Int64, "Commented product", model.Home, 1)
+	userCtx := WithAuditActor(ctx, AuditActor{UserID: user.ID.Int64, RequestID: "forgotten-request", IPAddress: "198.51.100.7"})
+
+	user.Address = model.Address{
+		City:       model.NullStringJSON{String: "Ruse", Valid: true},
+		Country:    model.NullStringJSON{String: "Bulgaria", Valid: true},
+		Address:    model.NullStringJSON{String: "4 Danube St", Valid: true},
+		PostalCode: model.NullStringJSON{String: "7000", Valid: true},
+	}
+	user.LastName = model.NullStringJSON{String: "Forgettable", Valid: true}
+	if _, err := NewUserDAO().Update(userCtx, user); err != nil {
+		t.Fatal(err)
+	}
+	comment, err := NewCommentDAO().Create(userCtx, &model.Comment{
+		User:      *user,
+		ProductID: product.ID,
+		Comment:   model.NullStringJSON{String: "Secret opinion", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewCommentDAO().Delete(userCtx, comment.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewUserDAO().Export(ctx, user.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewUserDAO().Delete(ctx, user.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := executeMultiRowQuery(ctx, GetDAO().db,
+		func(row rowScanner) (string, error) {
+			var action, changes, ipAddress string
+			err := row.Scan(&action, &changes, &ipAddress)
+			return action + " " + changes + " " + ipAddress, err
+		},
+		"SELECT action, changes, ip_address FROM audit_log WHERE actor_id = $1 OR (entity_type = 'user' AND entity_id = $1)", user.ID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) < 3 {
+		t.Fatalf("expected the update and deletions to be recorded, got %v", rows)
+	}
+	for _, row := range rows {
+		for _, personal := range []string{"forgotten", "Forgettable", "Danube", "Secret opinion", "198.51.100.7"} {
+			if strings.Contains(row, personal) {
+				t.Errorf("expected no %q in the audit log, got %s", personal, row)
+			}
+		}
+	}
+}
+
+func TestAuditChanges(t *testing.T) {
+	changes, err := auditChanges(nil, map[string]any{"status": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(changes)
+	if string(data) != `{"status":{"before":null,"after":2}}` {
+		t.Errorf("expected a created field, got %s", data)
+	}
+}
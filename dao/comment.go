@@ -1,6 +1,12 @@
 package dao
 
-import "github.com/vladoiliev02/online-store/model"
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/vladoiliev02/online-store/model"
+)
 
 const (
 	selectComments = `
@@ -14,6 +20,10 @@ const (
 
 	selectCommentsByProductID = selectComments + " WHERE product_id = $1"
 
+	selectCommentsByProductIDs = selectComments + " WHERE product_id = ANY($1)"
+
+	selectCommentsByUserID = selectComments + " WHERE c.user_id = $1"
+
 	insertComment = `
 		INSERT INTO comments(user_id, product_id, comment)
 		VALUES ($1, $2, $3)
@@ -23,6 +33,7 @@ const (
 	deleteComment = `
 		DELETE FROM comments
 		WHERE id = $1
+		RETURNING id, product_id, user_id, comment, created_at
 	`
 )
 
@@ -44,19 +55,29 @@ func newCommentDAO(qe queryExecutor) *CommentDAO {
 	}
 }
 
-func (c *CommentDAO) GetByProductID(productID int64) ([]*model.Comment, error) {
-	return executeMultiRowQuery(c.qe, scanComment,
+func (c *CommentDAO) GetByProductID(ctx context.Context, productID int64) ([]*model.Comment, error) {
+	return executeMultiRowQuery(ctx, c.dao.reader(ctx, c.qe), scanComment,
 		selectCommentsByProductID, productID)
 }
 
-func (c *CommentDAO) Create(comment *model.Comment) (*model.Comment, error) {
-	comment, err := executeSingleRowQuery(c.qe, propertyScanner(comment, &comment.ID, &comment.CreatedAt),
+func (c *CommentDAO) GetByProductIDs(ctx context.Context, productIDs []int64) ([]*model.Comment, error) {
+	return executeMultiRowQuery(ctx, c.dao.reader(ctx, c.qe), scanComment,
+		selectCommentsByProductIDs, int64Array(productIDs))
+}
+
+func (c *CommentDAO) GetByUserID(ctx context.Context, userID int64) ([]*model.Comment, error) {
+	return executeMultiRowQuery(ctx, c.qe, scanComment,
+		selectCommentsByUserID, userID)
+}
+
+func (c *CommentDAO) Create(ctx context.Context, comment *model.Comment) (*model.Comment, error) {
+	comment, err := executeSingleRowQuery(ctx, c.qe, propertyScanner(comment, &comment.ID, &comment.CreatedAt),
 		insertComment, comment.User.ID, comment.ProductID, comment.Comment)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := c.userDAO.GetByID(comment.User.ID.Int64)
+	user, err := c.userDAO.GetByID(ctx, comment.User.ID.Int64)
 	if err != nil {
 		return nil, err
 	}
@@ -65,8 +86,22 @@ func (c *CommentDAO) Create(comment *model.Comment) (*model.Comment, error) {
 	return comment, nil
 }
 
-func (c *CommentDAO) Delete(id int64) error {
-	return executeNoRowsQuery(c.qe, deleteComment, id)
+func (c *CommentDAO) Delete(ctx context.Context, id int64) error {
+	_, err := executeInTransactionOf(ctx, c.dao.db, c.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Comment, error) {
+			var comment model.Comment
+			_, err := executeSingleRowQuery(ctx, tx,
+				propertyScanner(&comment, &comment.ID, &comment.ProductID, &comment.User.ID, &comment.Comment, &comment.CreatedAt),
+				deleteComment, id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+
+			return nil, recordAudit(ctx, tx, model.AuditCommentDelete, auditComment, id, &comment, nil)
+		})
+	return err
 }
 
 func scanComment(row rowScanner) (*model.Comment, error) {
@@ -0,0 +1,145 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	selectCredentials = `
+		SELECT c.user_id, c.password_hash, c.email_verified, c.failed_attempts, c.locked_until
+		FROM credentials c
+	`
+
+	selectCredentialByUserID = selectCredentials +
+		"WHERE c.user_id = $1;"
+
+	selectCredentialByEmail = selectCredentials +
+		"JOIN users u ON u.id = c.user_id WHERE u.email = $1;"
+
+	insertCredential = `
+		INSERT INTO credentials(user_id, password_hash, email_verified)
+		VALUES ($1, $2, $3);
+	`
+
+	// upsertCredential sets the password of a user, who proved to own their
+	// email by following a link sent to it.
+	upsertCredential = `
+		INSERT INTO credentials(user_id, password_hash, email_verified)
+		VALUES ($1, $2, TRUE)
+		ON CONFLICT (user_id) DO UPDATE
+		SET password_hash = excluded.password_hash, email_verified = TRUE, failed_attempts = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP;
+	`
+
+	verifyCredential = `
+		UPDATE credentials
+		SET email_verified = TRUE, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1;
+	`
+
+	deleteUnverifiedCredential = `
+		DELETE FROM credentials
+		WHERE user_id = $1 AND email_verified = FALSE;
+	`
+
+	// recordLoginFailure counts a failed login and locks the credential once
+	// the maximum number of attempts is reached, starting to count anew.
+	recordLoginFailure = `
+		UPDATE credentials
+		SET failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE locked_until END
+		WHERE user_id = $1;
+	`
+
+	resetLoginFailures = `
+		UPDATE credentials
+		SET failed_attempts = 0, locked_until = NULL
+		WHERE user_id = $1 AND (failed_attempts > 0 OR locked_until IS NOT NULL);
+	`
+)
+
+// CredentialDAO stores the passwords of local accounts.
+type CredentialDAO struct {
+	dao *DAO
+	qe  queryExecutor
+}
+
+func NewCredentialDAO() *CredentialDAO {
+	return newCredentialDAO(GetDAO().db)
+}
+
+func newCredentialDAO(qe queryExecutor) *CredentialDAO {
+	return &CredentialDAO{
+		dao: GetDAO(),
+		qe:  qe,
+	}
+}
+
+func (c *CredentialDAO) GetByUserID(ctx context.Context, userID int64) (*model.Credential, error) {
+	return executeSingleRowQuery(ctx, c.qe, scanCredential,
+		selectCredentialByUserID, userID)
+}
+
+func (c *CredentialDAO) GetByEmail(ctx context.Context, email string) (*model.Credential, error) {
+	return executeSingleRowQuery(ctx, c.qe, scanCredential,
+		selectCredentialByEmail, email)
+}
+
+// CreateWithUser creates a user together with their credential.
+func (c *CredentialDAO) CreateWithUser(ctx context.Context, user *model.User, credential *model.Credential) (*model.User, error) {
+	if user == nil || credential == nil {
+		return nil, &DAOError{Query: insertCredential, Message: "Nil User or Credential"}
+	}
+
+	return executeInTransactionOf(ctx, c.dao.db, c.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.User, error) {
+			user, err := newUserDAO(tx).Create(ctx, user)
+			if err != nil {
+				return nil, err
+			}
+
+			credential.UserID = user.ID
+			if err := executeNoRowsQuery(ctx, tx, insertCredential,
+				credential.UserID, credential.PasswordHash, credential.EmailVerified); err != nil {
+				return nil, err
+			}
+
+			return user, nil
+		})
+}
+
+// SetPassword sets the password of a user, creating their credential if
+// needed, marks their email as verified and unlocks it.
+func (c *CredentialDAO) SetPassword(ctx context.Context, userID int64, passwordHash string) error {
+	return executeNoRowsQuery(ctx, c.qe, upsertCredential, userID, passwordHash)
+}
+
+func (c *CredentialDAO) Verify(ctx context.Context, userID int64) error {
+	return executeNoRowsQuery(ctx, c.qe, verifyCredential, userID)
+}
+
+// DeleteUnverified deletes the credential of a user if their email is not
+// verified, in which case anyone could have registered it.
+func (c *CredentialDAO) DeleteUnverified(ctx context.Context, userID int64) error {
+	return executeNoRowsQuery(ctx, c.qe, deleteUnverifiedCredential, userID)
+}
+
+// RecordFailure counts a failed login, locking the credential until
+// lockedUntil on the maxAttempts failure.
+func (c *CredentialDAO) RecordFailure(ctx context.Context, userID int64, maxAttempts int, lockedUntil time.Time) error {
+	return executeNoRowsQuery(ctx, c.qe, recordLoginFailure, userID, maxAttempts, lockedUntil)
+}
+
+// RecordSuccess forgets the failed logins of a credential.
+func (c *CredentialDAO) RecordSuccess(ctx context.Context, userID int64) error {
+	return executeNoRowsQuery(ctx, c.qe, resetLoginFailures, userID)
+}
+
+func scanCredential(row rowScanner) (*model.Credential, error) {
+	var credential model.Credential
+	return propertyScanner(&credential,
+		&credential.UserID, &credential.PasswordHash, &credential.EmailVerified, &credential.FailedAttempts, &credential.LockedUntil)(row)
+}
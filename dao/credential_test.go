@@ -0,0 +1,80 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+func TestCredentialDAO_LocksAfterMaxFailures(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "credential-user")
+	lockedUntil := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+
+	if err := NewCredentialDAO().SetPassword(ctx, user.ID.Int64, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := NewCredentialDAO().RecordFailure(ctx, user.ID.Int64, 3, lockedUntil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	credential, err := NewCredentialDAO().GetByEmail(ctx, "credential-user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !credential.EmailVerified || credential.LockedUntil == nil || !credential.LockedUntil.Equal(lockedUntil) || credential.FailedAttempts != 0 {
+		t.Errorf("expected a verified, locked credential, got %+v", credential)
+	}
+
+	if err := NewCredentialDAO().RecordSuccess(ctx, user.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+	if credential, err := NewCredentialDAO().GetByUserID(ctx, user.ID.Int64); err != nil || credential.LockedUntil != nil {
+		t.Errorf("expected the credential to be unlocked, got %+v, %v", credential, err)
+	}
+}
+
+func TestAccountTokenDAO_ConsumesOnce(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "token-user")
+	now := time.Now().UTC()
+
+	create := func(tokenHash string, expiresAt time.Time) {
+		t.Helper()
+		_, err := NewAccountTokenDAO().Create(ctx, &model.AccountToken{
+			UserID: user.ID, Purpose: "verify_email", TokenHash: tokenHash, ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	create("first-token", now.Add(time.Hour))
+	create("second-token", now.Add(time.Hour))
+	if _, err := NewAccountTokenDAO().Consume(ctx, "verify_email", "first-token", now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the earlier token to be replaced, got %v", err)
+	}
+	if _, err := NewAccountTokenDAO().Consume(ctx, "reset_password", "second-token", now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the token to be bound to its purpose, got %v", err)
+	}
+
+	create("second-token", now.Add(time.Hour))
+	userID, err := NewAccountTokenDAO().Consume(ctx, "verify_email", "second-token", now)
+	if err != nil || userID != user.ID.Int64 {
+		t.Errorf("expected the token of user %d, got %d, %v", user.ID.Int64, userID, err)
+	}
+	if _, err := NewAccountTokenDAO().Consume(ctx, "verify_email", "second-token", now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the token to be consumed, got %v", err)
+	}
+
+	create("expired-token", now.Add(-time.Minute))
+	if _, err := NewAccountTokenDAO().Consume(ctx, "verify_email", "expired-token", now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the expired token to be refused, got %v", err)
+	}
+}
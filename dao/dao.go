@@ -1,11 +1,21 @@
 package dao
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/vladoiliev02/online-store/metrics"
+)
+
+const (
+	minConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff = 30 * time.Second
 )
 
 var (
@@ -16,10 +26,37 @@ var (
 type DBOptions struct {
 	DriverName string
 	ConnStr    string
+	// MinPageSize and MaxPageSize bound the size of product pages. The
+	// defaults of 40 and 80 are used when they are not set.
+	MinPageSize int
+	MaxPageSize int
+
+	// Connection pool settings, see sql.DB. Zero keeps the database/sql default.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// Migrations holds the Flyway migration scripts, V<version>__<name>.sql,
+	// which the readiness check compares to the applied ones. Optional.
+	Migrations fs.FS
+	// HealthCacheTTL is how long a health check result is reused, so that
+	// frequent readiness probes do not each query the database.
+	HealthCacheTTL time.Duration
+
+	// ReplicaConnStrs are read replicas of the primary, which serve the
+	// catalogue queries. ReplicaCheckInterval defaults to 5 seconds.
+	ReplicaConnStrs      []string
+	ReplicaCheckInterval time.Duration
 }
 
 type DAO struct {
-	db *sql.DB
+	db     *sql.DB
+	health healthCache
+
+	replicas      []*replica
+	nextReplica   atomic.Uint32
+	stopReplicaCh chan struct{}
 }
 
 func Init(dbOptions *DBOptions) {
@@ -30,32 +67,96 @@ func Init(dbOptions *DBOptions) {
 	options = dbOptions
 }
 
+// GetDAO returns the DAO, opening the connection pool on first use. Opening
+// does not connect to the database, see Connect.
 func GetDAO() *DAO {
 	if dao == nil {
-		db, err := sql.Open(options.DriverName, options.ConnStr)
+		dialect = dialectFor(options.DriverName)
+		if options.Migrations == nil {
+			options.Migrations = dialect.Migrations()
+		}
 
+		db, err := sql.Open(options.DriverName, dialect.DataSourceName(options.ConnStr))
 		if err != nil {
 			panic(err.Error())
 		}
 
+		configurePool(db)
+
 		dao = &DAO{
-			db: db,
+			db:       db,
+			replicas: openReplicas(options.ReplicaConnStrs),
 		}
-
-		if !dao.IsReady() {
-			panic(err.Error())
+		metrics.RegisterDB("primary", db)
+
+		if len(dao.replicas) > 0 {
+			interval := options.ReplicaCheckInterval
+			if interval <= 0 {
+				interval = defaultReplicaCheckInterval
+			}
+
+			dao.stopReplicaCh = make(chan struct{})
+			for _, replica := range dao.replicas {
+				metrics.RegisterDB(replica.name, replica.db)
+			}
+			go dao.checkReplicas(interval, dao.stopReplicaCh)
 		}
 	}
 
 	return dao
 }
 
-func (d *DAO) IsReady() bool {
-	_, err := dao.db.Exec("SELECT 1")
-	if err != nil {
-		log.Println("Database error occurred: ", err)
+func configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(options.ConnMaxIdleTime)
+}
+
+// Connect pings the database until it answers, backing off exponentially
+// between attempts, or until ctx is done. If the dialect manages its schema,
+// the pending migrations are applied.
+func Connect(ctx context.Context) error {
+	d := GetDAO()
+	backoff := minConnectBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := d.db.PingContext(ctx)
+		if err == nil {
+			slog.Info("Connected to the database", "attempts", attempt)
+			if dialect.Migrations() != nil {
+				return d.migrate(ctx, dialect.Migrations())
+			}
+			return nil
+		}
+
+		slog.Warn("Cannot connect to the database", "attempt", attempt, "retry_in", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return &DAOError{Message: "Cannot connect to the database", Err: errors.Join(ctx.Err(), err)}
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
+}
+
+// Close closes the database once the running queries finish. It is called on
+// shutdown, after the in-flight requests are drained.
+func Close() error {
+	if dao == nil {
+		return nil
+	}
+
+	var errs []error
+	if dao.stopReplicaCh != nil {
+		close(dao.stopReplicaCh)
+	}
+	for _, replica := range dao.replicas {
+		errs = append(errs, replica.db.Close())
 	}
-	return err == nil
+
+	return errors.Join(append(errs, dao.db.Close())...)
 }
 
 type rowScanner interface {
@@ -63,15 +164,16 @@ type rowScanner interface {
 }
 
 type queryExecutor interface {
-	Query(query string, args ...any) (*sql.Rows, error)
-	QueryRow(query string, args ...any) *sql.Row
-	Exec(query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
-func executeMultiRowQuery[T any](db queryExecutor, rowScanningFunc func(rowScanner) (T, error), query string, queryArgs ...any) ([]T, error) {
-	log.Println("Executing:", query)
+func executeMultiRowQuery[T any](ctx context.Context, db queryExecutor, rowScanningFunc func(rowScanner) (T, error), query string, queryArgs ...any) (_ []T, err error) {
+	ctx, end := startQuery(ctx, query)
+	defer end(&err)
 
-	rows, err := db.Query(query, queryArgs...)
+	rows, err := db.QueryContext(ctx, dialect.Rewrite(query), queryArgs...)
 	if err != nil {
 		return nil, &DAOError{Query: query, Message: "Error executing multi row query", Err: err}
 	}
@@ -89,8 +191,11 @@ func executeMultiRowQuery[T any](db queryExecutor, rowScanningFunc func(rowScann
 	return objects, nil
 }
 
-func executeSingleRowQuery[T any](db queryExecutor, rowScanningFunc func(rowScanner) (T, error), query string, queryArgs ...any) (T, error) {
-	row := db.QueryRow(query, queryArgs...)
+func executeSingleRowQuery[T any](ctx context.Context, db queryExecutor, rowScanningFunc func(rowScanner) (T, error), query string, queryArgs ...any) (_ T, err error) {
+	ctx, end := startQuery(ctx, query)
+	defer end(&err)
+
+	row := db.QueryRowContext(ctx, dialect.Rewrite(query), queryArgs...)
 
 	object, err := rowScanningFunc(row)
 	if err != nil {
@@ -100,8 +205,11 @@ func executeSingleRowQuery[T any](db queryExecutor, rowScanningFunc func(rowScan
 	return object, nil
 }
 
-func executeNoRowsQuery(db queryExecutor, query string, queryArgs ...any) error {
-	_, err := db.Exec(query, queryArgs...)
+func executeNoRowsQuery(ctx context.Context, db queryExecutor, query string, queryArgs ...any) (err error) {
+	ctx, end := startQuery(ctx, query)
+	defer end(&err)
+
+	_, err = db.ExecContext(ctx, dialect.Rewrite(query), queryArgs...)
 	if err != nil {
 		return &DAOError{Query: query, Message: "Error executing query returning no rows", Err: err}
 	}
@@ -109,8 +217,23 @@ func executeNoRowsQuery(db queryExecutor, query string, queryArgs ...any) error
 	return nil
 }
 
-func executeInTransaction[T any](db *sql.DB, transactionalFunc func(*sql.Tx) (T, error)) (T, error) {
-	tx, err := db.Begin()
+// Transaction options of executeInTransaction.
+var (
+	defaultTxOptions = &sql.TxOptions{Isolation: sql.LevelReadCommitted}
+	// snapshotTxOptions make all reads of a transaction see the same snapshot
+	// and fail the transaction if a row it changes was changed concurrently.
+	snapshotTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead}
+	readOnlyTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+)
+
+// executeInTransaction runs transactionalFunc in a transaction. The context
+// passed to it carries the span of the transaction. The transaction is rolled
+// back if ctx is canceled before it is committed.
+func executeInTransaction[T any](ctx context.Context, db *sql.DB, options *sql.TxOptions, transactionalFunc func(context.Context, *sql.Tx) (T, error)) (_ T, err error) {
+	ctx, end := startTransaction(ctx)
+	defer end(&err)
+
+	tx, err := db.BeginTx(ctx, options)
 	if err != nil {
 		var invalid T
 		return invalid, &DAOError{Query: "BEGIN", Message: "Error starting transaction", Err: err}
@@ -121,7 +244,7 @@ func executeInTransaction[T any](db *sql.DB, transactionalFunc func(*sql.Tx) (T,
 		}
 	}()
 
-	result, err := transactionalFunc(tx)
+	result, err := transactionalFunc(ctx, tx)
 	if err != nil {
 		return result, &DAOError{Query: "transactionalFunction", Message: "Error executing the provided function in a transaction", Err: err}
 	}
@@ -134,6 +257,17 @@ func executeInTransaction[T any](db *sql.DB, transactionalFunc func(*sql.Tx) (T,
 	return result, nil
 }
 
+// executeInTransactionOf runs transactionalFunc in qe if it is a transaction,
+// or in a new transaction of db otherwise. DAOs created for a transaction use
+// it, so that they do not start a concurrent one.
+func executeInTransactionOf[T any](ctx context.Context, db *sql.DB, qe queryExecutor, options *sql.TxOptions, transactionalFunc func(context.Context, *sql.Tx) (T, error)) (T, error) {
+	if tx, ok := qe.(*sql.Tx); ok {
+		return transactionalFunc(ctx, tx)
+	}
+
+	return executeInTransaction(ctx, db, options, transactionalFunc)
+}
+
 func propertyScanner[T any](obj T, args ...any) func(rowScanner) (T, error) {
 	return func(row rowScanner) (T, error) {
 		err := row.Scan(args...)
@@ -145,6 +279,24 @@ func propertyScanner[T any](obj T, args ...any) func(rowScanner) (T, error) {
 	}
 }
 
+// ErrVersionMismatch is returned when an entity was modified since the version
+// the caller based its update on.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// ErrInvalidStatusTransition is returned when an order cannot move from its
+// status to the requested one.
+var ErrInvalidStatusTransition = errors.New("invalid status transition")
+
+// versionError reports an update of a versioned entity that matched no rows
+// as a version mismatch.
+func versionError(err error, version int64) error {
+	if version != 0 && errors.Is(err, sql.ErrNoRows) {
+		return &DAOError{Query: "version check", Message: "Entity was modified concurrently", Err: ErrVersionMismatch}
+	}
+
+	return err
+}
+
 type DAOError struct {
 	Query   string `json:"-"`
 	Message string `json:"-"`
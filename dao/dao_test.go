@@ -0,0 +1,120 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+// TestMain runs the DAO tests against a fresh SQLite database. Set
+// DAO_TEST_DRIVER and DAO_TEST_CONNECTION_STRING to run them against another
+// database, e.g. a Postgres migrated by Flyway.
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	driverName, connStr := os.Getenv("DAO_TEST_DRIVER"), os.Getenv("DAO_TEST_CONNECTION_STRING")
+	if driverName == "" {
+		dir, err := os.MkdirTemp("", "dao-test")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+
+		driverName, connStr = "sqlite", "file:"+filepath.Join(dir, "store.db")
+	}
+
+	Init(&DBOptions{DriverName: driverName, ConnStr: connStr})
+	defer Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := Connect(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return m.Run()
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func createTestUser(t *testing.T, name string) *model.User {
+	t.Helper()
+
+	user, err := NewUserDAO().Create(testContext(t), &model.User{
+		Name:       model.NullStringJSON{String: name, Valid: true},
+		FirstName:  model.NullStringJSON{String: name, Valid: true},
+		LastName:   model.NullStringJSON{String: "Tester", Valid: true},
+		PictureURL: model.NullStringJSON{String: "https://example.com/" + name + ".png", Valid: true},
+		Email:      model.NullStringJSON{String: name + "@example.com", Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("cannot create user: %v", err)
+	}
+	return user
+}
+
+func createTestProduct(t *testing.T, userID int64, name string, category model.ProductCategory, quantity int64) *model.Product {
+	t.Helper()
+
+	product, err := NewProductDAO().Create(testContext(t), &model.Product{
+		Name:        model.NullStringJSON{String: name, Valid: true},
+		Description: model.NullStringJSON{String: "A " + name, Valid: true},
+		Price:       model.NewPrice(250, model.BGN),
+		Quantity:    model.NullInt64JSON{Int64: quantity, Valid: true},
+		Category:    category,
+		Available:   model.NullBoolJSON{Bool: true, Valid: true},
+		UserID:      model.NullInt64JSON{Int64: userID, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("cannot create product: %v", err)
+	}
+	return product
+}
+
+// checkoutTestOrder orders quantity of a product and checks the order out.
+func checkoutTestOrder(t *testing.T, buyerID, productID, quantity int64) *model.Order {
+	t.Helper()
+	ctx := testContext(t)
+
+	_, err := NewOrderDAO().AddItem(ctx, buyerID, &model.Item{
+		ProductID: model.NullInt64JSON{Int64: productID, Valid: true},
+		Quantity:  model.NullInt64JSON{Int64: quantity, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("cannot add item: %v", err)
+	}
+
+	cart, err := NewOrderDAO().GetCart(ctx, buyerID)
+	if err != nil {
+		t.Fatalf("cannot get cart: %v", err)
+	}
+	if cart, err = NewOrderDAO().LoadItems(ctx, cart); err != nil {
+		t.Fatalf("cannot load items: %v", err)
+	}
+
+	cart.Status = model.InProgress
+	cart.Address = model.Address{
+		City:       model.NullStringJSON{String: "Varna", Valid: true},
+		Country:    model.NullStringJSON{String: "Bulgaria", Valid: true},
+		Address:    model.NullStringJSON{String: "3 Sea Garden", Valid: true},
+		PostalCode: model.NullStringJSON{String: "9000", Valid: true},
+	}
+	order, err := NewOrderDAO().Update(ctx, cart)
+	if err != nil {
+		t.Fatalf("cannot check out: %v", err)
+	}
+	return order
+}
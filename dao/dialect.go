@@ -0,0 +1,138 @@
+package dao
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// Dialect adapts the DAO to a database. The queries of the DAO are written
+// for Postgres and rewritten by the dialect before they are executed.
+type Dialect interface {
+	// Name is the db.system reported in traces.
+	Name() string
+	// DataSourceName completes the connection string, e.g. with settings the
+	// DAO relies on.
+	DataSourceName(connStr string) string
+	// Rewrite translates a Postgres query to the dialect.
+	Rewrite(query string) string
+	// Array encodes ids as the parameter of a "= ANY($n)" condition.
+	Array(ids []int64) any
+	// Migrations are the schema migrations the DAO applies itself on Connect,
+	// or nil if the schema is managed externally.
+	Migrations() fs.FS
+}
+
+var (
+	dialects = map[string]Dialect{
+		"postgres": postgresDialect{},
+		"sqlite":   &sqliteDialect{},
+	}
+	// dialect is the dialect of the configured driver, Postgres by default.
+	dialect Dialect = postgresDialect{}
+)
+
+// RegisterDialect makes the DAO use d with the database/sql driver driverName.
+func RegisterDialect(driverName string, d Dialect) {
+	dialects[driverName] = d
+}
+
+func dialectFor(driverName string) Dialect {
+	if d, ok := dialects[driverName]; ok {
+		return d
+	}
+	return postgresDialect{}
+}
+
+// int64Array encodes ids for the "= ANY($n)" conditions of the queries.
+func int64Array(ids []int64) any {
+	return dialect.Array(ids)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string                         { return "postgresql" }
+func (postgresDialect) DataSourceName(connStr string) string { return connStr }
+func (postgresDialect) Rewrite(query string) string          { return query }
+func (postgresDialect) Array(ids []int64) any                { return pq.Array(ids) }
+
+// Migrations returns nil, the Postgres schema is migrated by Flyway.
+func (postgresDialect) Migrations() fs.FS { return nil }
+
+//go:embed sqlite/*.sql
+var sqliteMigrations embed.FS
+
+var (
+	anyRegex = regexp.MustCompile(`=\s*ANY\((\$[0-9]+)\)`)
+	nowRegex = regexp.MustCompile(`(?i)\bNOW\(\)`)
+	// castRegex matches the casts of arguments, which SQLite does not need.
+	castRegex = regexp.MustCompile(`(\$[0-9]+)::[a-zA-Z]+`)
+)
+
+// sqliteDialect runs the store on an embedded SQLite database, so that it can
+// be tried without a database server. Arrays are passed as JSON.
+type sqliteDialect struct {
+	rewritten sync.Map
+}
+
+func (*sqliteDialect) Name() string { return "sqlite" }
+
+// DataSourceName enables foreign keys and write-ahead logging, so that reads
+// do not block writes, and waits for locks held by other connections instead
+// of failing right away.
+func (*sqliteDialect) DataSourceName(connStr string) string {
+	pragmas := url.Values{}
+	if !strings.Contains(connStr, "busy_timeout") {
+		pragmas.Add("_pragma", "busy_timeout(5000)")
+	}
+	if !strings.Contains(connStr, "journal_mode") {
+		pragmas.Add("_pragma", "journal_mode(WAL)")
+	}
+	if !strings.Contains(connStr, "foreign_keys") {
+		pragmas.Add("_pragma", "foreign_keys(1)")
+	}
+	if !strings.Contains(connStr, "_txlock") {
+		pragmas.Add("_txlock", "immediate")
+	}
+	if len(pragmas) == 0 {
+		return connStr
+	}
+
+	separator := "?"
+	if strings.Contains(connStr, "?") {
+		separator = "&"
+	}
+	return connStr + separator + pragmas.Encode()
+}
+
+func (d *sqliteDialect) Rewrite(query string) string {
+	if rewritten, ok := d.rewritten.Load(query); ok {
+		return rewritten.(string)
+	}
+
+	rewritten := anyRegex.ReplaceAllString(query, "IN (SELECT value FROM json_each($1))")
+	rewritten = nowRegex.ReplaceAllString(rewritten, "CURRENT_TIMESTAMP")
+	rewritten = castRegex.ReplaceAllString(rewritten, "${1}")
+	d.rewritten.Store(query, rewritten)
+	return rewritten
+}
+
+func (*sqliteDialect) Array(ids []int64) any {
+	if ids == nil {
+		ids = []int64{}
+	}
+	encoded, _ := json.Marshal(ids)
+	return string(encoded)
+}
+
+func (*sqliteDialect) Migrations() fs.FS {
+	migrations, _ := fs.Sub(sqliteMigrations, "sqlite")
+	return migrations
+}
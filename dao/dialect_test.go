@@ -0,0 +1,13 @@
+package dao
+
+import "testing"
+
+func TestSQLiteDialect_Rewrite(t *testing.T) {
+	d := &sqliteDialect{}
+
+	query := "SELECT id FROM audit_log WHERE ($1::bigint IS NULL OR actor_id = $1::bigint) AND created_at < NOW() AND id = ANY($2)"
+	expected := "SELECT id FROM audit_log WHERE ($1 IS NULL OR actor_id = $1) AND created_at < CURRENT_TIMESTAMP AND id IN (SELECT value FROM json_each($2))"
+	if rewritten := d.Rewrite(query); rewritten != expected {
+		t.Errorf("expected %q, got %q", expected, rewritten)
+	}
+}
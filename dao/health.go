@@ -0,0 +1,149 @@
+package dao
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Health is the state of the database as seen by the readiness probe.
+type Health struct {
+	Ready      bool            `json:"ready"`
+	Database   DatabaseHealth  `json:"database"`
+	Migrations MigrationHealth `json:"migrations"`
+	Pool       PoolHealth      `json:"pool"`
+	// Replicas do not affect readiness, reads fall back to the primary.
+	Replicas []ReplicaHealth `json:"replicas"`
+}
+
+type DatabaseHealth struct {
+	Reachable bool    `json:"reachable"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// MigrationHealth compares the migration scripts shipped with the store to
+// the ones recorded by Flyway. Checked is false if either is unavailable.
+type MigrationHealth struct {
+	Checked bool     `json:"checked"`
+	Applied int      `json:"applied"`
+	Pending []string `json:"pending"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// PoolHealth reports how busy the connection pool is. Saturation is the share
+// of the maximum open connections in use, or 0 when the pool is unbounded.
+type PoolHealth struct {
+	Open           int     `json:"open"`
+	InUse          int     `json:"inUse"`
+	Idle           int     `json:"idle"`
+	MaxOpen        int     `json:"maxOpen"`
+	Saturation     float64 `json:"saturation"`
+	WaitCount      int64   `json:"waitCount"`
+	WaitDurationMs float64 `json:"waitDurationMs"`
+}
+
+type healthCache struct {
+	mu        sync.Mutex
+	health    *Health
+	checkedAt time.Time
+}
+
+// Health checks the database, reusing the previous result for
+// DBOptions.HealthCacheTTL. The database is ready when it answers a ping and
+// no migration is pending.
+func (d *DAO) Health(ctx context.Context) *Health {
+	d.health.mu.Lock()
+	defer d.health.mu.Unlock()
+
+	if d.health.health != nil && time.Since(d.health.checkedAt) < options.HealthCacheTTL {
+		health := *d.health.health
+		health.Pool = d.poolHealth()
+		health.Replicas = d.replicaHealth()
+		return &health
+	}
+
+	health := &Health{
+		Database:   d.ping(ctx),
+		Migrations: d.migrationHealth(ctx),
+		Pool:       d.poolHealth(),
+		Replicas:   d.replicaHealth(),
+	}
+	health.Ready = health.Database.Reachable && len(health.Migrations.Pending) == 0
+	if !health.Ready {
+		slog.Error("database is not ready", "database", health.Database, "pending_migrations", health.Migrations.Pending)
+	}
+
+	d.health.health, d.health.checkedAt = health, time.Now()
+	return health
+}
+
+func (d *DAO) ping(ctx context.Context) DatabaseHealth {
+	start := time.Now()
+	err := d.db.PingContext(ctx)
+	health := DatabaseHealth{
+		Reachable: err == nil,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		health.Error = err.Error()
+	}
+	return health
+}
+
+func (d *DAO) migrationHealth(ctx context.Context) MigrationHealth {
+	health := MigrationHealth{Pending: []string{}}
+	if options.Migrations == nil {
+		return health
+	}
+
+	available, err := migrationScripts(options.Migrations)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		health.Error = "cannot read the Flyway schema history"
+		return health
+	}
+
+	health.Checked = true
+	health.Applied = len(applied)
+	health.Pending = pendingMigrations(available, applied)
+	return health
+}
+
+func (d *DAO) poolHealth() PoolHealth {
+	stats := d.db.Stats()
+	health := PoolHealth{
+		Open:           stats.OpenConnections,
+		InUse:          stats.InUse,
+		Idle:           stats.Idle,
+		MaxOpen:        stats.MaxOpenConnections,
+		WaitCount:      stats.WaitCount,
+		WaitDurationMs: float64(stats.WaitDuration.Microseconds()) / 1000,
+	}
+	if stats.MaxOpenConnections > 0 {
+		health.Saturation = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+	}
+	return health
+}
+
+func pendingMigrations(available []migration, applied []string) []string {
+	isApplied := map[string]bool{}
+	for _, version := range applied {
+		isApplied[version] = true
+	}
+
+	pending := []string{}
+	for _, migration := range available {
+		if !isApplied[migration.version] {
+			pending = append(pending, migration.version)
+		}
+	}
+
+	return pending
+}
@@ -0,0 +1,31 @@
+package dao
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPendingMigrations(t *testing.T) {
+	migrations := fstest.MapFS{
+		"V1__Create_Tables.sql": {},
+		"V2__Add_Versions.sql":  {},
+		"V2_1__Add_Indexes.sql": {},
+		"R__Refresh_Views.sql":  {},
+		"README.md":             {},
+		"V3__Add_Sessions.sql":  {},
+		"V10__Add_Audit.sql":    {},
+		"V4__Not_A_Script.sql~": {},
+		"nested/V5__Nested.sql": {},
+	}
+
+	available, err := migrationScripts(migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := pendingMigrations(available, []string{"1", "2"})
+	if expected := []string{"2.1", "3", "5", "10"}; !reflect.DeepEqual(pending, expected) {
+		t.Errorf("expected pending migrations %v, got %v", expected, pending)
+	}
+}
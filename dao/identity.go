@@ -0,0 +1,110 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	selectIdentities = `
+		SELECT id, user_id, provider, subject, email, created_at
+		FROM identities
+	`
+
+	selectIdentityByProviderSubject = selectIdentities +
+		"WHERE provider = $1 AND subject = $2;"
+
+	selectIdentitiesByUserID = selectIdentities +
+		"WHERE user_id = $1 ORDER BY id;"
+
+	insertIdentity = `
+		INSERT INTO identities(user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;
+	`
+
+	// deleteIdentity keeps the last identity of a user without a password,
+	// without which they could not log in anymore.
+	deleteIdentity = `
+		DELETE FROM identities
+		WHERE id = $1 AND user_id = $2
+			AND (SELECT COUNT(*) FROM identities WHERE user_id = $2) + (SELECT COUNT(*) FROM credentials WHERE user_id = $2) > 1
+		RETURNING id;
+	`
+)
+
+type IdentityDAO struct {
+	dao *DAO
+	qe  queryExecutor
+}
+
+func NewIdentityDAO() *IdentityDAO {
+	return newIdentityDAO(GetDAO().db)
+}
+
+func newIdentityDAO(qe queryExecutor) *IdentityDAO {
+	return &IdentityDAO{
+		dao: GetDAO(),
+		qe:  qe,
+	}
+}
+
+func (i *IdentityDAO) GetByProviderSubject(ctx context.Context, provider, subject string) (*model.Identity, error) {
+	return executeSingleRowQuery(ctx, i.qe, scanIdentity,
+		selectIdentityByProviderSubject, provider, subject)
+}
+
+func (i *IdentityDAO) GetByUserID(ctx context.Context, userID int64) ([]*model.Identity, error) {
+	return executeMultiRowQuery(ctx, i.qe, scanIdentity,
+		selectIdentitiesByUserID, userID)
+}
+
+func (i *IdentityDAO) Create(ctx context.Context, identity *model.Identity) (*model.Identity, error) {
+	if identity == nil {
+		return nil, &DAOError{Query: insertIdentity, Message: "Nil Identity"}
+	}
+
+	return executeSingleRowQuery(ctx, i.qe, propertyScanner(identity, &identity.ID, &identity.CreatedAt),
+		insertIdentity, identity.UserID, identity.Provider, identity.Subject, identity.Email)
+}
+
+// CreateWithUser creates a user together with their first identity.
+func (i *IdentityDAO) CreateWithUser(ctx context.Context, user *model.User, identity *model.Identity) (*model.User, error) {
+	if user == nil || identity == nil {
+		return nil, &DAOError{Query: insertIdentity, Message: "Nil User or Identity"}
+	}
+
+	return executeInTransactionOf(ctx, i.dao.db, i.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.User, error) {
+			user, err := newUserDAO(tx).Create(ctx, user)
+			if err != nil {
+				return nil, err
+			}
+
+			identity.UserID = user.ID
+			if _, err := newIdentityDAO(tx).Create(ctx, identity); err != nil {
+				return nil, err
+			}
+
+			return user, nil
+		})
+}
+
+// Delete unlinks an identity from a user. It fails with sql.ErrNoRows if the
+// user has no such identity or it is their last way to log in.
+func (i *IdentityDAO) Delete(ctx context.Context, userID, id int64) error {
+	_, err := executeSingleRowQuery(ctx, i.qe, func(row rowScanner) (int64, error) {
+		var id int64
+		err := row.Scan(&id)
+		return id, err
+	}, deleteIdentity, id, userID)
+	return err
+}
+
+func scanIdentity(row rowScanner) (*model.Identity, error) {
+	var identity model.Identity
+	return propertyScanner(&identity,
+		&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)(row)
+}
@@ -0,0 +1,50 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+func TestIdentityDAO_KeepsLastIdentity(t *testing.T) {
+	ctx := testContext(t)
+
+	user, err := NewIdentityDAO().CreateWithUser(ctx, &model.User{
+		Name:       model.NullStringJSON{String: "linked", Valid: true},
+		FirstName:  model.NullStringJSON{String: "linked", Valid: true},
+		LastName:   model.NullStringJSON{String: "Tester", Valid: true},
+		PictureURL: model.NullStringJSON{String: "", Valid: true},
+		Email:      model.NullStringJSON{String: "linked@example.com", Valid: true},
+	}, &model.Identity{
+		Provider: model.NullStringJSON{String: "google", Valid: true},
+		Subject:  model.NullStringJSON{String: "google-subject", Valid: true},
+		Email:    model.NullStringJSON{String: "linked@example.com", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	github, err := NewIdentityDAO().Create(ctx, &model.Identity{
+		UserID:   user.ID,
+		Provider: model.NullStringJSON{String: "github", Valid: true},
+		Subject:  model.NullStringJSON{String: "12345", Valid: true},
+		Email:    model.NullStringJSON{String: "", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities, err := NewIdentityDAO().GetByUserID(ctx, user.ID.Int64)
+	if err != nil || len(identities) != 2 {
+		t.Fatalf("expected 2 identities, got %v, %v", identities, err)
+	}
+
+	if err := NewIdentityDAO().Delete(ctx, user.ID.Int64, github.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewIdentityDAO().Delete(ctx, user.ID.Int64, identities[0].ID.Int64); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the last identity to be kept, got %v", err)
+	}
+}
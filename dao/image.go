@@ -1,6 +1,10 @@
 package dao
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+
 	"github.com/vladoiliev02/online-store/model"
 )
 
@@ -15,6 +19,16 @@ const (
 		LIMIT $2
 	`
 
+	selectByProductIds = `
+		SELECT id, product_id, data, format
+		FROM (
+			SELECT id, product_id, data, format, ROW_NUMBER() OVER (PARTITION BY product_id ORDER BY id) AS n
+			FROM product_images
+			WHERE product_id = ANY($1)
+		) i
+		WHERE n <= $2
+	`
+
 	insertImage = `
 		INSERT INTO product_images (product_id, data, format)
 		VALUES ($1, $2, $3)
@@ -25,6 +39,7 @@ const (
 		DELETE
 		FROM product_images
 		WHERE id = $1
+		RETURNING id, product_id, format
 	`
 )
 
@@ -44,21 +59,42 @@ func newImageDAO(qe queryExecutor) *ImageDAO {
 	}
 }
 
-func (i *ImageDAO) GetByProductID(productID, limit int64) ([]*model.Image, error) {
-	return executeMultiRowQuery(i.qe,
+func (i *ImageDAO) GetByProductID(ctx context.Context, productID, limit int64) ([]*model.Image, error) {
+	return executeMultiRowQuery(ctx, i.dao.reader(ctx, i.qe),
 		scanImage,
 		selectByProductId, productID, limit)
 }
 
-func (i *ImageDAO) Create(image *model.Image) (*model.Image, error) {
-	return executeSingleRowQuery(i.qe,
+// GetByProductIDs returns up to limit images of each of the given products.
+func (i *ImageDAO) GetByProductIDs(ctx context.Context, productIDs []int64, limit int64) ([]*model.Image, error) {
+	return executeMultiRowQuery(ctx, i.dao.reader(ctx, i.qe),
+		scanImage,
+		selectByProductIds, int64Array(productIDs), limit)
+}
+
+func (i *ImageDAO) Create(ctx context.Context, image *model.Image) (*model.Image, error) {
+	return executeSingleRowQuery(ctx, i.qe,
 		propertyScanner(image, &image.ID),
 		insertImage, image.ProductID, image.Data, image.Format)
 }
 
-func (i *ImageDAO) Delete(id int64) error {
-	return executeNoRowsQuery(i.qe,
-		deleteImage, id)
+// Delete deletes an image. Its data is not kept in the audit log.
+func (i *ImageDAO) Delete(ctx context.Context, id int64) error {
+	_, err := executeInTransactionOf(ctx, i.dao.db, i.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Image, error) {
+			var image model.Image
+			_, err := executeSingleRowQuery(ctx, tx,
+				propertyScanner(&image, &image.ID, &image.ProductID, &image.Format),
+				deleteImage, id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+
+			return nil, recordAudit(ctx, tx, model.AuditImageDelete, auditImage, id, &image, nil)
+		})
+	return err
 }
 
 func scanImage(row rowScanner) (*model.Image, error) {
@@ -0,0 +1,86 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/vladoiliev02/online-store/metrics"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/vladoiliev02/online-store/dao")
+
+// startQuery starts a span for a query executed by one of the execute*
+// helpers. The returned function ends the span, then logs and measures the
+// query. It is meant to be deferred, so that it sees the final error.
+func startQuery(ctx context.Context, query string) (context.Context, func(*error)) {
+	name, start := queryName(), time.Now()
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", dialect.Name()),
+		attribute.String("db.statement", strings.Join(strings.Fields(query), " ")),
+	))
+
+	return ctx, func(err *error) {
+		duration := time.Since(start)
+		failed := *err != nil && !errors.Is(*err, sql.ErrNoRows)
+
+		if failed {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, "query failed")
+		}
+		span.End()
+
+		metrics.ObserveQuery(name, duration, failed)
+
+		attrs := []any{"query", name, "duration", duration}
+		if failed {
+			slog.WarnContext(ctx, "query failed", append(attrs, "error", *err)...)
+		} else {
+			slog.DebugContext(ctx, "query executed", attrs...)
+		}
+	}
+}
+
+// startTransaction starts a span, which is the parent of the spans of all
+// queries executed in the transaction.
+func startTransaction(ctx context.Context) (context.Context, func(*error)) {
+	ctx, span := tracer.Start(ctx, queryName()+" transaction")
+
+	return ctx, func(err *error) {
+		if *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, "transaction failed")
+		}
+		span.End()
+	}
+}
+
+// queryName names a query after the DAO method that executed it, e.g.
+// "ProductDAO.GetByID", which is more readable than the SQL itself.
+func queryName() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	for {
+		frame, more := frames.Next()
+
+		_, method, found := strings.Cut(frame.Function, "/dao.(*")
+		if found {
+			method, _, _ = strings.Cut(strings.Replace(method, ").", ".", 1), ".func")
+			return method
+		}
+
+		if !more {
+			return "unknown"
+		}
+	}
+}
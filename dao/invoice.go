@@ -1,6 +1,9 @@
 package dao
 
-import "github.com/vladoiliev02/online-store/model"
+import (
+	"context"
+	"github.com/vladoiliev02/online-store/model"
+)
 
 const (
 	selectInvoices = `
@@ -12,11 +15,13 @@ const (
 		LEFT JOIN addresses a ON a.id = o.address_id
 	`
 
-	selectInvoiceByID = selectInvoices + " WHERE id = $1"
+	selectInvoiceByID = selectInvoices + " WHERE i.id = $1"
+
+	selectInvoicesByUserID = selectInvoices + " WHERE i.user_id = $1"
 
-	selectInvoicesByUserID = selectInvoices + " WHERE user_id = $1"
+	selectInvoicesByOrderID = selectInvoices + " WHERE i.order_id = $1"
 
-	selectInvoicesByOrderID = selectInvoices + " WHERE order_id = $1"
+	selectInvoicesByOrderIDs = selectInvoices + " WHERE i.order_id = ANY($1)"
 
 	insertInvoice = `
 		INSERT INTO invoices(user_id, order_id, total_price_units, total_price_currency)
@@ -41,23 +46,28 @@ func newInvoiceDAO(qe queryExecutor) *InvoiceDAO {
 	}
 }
 
-func (i *InvoiceDAO) GetByID(id int64) (*model.Invoice, error) {
-	return executeSingleRowQuery(i.qe, scanInvoice,
+func (i *InvoiceDAO) GetByID(ctx context.Context, id int64) (*model.Invoice, error) {
+	return executeSingleRowQuery(ctx, i.qe, scanInvoice,
 		selectInvoiceByID, id)
 }
 
-func (i *InvoiceDAO) GetByUserID(userID int64) ([]*model.Invoice, error) {
-	return executeMultiRowQuery(i.qe, scanInvoice,
+func (i *InvoiceDAO) GetByUserID(ctx context.Context, userID int64) ([]*model.Invoice, error) {
+	return executeMultiRowQuery(ctx, i.qe, scanInvoice,
 		selectInvoicesByUserID, userID)
 }
 
-func (i *InvoiceDAO) GetByOrderID(orderID int64) (*model.Invoice, error) {
-	return executeSingleRowQuery(i.qe, scanInvoice,
+func (i *InvoiceDAO) GetByOrderID(ctx context.Context, orderID int64) (*model.Invoice, error) {
+	return executeSingleRowQuery(ctx, i.qe, scanInvoice,
 		selectInvoicesByOrderID, orderID)
 }
 
-func (i *InvoiceDAO) Create(invoice *model.Invoice) (*model.Invoice, error) {
-	return executeSingleRowQuery(i.qe, propertyScanner(invoice, &invoice.ID, &invoice.CreatedAt),
+func (i *InvoiceDAO) GetByOrderIDs(ctx context.Context, orderIDs []int64) ([]*model.Invoice, error) {
+	return executeMultiRowQuery(ctx, i.qe, scanInvoice,
+		selectInvoicesByOrderIDs, int64Array(orderIDs))
+}
+
+func (i *InvoiceDAO) Create(ctx context.Context, invoice *model.Invoice) (*model.Invoice, error) {
+	return executeSingleRowQuery(ctx, i.qe, propertyScanner(invoice, &invoice.ID, &invoice.CreatedAt),
 		insertInvoice, invoice.UserID, invoice.Order.ID, invoice.TotalPrice.Units, invoice.TotalPrice.Currency)
 }
 
@@ -1,6 +1,9 @@
 package dao
 
-import "github.com/vladoiliev02/online-store/model"
+import (
+	"context"
+	"github.com/vladoiliev02/online-store/model"
+)
 
 const (
 	selectItems = `
@@ -10,6 +13,8 @@ const (
 
 	selectItemsByOrderID = selectItems + " WHERE order_id = $1"
 
+	selectItemsByOrderIDs = selectItems + " WHERE order_id = ANY($1)"
+
 	selectItemByOrderIDAndProductID = selectItems + " WHERE order_id = $1 AND product_id = $2"
 
 	insertItem = `
@@ -47,29 +52,34 @@ func newItemDAO(qe queryExecutor) *ItemDAO {
 	}
 }
 
-func (i *ItemDAO) GetByOrderIDAndProductID(orderID, productID int64) (*model.Item, error) {
-	return executeSingleRowQuery(i.qe, scanItem,
+func (i *ItemDAO) GetByOrderIDAndProductID(ctx context.Context, orderID, productID int64) (*model.Item, error) {
+	return executeSingleRowQuery(ctx, i.qe, scanItem,
 		selectItemByOrderIDAndProductID, orderID, productID)
 }
 
-func (i *ItemDAO) GetByOrderID(orderID int64) ([]*model.Item, error) {
-	return executeMultiRowQuery(i.qe, scanItem,
+func (i *ItemDAO) GetByOrderID(ctx context.Context, orderID int64) ([]*model.Item, error) {
+	return executeMultiRowQuery(ctx, i.qe, scanItem,
 		selectItemsByOrderID, orderID)
 }
 
-func (i *ItemDAO) Create(item *model.Item) (*model.Item, error) {
-	return executeSingleRowQuery(i.qe, propertyScanner(item, &item.ID),
+func (i *ItemDAO) GetByOrderIDs(ctx context.Context, orderIDs []int64) ([]*model.Item, error) {
+	return executeMultiRowQuery(ctx, i.qe, scanItem,
+		selectItemsByOrderIDs, int64Array(orderIDs))
+}
+
+func (i *ItemDAO) Create(ctx context.Context, item *model.Item) (*model.Item, error) {
+	return executeSingleRowQuery(ctx, i.qe, propertyScanner(item, &item.ID),
 		insertItem, item.ProductID, item.OrderID, item.Quantity, item.Price.Units, item.Price.Currency)
 
 }
 
-func (i *ItemDAO) Update(item *model.Item) (*model.Item, error) {
-	return executeSingleRowQuery(i.qe, scanItem,
+func (i *ItemDAO) Update(ctx context.Context, item *model.Item) (*model.Item, error) {
+	return executeSingleRowQuery(ctx, i.qe, scanItem,
 		updateItem, item.OrderID, item.Quantity, item.Price.Units, item.Price.Currency, item.ID)
 }
 
-func (i *ItemDAO) Delete(id int64) error {
-	return executeNoRowsQuery(i.qe, deleteItem, id)
+func (i *ItemDAO) Delete(ctx context.Context, id int64) error {
+	return executeNoRowsQuery(ctx, i.qe, deleteItem, id)
 }
 
 func scanItem(row rowScanner) (*model.Item, error) {
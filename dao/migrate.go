@@ -0,0 +1,139 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// createSchemaHistory creates a subset of the Flyway schema history table,
+	// for databases whose schema the DAO migrates itself.
+	createSchemaHistory = `
+		CREATE TABLE IF NOT EXISTS flyway_schema_history (
+			installed_rank INTEGER PRIMARY KEY,
+			version VARCHAR(50),
+			description VARCHAR(200) NOT NULL,
+			script VARCHAR(1000) NOT NULL,
+			installed_on TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+			success BOOLEAN NOT NULL
+		)
+	`
+
+	selectAppliedMigrations = `
+		SELECT version
+		FROM flyway_schema_history
+		WHERE success AND version IS NOT NULL
+	`
+
+	insertSchemaHistory = `
+		INSERT INTO flyway_schema_history(installed_rank, version, description, script, success)
+		VALUES ((SELECT COALESCE(MAX(installed_rank), 0) + 1 FROM flyway_schema_history), $1, $2, $3, $4)
+	`
+)
+
+var migrationFileRegex = regexp.MustCompile(`^V([0-9][0-9._]*)__(.+)\.sql$`)
+
+// migration is a versioned Flyway migration script, V<version>__<description>.sql.
+type migration struct {
+	version     string
+	description string
+	script      string
+}
+
+// migrationScripts lists the migration scripts in migrations, ordered by
+// version, e.g. "2" for V2__Add_Versions.sql.
+func migrationScripts(migrations fs.FS) ([]migration, error) {
+	var scripts []migration
+	err := fs.WalkDir(migrations, ".", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if match := migrationFileRegex.FindStringSubmatch(entry.Name()); match != nil && !entry.IsDir() {
+			scripts = append(scripts, migration{
+				version:     strings.ReplaceAll(match[1], "_", "."),
+				description: strings.ReplaceAll(match[2], "_", " "),
+				script:      file,
+			})
+		}
+		return nil
+	})
+
+	sort.Slice(scripts, func(i, j int) bool { return versionLess(scripts[i].version, scripts[j].version) })
+	return scripts, err
+}
+
+// versionLess compares dotted versions numerically, so that 10 follows 9.
+func versionLess(a, b string) bool {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aPart, _ := strconv.Atoi(aParts[i])
+		bPart, _ := strconv.Atoi(bParts[i])
+		if aPart != bPart {
+			return aPart < bPart
+		}
+	}
+	return len(aParts) < len(bParts)
+}
+
+func (d *DAO) appliedMigrations(ctx context.Context) ([]string, error) {
+	return executeMultiRowQuery(ctx, d.db, func(row rowScanner) (string, error) {
+		var version string
+		err := row.Scan(&version)
+		return version, err
+	}, selectAppliedMigrations)
+}
+
+// migrate applies the pending migrations, each in its own transaction, and
+// records them in the schema history like Flyway does.
+func (d *DAO) migrate(ctx context.Context, migrations fs.FS) error {
+	if err := executeNoRowsQuery(ctx, d.db, createSchemaHistory); err != nil {
+		return err
+	}
+
+	scripts, err := migrationScripts(migrations)
+	if err != nil {
+		return &DAOError{Query: "migrate", Message: "Cannot read migrations", Err: err}
+	}
+
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	isApplied := map[string]bool{}
+	for _, version := range applied {
+		isApplied[version] = true
+	}
+
+	for _, script := range scripts {
+		if isApplied[script.version] {
+			continue
+		}
+
+		content, err := fs.ReadFile(migrations, script.script)
+		if err != nil {
+			return &DAOError{Query: "migrate", Message: "Cannot read migration " + script.script, Err: err}
+		}
+
+		_, err = executeInTransaction(ctx, d.db, defaultTxOptions, func(ctx context.Context, tx *sql.Tx) (any, error) {
+			if err := executeNoRowsQuery(ctx, tx, string(content)); err != nil {
+				return nil, err
+			}
+			return nil, executeNoRowsQuery(ctx, tx, insertSchemaHistory, script.version, script.description, path.Base(script.script), true)
+		})
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Applied migration", "version", script.version, "description", script.description)
+	}
+
+	return nil
+}
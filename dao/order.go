@@ -1,15 +1,17 @@
 package dao
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 
+	"github.com/vladoiliev02/online-store/metrics"
 	"github.com/vladoiliev02/online-store/model"
 )
 
 const (
 	selectOrders = `
-		SELECT o.id, o.user_id, o.status, o.created_at, o.latest_update,
+		SELECT o.id, o.user_id, o.status, o.created_at, o.latest_update, o.version,
 			a.id, a.city, a.country, a.address, a.postal_code
 		FROM orders o
 		LEFT JOIN addresses a ON a.id = o.address_id
@@ -21,17 +23,19 @@ const (
 
 	selectOrdersByUserIDAndStatus = selectOrders + " WHERE o.user_id = $1 AND o.status = $2"
 
+	selectOrdersByStatus = selectOrders + " WHERE o.status = $1"
+
 	insertOrder = `
 		INSERT INTO orders(user_id, status, address_id)
 		VALUES ($1, $2, $3)
-		RETURNING id, created_at, latest_update
+		RETURNING id, created_at, latest_update, version
 	`
 
 	updateOrder = `
 		UPDATE orders
-		SET status = $1, address_id = $2, latest_update = NOW()
-		WHERE id = $3
-		RETURNING id, created_at, latest_update
+		SET status = $1, address_id = $2, latest_update = NOW(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING id, created_at, latest_update, version
 	`
 )
 
@@ -49,28 +53,33 @@ func newOrderDAO(qe queryExecutor) *OrderDAO {
 	return &OrderDAO{
 		dao:     GetDAO(),
 		qe:      qe,
-		itemDAO: NewItemDAO(),
+		itemDAO: newItemDAO(qe),
 	}
 }
 
-func (o *OrderDAO) GetByID(id int64) (*model.Order, error) {
-	return executeSingleRowQuery(o.qe, scanOrder, selectOrderByID, id)
+func (o *OrderDAO) GetByID(ctx context.Context, id int64) (*model.Order, error) {
+	return executeSingleRowQuery(ctx, o.qe, scanOrder, selectOrderByID, id)
 }
 
-func (o *OrderDAO) GetByUserID(userID int64) ([]*model.Order, error) {
-	return executeMultiRowQuery(o.qe, scanOrder,
+func (o *OrderDAO) GetByUserID(ctx context.Context, userID int64) ([]*model.Order, error) {
+	return executeMultiRowQuery(ctx, o.qe, scanOrder,
 		selectOrdersByUserID, userID)
 }
 
-func (o *OrderDAO) GetByUserIDAndStatus(userID int64, status model.OrderStatus) ([]*model.Order, error) {
-	orders, err := executeMultiRowQuery(o.qe, scanOrder,
+func (o *OrderDAO) GetByStatus(ctx context.Context, status model.OrderStatus) ([]*model.Order, error) {
+	return executeMultiRowQuery(ctx, o.qe, scanOrder,
+		selectOrdersByStatus, status)
+}
+
+func (o *OrderDAO) GetByUserIDAndStatus(ctx context.Context, userID int64, status model.OrderStatus) ([]*model.Order, error) {
+	orders, err := executeMultiRowQuery(ctx, o.qe, scanOrder,
 		selectOrdersByUserIDAndStatus, userID, status)
 	if err != nil {
 		return nil, err
 	}
 
 	if status == model.InCart && len(orders) == 0 {
-		order, err := o.Create(&model.Order{
+		order, err := o.Create(ctx, &model.Order{
 			UserID: model.NullInt64JSON{Int64: userID, Valid: true},
 			Status: model.InCart,
 		})
@@ -84,12 +93,14 @@ func (o *OrderDAO) GetByUserIDAndStatus(userID int64, status model.OrderStatus)
 	return orders, nil
 }
 
-func (o *OrderDAO) Create(order *model.Order) (*model.Order, error) {
-	return executeInTransaction(o.dao.db,
-		func(tx *sql.Tx) (*model.Order, error) {
+// Create inserts an order. If the DAO belongs to a transaction, the order is
+// created as part of it.
+func (o *OrderDAO) Create(ctx context.Context, order *model.Order) (*model.Order, error) {
+	order, err := executeInTransactionOf(ctx, o.dao.db, o.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Order, error) {
 			if (order.Address != model.Address{}) {
 				addressTx := newAddressDAO(tx)
-				address, err := addressTx.CreateAddress(&order.Address)
+				address, err := addressTx.CreateAddress(ctx, &order.Address)
 				if err != nil {
 					return nil, err
 				}
@@ -97,47 +108,69 @@ func (o *OrderDAO) Create(order *model.Order) (*model.Order, error) {
 				order.Address = *address
 			}
 
-			return executeSingleRowQuery(o.qe, scanIDAndTimestamps(order),
+			return executeSingleRowQuery(ctx, tx, scanIDAndTimestamps(order),
 				insertOrder, order.UserID, order.Status, order.Address.ID)
 		})
 
+	if err == nil && order.Status == model.InCart {
+		metrics.CartsCreated.Inc()
+	}
+
+	return order, err
 }
 
-func (o *OrderDAO) Update(order *model.Order) (*model.Order, error) {
-	return executeInTransaction(o.dao.db,
-		func(tx *sql.Tx) (*model.Order, error) {
+func (o *OrderDAO) Update(ctx context.Context, order *model.Order) (*model.Order, error) {
+	// Checkouts are counted once the transaction is over.
+	checkout, failureReason := false, metrics.CheckoutError
+
+	order, err := executeInTransaction(ctx, o.dao.db, snapshotTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Order, error) {
 			orderTx := newOrderDAO(tx)
-			existingOrder, err := orderTx.GetByID(order.ID.Int64)
+			existingOrder, err := orderTx.GetByID(ctx, order.ID.Int64)
 			if err != nil {
 				return nil, err
 			}
+			checkout = existingOrder.Status == model.InCart && order.Status != model.InCart
+
+			if order.Version != 0 && order.Version != existingOrder.Version {
+				failureReason = metrics.CheckoutConflict
+				return nil, &DAOError{Query: updateOrder, Message: "Order was modified concurrently", Err: ErrVersionMismatch}
+			}
 
 			if (order.Status == model.Canceled && existingOrder.Status == model.InCart) ||
 				(order.Status != model.Canceled && order.Status-existingOrder.Status != 1) {
-				return nil, &DAOError{Query: updateOrder, Message: "Invalid order status update", Err: err}
+				failureReason = metrics.CheckoutInvalidStatus
+				return nil, &DAOError{Query: updateOrder, Message: "Invalid order status update", Err: ErrInvalidStatusTransition}
 			}
 
-			if existingOrder.Status == model.InCart && order.Status != model.InCart {
+			if checkout {
+				// The cart holds the items in the database, whichever the
+				// order was read with.
+				if _, err := orderTx.LoadItems(ctx, order); err != nil {
+					return nil, err
+				}
 				if len(order.Products) == 0 {
+					failureReason = metrics.CheckoutEmptyCart
 					return nil, &DAOError{Query: updateOrder, Message: "Invalid cart - no items", Err: err}
 				}
 
 				if err := model.ValidateAddress(&order.Address); err != nil {
+					failureReason = metrics.CheckoutInvalidAddress
 					return nil, &DAOError{Query: updateOrder, Message: "Invalid order address", Err: err}
 				}
 
-				orderTx.Create(&model.Order{
+				orderTx.Create(ctx, &model.Order{
 					UserID: existingOrder.UserID,
 					Status: model.InCart,
 				})
 
-				orderPrice, err := orderTx.calculatePrice(tx, order)
+				orderPrice, err := orderTx.calculatePrice(ctx, tx, order)
 				if err != nil {
 					return nil, &DAOError{Query: updateOrder, Message: "Error calculating order price", Err: err}
 				}
 
 				invoiceTx := newInvoiceDAO(tx)
-				invoiceTx.Create(&model.Invoice{
+				invoiceTx.Create(ctx, &model.Invoice{
 					UserID:     existingOrder.UserID,
 					Order:      *order,
 					TotalPrice: orderPrice,
@@ -146,7 +179,7 @@ func (o *OrderDAO) Update(order *model.Order) (*model.Order, error) {
 
 			if (order.Address != model.Address{}) {
 				addressTx := newAddressDAO(tx)
-				address, err := addressTx.CreateAddress(&order.Address)
+				address, err := addressTx.CreateAddress(ctx, &order.Address)
 				if err != nil {
 					return nil, err
 				}
@@ -156,16 +189,37 @@ func (o *OrderDAO) Update(order *model.Order) (*model.Order, error) {
 				order.Address.ID = existingOrder.Address.ID
 			}
 
-			return executeSingleRowQuery(tx,
+			order, err = executeSingleRowQuery(ctx, tx,
 				scanIDAndTimestamps(order),
 				updateOrder,
-				order.Status, order.Address.ID, order.ID)
+				order.Status, order.Address.ID, order.ID, existingOrder.Version)
+			if errors.Is(err, sql.ErrNoRows) {
+				failureReason = metrics.CheckoutConflict
+			}
+			if err != nil {
+				return order, versionError(err, existingOrder.Version)
+			}
+
+			updatedOrder, err := orderTx.GetByID(ctx, order.ID.Int64)
+			if err != nil {
+				return nil, err
+			}
+
+			return order, recordAudit(ctx, tx, model.AuditOrderUpdate, auditOrder, order.ID.Int64, existingOrder, updatedOrder)
 		})
+
+	if checkout && err != nil {
+		metrics.CheckoutFailures.WithLabelValues(failureReason).Inc()
+	} else if checkout {
+		metrics.Checkouts.Inc()
+	}
+
+	return order, err
 }
 
-func (o *OrderDAO) calculatePrice(tx queryExecutor, order *model.Order) (model.Price, error) {
+func (o *OrderDAO) calculatePrice(ctx context.Context, tx queryExecutor, order *model.Order) (model.Price, error) {
 	var err error
-	order, err = o.LoadItems(order)
+	order, err = o.LoadItems(ctx, order)
 	if err != nil {
 		return model.Price{}, err
 	}
@@ -177,7 +231,7 @@ func (o *OrderDAO) calculatePrice(tx queryExecutor, order *model.Order) (model.P
 	price := model.NewPrice(0, order.Products[0].Price.Currency)
 	for _, item := range order.Products {
 		productTx := newProductDAO(tx)
-		product, err := productTx.GetByID(item.ProductID.Int64)
+		product, err := productTx.GetByID(ctx, item.ProductID.Int64)
 		if err != nil {
 			return model.Price{}, err
 		}
@@ -187,7 +241,7 @@ func (o *OrderDAO) calculatePrice(tx queryExecutor, order *model.Order) (model.P
 		}
 
 		product.Quantity.Int64 -= item.Quantity.Int64
-		product, err = productTx.Update(product)
+		product, err = productTx.Update(ctx, product)
 		if err != nil {
 			return model.Price{}, err
 		}
@@ -198,8 +252,23 @@ func (o *OrderDAO) calculatePrice(tx queryExecutor, order *model.Order) (model.P
 	return price, nil
 }
 
-func (o *OrderDAO) LoadItems(order *model.Order) (*model.Order, error) {
-	items, err := o.itemDAO.GetByOrderID(order.ID.Int64)
+// GetByIDWithItems returns an order together with its items, as seen by a
+// single snapshot of the database.
+func (o *OrderDAO) GetByIDWithItems(ctx context.Context, id int64) (*model.Order, error) {
+	return executeInTransaction(ctx, o.dao.db, readOnlyTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Order, error) {
+			orderTx := newOrderDAO(tx)
+			order, err := orderTx.GetByID(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+
+			return orderTx.LoadItems(ctx, order)
+		})
+}
+
+func (o *OrderDAO) LoadItems(ctx context.Context, order *model.Order) (*model.Order, error) {
+	items, err := o.itemDAO.GetByOrderID(ctx, order.ID.Int64)
 	if err != nil {
 		return nil, err
 	}
@@ -208,17 +277,17 @@ func (o *OrderDAO) LoadItems(order *model.Order) (*model.Order, error) {
 	return order, nil
 }
 
-func (o *OrderDAO) AddItem(userID int64, item *model.Item) (*model.Item, error) {
-	return executeInTransaction(o.dao.db,
-		func(tx *sql.Tx) (*model.Item, error) {
+func (o *OrderDAO) AddItem(ctx context.Context, userID int64, item *model.Item) (*model.Item, error) {
+	return executeInTransaction(ctx, o.dao.db, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Item, error) {
 			orderTx := newOrderDAO(tx)
-			order, err := orderTx.GetCart(userID)
+			order, err := orderTx.GetCart(ctx, userID)
 			if err != nil {
 				return nil, err
 			}
 			item.OrderID = order.ID
 
-			order, err = orderTx.LoadItems(order)
+			order, err = orderTx.LoadItems(ctx, order)
 			if err != nil {
 				return nil, err
 			}
@@ -232,7 +301,7 @@ func (o *OrderDAO) AddItem(userID int64, item *model.Item) (*model.Item, error)
 			}
 
 			productTx := newProductDAO(tx)
-			product, err := productTx.GetByID(item.ProductID.Int64)
+			product, err := productTx.GetByID(ctx, item.ProductID.Int64)
 			if err != nil {
 				return nil, err
 			}
@@ -240,9 +309,9 @@ func (o *OrderDAO) AddItem(userID int64, item *model.Item) (*model.Item, error)
 
 			itemDAO := newItemDAO(tx)
 			if item.ID.Valid {
-				item, err = itemDAO.Update(item)
+				item, err = itemDAO.Update(ctx, item)
 			} else {
-				item, err = itemDAO.Create(item)
+				item, err = itemDAO.Create(ctx, item)
 			}
 
 			if err != nil {
@@ -253,16 +322,16 @@ func (o *OrderDAO) AddItem(userID int64, item *model.Item) (*model.Item, error)
 		})
 }
 
-func (o *OrderDAO) RemoveItem(userID int64, itemID int64) error {
-	_, err := executeInTransaction(o.dao.db,
-		func(tx *sql.Tx) (*model.Item, error) {
+func (o *OrderDAO) RemoveItem(ctx context.Context, userID int64, itemID int64) error {
+	_, err := executeInTransaction(ctx, o.dao.db, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Item, error) {
 			orderTx := newOrderDAO(tx)
-			order, err := orderTx.GetCart(userID)
+			order, err := orderTx.GetCart(ctx, userID)
 			if err != nil {
 				return nil, err
 			}
 
-			order, err = orderTx.LoadItems(order)
+			order, err = orderTx.LoadItems(ctx, order)
 			if err != nil {
 				return nil, err
 			}
@@ -270,7 +339,7 @@ func (o *OrderDAO) RemoveItem(userID int64, itemID int64) error {
 			itemTx := newItemDAO(tx)
 			for _, p := range order.Products {
 				if p.ID.Int64 == itemID {
-					itemTx.Delete(itemID)
+					itemTx.Delete(ctx, itemID)
 					break
 				}
 			}
@@ -281,8 +350,8 @@ func (o *OrderDAO) RemoveItem(userID int64, itemID int64) error {
 	return err
 }
 
-func (o *OrderDAO) GetCart(userID int64) (*model.Order, error) {
-	orders, err := o.GetByUserIDAndStatus(userID, model.InCart)
+func (o *OrderDAO) GetCart(ctx context.Context, userID int64) (*model.Order, error) {
+	orders, err := o.GetByUserIDAndStatus(ctx, userID, model.InCart)
 	if err != nil {
 		return nil, err
 	}
@@ -293,7 +362,7 @@ func (o *OrderDAO) GetCart(userID int64) (*model.Order, error) {
 		order := &model.Order{}
 		order.UserID.Scan(userID)
 		order.Status = model.InCart
-		o.Create(order)
+		return o.Create(ctx, order)
 	}
 
 	return orders[0], nil
@@ -302,10 +371,10 @@ func (o *OrderDAO) GetCart(userID int64) (*model.Order, error) {
 func scanOrder(row rowScanner) (*model.Order, error) {
 	var order model.Order
 	return propertyScanner(&order,
-		&order.ID, &order.UserID, &order.Status, &order.CreatedAt, &order.LatestUpdate,
+		&order.ID, &order.UserID, &order.Status, &order.CreatedAt, &order.LatestUpdate, &order.Version,
 		&order.Address.ID, &order.Address.City, &order.Address.Country, &order.Address.Address, &order.Address.PostalCode)(row)
 }
 
 func scanIDAndTimestamps(order *model.Order) func(rowScanner) (*model.Order, error) {
-	return propertyScanner(order, &order.ID, &order.CreatedAt, &order.LatestUpdate)
+	return propertyScanner(order, &order.ID, &order.CreatedAt, &order.LatestUpdate, &order.Version)
 }
@@ -0,0 +1,118 @@
+package dao
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+func TestOrderDAO_Checkout(t *testing.T) {
+	ctx := testContext(t)
+	seller, buyer := createTestUser(t, "order-seller"), createTestUser(t, "buyer")
+	product := createTestProduct(t, seller.ID.Int64, "Ordered product", model.Technology, 5)
+
+	item, err := NewOrderDAO().AddItem(ctx, buyer.ID.Int64, &model.Item{
+		ProductID: product.ID,
+		Quantity:  model.NullInt64JSON{Int64: 2, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Price.Units != 500 {
+		t.Errorf("expected a price of 500, got %d", item.Price.Units)
+	}
+
+	cart, err := NewOrderDAO().GetCart(ctx, buyer.ID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err = NewOrderDAO().LoadItems(ctx, cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cart.Status = model.InProgress
+	cart.Address = model.Address{
+		City:       model.NullStringJSON{String: "Plovdiv", Valid: true},
+		Country:    model.NullStringJSON{String: "Bulgaria", Valid: true},
+		Address:    model.NullStringJSON{String: "2 Main St", Valid: true},
+		PostalCode: model.NullStringJSON{String: "4000", Valid: true},
+	}
+	if _, err := NewOrderDAO().Update(ctx, cart); err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := NewOrderDAO().GetByIDWithItems(ctx, cart.ID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != model.InProgress || len(order.Products) != 1 {
+		t.Errorf("expected an order in progress with one item, got %+v", order)
+	}
+
+	stocked, err := NewProductDAO().GetByID(ctx, product.ID.Int64)
+	if err != nil || stocked.Quantity.Int64 != 3 {
+		t.Errorf("expected 3 products left in stock, got %v, %v", stocked, err)
+	}
+
+	invoices, err := NewInvoiceDAO().GetByUserID(ctx, buyer.ID.Int64)
+	if err != nil || len(invoices) != 1 || invoices[0].TotalPrice.Units != 500 {
+		t.Errorf("expected an invoice of 500, got %v, %v", invoices, err)
+	}
+
+	newCart, err := NewOrderDAO().GetCart(ctx, buyer.ID.Int64)
+	if err != nil || newCart.ID == cart.ID {
+		t.Errorf("expected a new cart after checkout, got %v, %v", newCart, err)
+	}
+}
+
+// TestOrderDAO_CheckoutOfPatchedOrder checks out a cart read without its items,
+// as PATCH /orders/{id} does when it only changes the status and address.
+func TestOrderDAO_CheckoutOfPatchedOrder(t *testing.T) {
+	ctx := testContext(t)
+	seller, buyer := createTestUser(t, "patch-seller"), createTestUser(t, "patch-buyer")
+	product := createTestProduct(t, seller.ID.Int64, "Patched product", model.Technology, 5)
+
+	if _, err := NewOrderDAO().AddItem(ctx, buyer.ID.Int64, &model.Item{
+		ProductID: product.ID,
+		Quantity:  model.NullInt64JSON{Int64: 1, Valid: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cart, err := NewOrderDAO().GetCart(ctx, buyer.ID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cart.Status = model.InProgress
+	cart.Address = model.Address{
+		City:       model.NullStringJSON{String: "Varna", Valid: true},
+		Country:    model.NullStringJSON{String: "Bulgaria", Valid: true},
+		Address:    model.NullStringJSON{String: "3 Sea St", Valid: true},
+		PostalCode: model.NullStringJSON{String: "9000", Valid: true},
+	}
+	cart.Products = nil
+	if _, err := NewOrderDAO().Update(ctx, cart); err != nil {
+		t.Fatalf("expected the cart to be checked out, got %v", err)
+	}
+
+	invoices, err := NewInvoiceDAO().GetByUserID(ctx, buyer.ID.Int64)
+	if err != nil || len(invoices) != 1 || invoices[0].TotalPrice.Units != 250 {
+		t.Errorf("expected an invoice of 250, got %v, %v", invoices, err)
+	}
+}
+
+func TestOrderDAO_RejectsInvalidStatusTransition(t *testing.T) {
+	ctx := testContext(t)
+	buyer := createTestUser(t, "skipping-buyer")
+	cart, err := NewOrderDAO().GetCart(ctx, buyer.ID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cart.Status = model.Completed
+	if _, err := NewOrderDAO().Update(ctx, cart); !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Errorf("expected an invalid status transition, got %v", err)
+	}
+}
@@ -1,27 +1,29 @@
 package dao
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"strings"
 
+	"github.com/vladoiliev02/online-store/metrics"
 	"github.com/vladoiliev02/online-store/model"
 )
 
 const (
-	minPage     = 1
-	minPageSize = 40
-	maxPageSize = 80
+	minPage            = 1
+	defaultMinPageSize = 40
+	defaultMaxPageSize = 80
 )
 
 const (
 	selectProducts = `
-		SELECT id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id
+		SELECT id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, version
 		FROM products
 	`
 
 	selectProductsWithPagination = `
-		SELECT id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, (SELECT count(*) FROM products WHERE available AND (category & $3) != 0) as count
+		SELECT id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, version, (SELECT count(*) FROM products WHERE available AND (category & $3) != 0) as count
 		FROM products
 		WHERE available AND (category & $3) != 0
 		ORDER BY rating DESC
@@ -31,8 +33,11 @@ const (
 	selectProductByID = selectProducts +
 		" WHERE id = $1"
 
+	selectProductsByIDs = selectProducts +
+		" WHERE id = ANY($1)"
+
 	selectProductByName = `
-		SELECT id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, (SELECT count(*) FROM products WHERE name LIKE $1 AND available AND (category & $4) != 0) as count
+		SELECT id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, version, (SELECT count(*) FROM products WHERE name LIKE $1 AND available AND (category & $4) != 0) as count
 		FROM products
 		WHERE LOWER(name) LIKE $1 AND available AND (category & $4) != 0
 		ORDER BY rating DESC
@@ -40,7 +45,7 @@ const (
 	`
 
 	selectProductsByUserID = `
-		SELECT id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, (SELECT count(*) FROM products WHERE user_id = $1) as count
+		SELECT id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, version, (SELECT count(*) FROM products WHERE user_id = $1) as count
 		FROM products
 		WHERE user_id = $1
 		ORDER BY rating DESC
@@ -50,14 +55,21 @@ const (
 	insertProduct = `
 		INSERT INTO products(name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, user_id)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, 0, 0, $8)
-		RETURNING id, created_at, rating, ratings_count
+		RETURNING id, created_at, rating, ratings_count, version
 	`
 
 	updateProduct = `
 		UPDATE products
-		SET description = $1, price_units = $2, price_currency = $3, quantity = $4, category = $5, available = $6
-		WHERE id = $7
-		RETURNING name, rating, ratings_count, user_id
+		SET name = COALESCE($1, name), description = $2, price_units = $3, price_currency = $4, quantity = $5, category = $6, available = $7, version = version + 1
+		WHERE id = $8 AND ($9 = 0 OR version = $9)
+		RETURNING id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, version
+	`
+
+	adjustProductQuantity = `
+		UPDATE products
+		SET quantity = quantity + $1, version = version + 1
+		WHERE id = $2 AND quantity + $1 >= 0
+		RETURNING id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, version
 	`
 
 	getRating = `
@@ -66,6 +78,18 @@ const (
 		WHERE user_id = $1 AND product_id = $2
 	`
 
+	selectRatingsByProductIDs = `
+		SELECT user_id, product_id, rating
+		FROM ratings
+		WHERE product_id = ANY($1)
+	`
+
+	selectRatingsByUserID = `
+		SELECT user_id, product_id, rating
+		FROM ratings
+		WHERE user_id = $1
+	`
+
 	insertRating = `
 		INSERT INTO ratings(user_id, product_id, rating)
 		VALUES ($1, $2, $3)
@@ -79,16 +103,16 @@ const (
 
 	updateProductNewRating = `
 		UPDATE products
-		SET rating = (rating * ratings_count + $1) / (ratings_count + 1), ratings_count = ratings_count + 1
+		SET rating = (rating * ratings_count + $1) / (ratings_count + 1), ratings_count = ratings_count + 1, version = version + 1
 		WHERE id = $2
-		RETURNING id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id
+		RETURNING id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, version
 	`
 
 	updateProductExistingRating = `
 		UPDATE products
-		SET rating = (rating * ratings_count + $1 - $2) / ratings_count
+		SET rating = (rating * ratings_count + $1 - $2) / ratings_count, version = version + 1
 		WHERE id = $3
-		RETURNING id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id
+		RETURNING id, name, description, price_units, price_currency, quantity, category, available, rating, ratings_count, created_at, user_id, version
 	`
 )
 
@@ -108,14 +132,14 @@ func newProductDAO(qe queryExecutor) *ProductDAO {
 	}
 }
 
-func (p *ProductDAO) GetAll(page, pageSize int, category model.ProductCategory) ([]*model.Product, int64, error) {
+func (p *ProductDAO) GetAll(ctx context.Context, page, pageSize int, category model.ProductCategory) ([]*model.Product, int64, error) {
 	pageSize, offset := getPageSizeAndOffset(pageSize, page)
 
 	var count int64
-	products, err := executeMultiRowQuery(p.qe,
+	products, err := executeMultiRowQuery(ctx, p.dao.reader(ctx, p.qe),
 		func(row rowScanner) (*model.Product, error) {
 			var product model.Product
-			return propertyScanner(&product, &product.ID, &product.Name, &product.Description, &product.Price.Units, &product.Price.Currency, &product.Quantity, &product.Category, &product.Available, &product.Rating, &product.RatingsCount, &product.CreatedAt, &product.UserID, &count)(row)
+			return propertyScanner(&product, &product.ID, &product.Name, &product.Description, &product.Price.Units, &product.Price.Currency, &product.Quantity, &product.Category, &product.Available, &product.Rating, &product.RatingsCount, &product.CreatedAt, &product.UserID, &product.Version, &count)(row)
 		},
 		selectProductsWithPagination,
 		pageSize, offset, category)
@@ -123,21 +147,28 @@ func (p *ProductDAO) GetAll(page, pageSize int, category model.ProductCategory)
 	return products, count, err
 }
 
-func (p *ProductDAO) GetByID(id int64) (*model.Product, error) {
-	return executeSingleRowQuery(p.qe,
+func (p *ProductDAO) GetByID(ctx context.Context, id int64) (*model.Product, error) {
+	return executeSingleRowQuery(ctx, p.qe,
 		scanProduct,
 		selectProductByID,
 		id)
 }
 
-func (p *ProductDAO) GetByNameLike(name string, page, pageSize int, category model.ProductCategory) ([]*model.Product, int64, error) {
+func (p *ProductDAO) GetByIDs(ctx context.Context, ids []int64) ([]*model.Product, error) {
+	return executeMultiRowQuery(ctx, p.qe,
+		scanProduct,
+		selectProductsByIDs,
+		int64Array(ids))
+}
+
+func (p *ProductDAO) GetByNameLike(ctx context.Context, name string, page, pageSize int, category model.ProductCategory) ([]*model.Product, int64, error) {
 	pageSize, offset := getPageSizeAndOffset(pageSize, page)
 
 	var count int64
-	products, err := executeMultiRowQuery(p.qe,
+	products, err := executeMultiRowQuery(ctx, p.dao.reader(ctx, p.qe),
 		func(row rowScanner) (*model.Product, error) {
 			var product model.Product
-			return propertyScanner(&product, &product.ID, &product.Name, &product.Description, &product.Price.Units, &product.Price.Currency, &product.Quantity, &product.Category, &product.Available, &product.Rating, &product.RatingsCount, &product.CreatedAt, &product.UserID, &count)(row)
+			return propertyScanner(&product, &product.ID, &product.Name, &product.Description, &product.Price.Units, &product.Price.Currency, &product.Quantity, &product.Category, &product.Available, &product.Rating, &product.RatingsCount, &product.CreatedAt, &product.UserID, &product.Version, &count)(row)
 		},
 		selectProductByName,
 		"%"+strings.ToLower(name)+"%", pageSize, offset, category)
@@ -145,14 +176,14 @@ func (p *ProductDAO) GetByNameLike(name string, page, pageSize int, category mod
 	return products, count, err
 }
 
-func (p *ProductDAO) GetByUserID(userID int64, page, pageSize int) ([]*model.Product, int64, error) {
+func (p *ProductDAO) GetByUserID(ctx context.Context, userID int64, page, pageSize int) ([]*model.Product, int64, error) {
 	pageSize, offset := getPageSizeAndOffset(pageSize, page)
 
 	var count int64
-	products, err := executeMultiRowQuery(p.qe,
+	products, err := executeMultiRowQuery(ctx, p.dao.reader(ctx, p.qe),
 		func(row rowScanner) (*model.Product, error) {
 			var product model.Product
-			return propertyScanner(&product, &product.ID, &product.Name, &product.Description, &product.Price.Units, &product.Price.Currency, &product.Quantity, &product.Category, &product.Available, &product.Rating, &product.RatingsCount, &product.CreatedAt, &product.UserID, &count)(row)
+			return propertyScanner(&product, &product.ID, &product.Name, &product.Description, &product.Price.Units, &product.Price.Currency, &product.Quantity, &product.Category, &product.Available, &product.Rating, &product.RatingsCount, &product.CreatedAt, &product.UserID, &product.Version, &count)(row)
 		},
 		selectProductsByUserID,
 		userID, pageSize, offset)
@@ -160,26 +191,64 @@ func (p *ProductDAO) GetByUserID(userID int64, page, pageSize int) ([]*model.Pro
 	return products, count, err
 }
 
-func (p *ProductDAO) Create(product *model.Product) (*model.Product, error) {
-	return executeSingleRowQuery(p.qe,
-		propertyScanner(product, &product.ID, &product.CreatedAt, &product.Rating, &product.RatingsCount),
+func (p *ProductDAO) Create(ctx context.Context, product *model.Product) (*model.Product, error) {
+	return executeSingleRowQuery(ctx, p.qe,
+		propertyScanner(product, &product.ID, &product.CreatedAt, &product.Rating, &product.RatingsCount, &product.Version),
 		insertProduct,
 		product.Name, product.Description, product.Price.Units, product.Price.Currency, product.Quantity,
 		product.Category, product.Available, product.UserID)
 }
 
-func (p *ProductDAO) Update(product *model.Product) (*model.Product, error) {
-	return executeSingleRowQuery(p.qe,
-		propertyScanner(product, &product.Name, &product.Rating, &product.RatingsCount, &product.UserID),
-		updateProduct,
-		product.Description, product.Price.Units, product.Price.Currency, product.Quantity, product.Category, product.Available, product.ID)
+// Update replaces the editable fields of a product, keeping the stored name if
+// product.Name is null. If product.Version is set, the update only succeeds if
+// it matches the stored version.
+func (p *ProductDAO) Update(ctx context.Context, product *model.Product) (*model.Product, error) {
+	return executeInTransactionOf(ctx, p.dao.db, p.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Product, error) {
+			before, err := newProductDAO(tx).GetByID(ctx, product.ID.Int64)
+			if err != nil {
+				return nil, versionError(err, product.Version)
+			}
+
+			after, err := executeSingleRowQuery(ctx, tx,
+				scanProduct,
+				updateProduct,
+				product.Name, product.Description, product.Price.Units, product.Price.Currency, product.Quantity, product.Category, product.Available, product.ID, product.Version)
+			if err != nil {
+				return nil, versionError(err, product.Version)
+			}
+			product.Name, product.Rating, product.RatingsCount, product.UserID, product.Version = after.Name, after.Rating, after.RatingsCount, after.UserID, after.Version
+
+			return product, recordAudit(ctx, tx, model.AuditProductUpdate, auditProduct, after.ID.Int64, before, after)
+		})
+}
+
+// AdjustQuantity atomically adds delta to the quantity in stock. It fails
+// with sql.ErrNoRows if the product does not exist or the stock would become negative.
+func (p *ProductDAO) AdjustQuantity(ctx context.Context, id, delta int64) (*model.Product, error) {
+	return executeInTransactionOf(ctx, p.dao.db, p.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.Product, error) {
+			after, err := executeSingleRowQuery(ctx, tx,
+				scanProduct,
+				adjustProductQuantity,
+				delta, id)
+			if err != nil {
+				return nil, err
+			}
+
+			// The update is atomic, so the product before it follows from after.
+			before := *after
+			before.Quantity.Int64 -= delta
+			before.Version--
+			return after, recordAudit(ctx, tx, model.AuditStockAdjust, auditProduct, id, &before, after)
+		})
 }
 
-func (p *ProductDAO) AddRating(rating *model.Rating) (*model.Product, error) {
-	_, err := executeInTransaction(p.dao.db,
-		func(tx *sql.Tx) (int, error) {
+func (p *ProductDAO) AddRating(ctx context.Context, rating *model.Rating) (*model.Product, error) {
+	_, err := executeInTransaction(ctx, p.dao.db, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (int, error) {
 			var er model.Rating
-			existingRating, err := executeSingleRowQuery(tx,
+			existingRating, err := executeSingleRowQuery(ctx, tx,
 				propertyScanner(&er, &er.UserID, &er.ProductID, &er.Rating),
 				getRating,
 				rating.UserID, rating.ProductID,
@@ -189,22 +258,22 @@ func (p *ProductDAO) AddRating(rating *model.Rating) (*model.Product, error) {
 			}
 
 			if errors.Is(err, sql.ErrNoRows) {
-				err = executeNoRowsQuery(tx, insertRating, rating.UserID, rating.ProductID, rating.Rating)
+				err = executeNoRowsQuery(ctx, tx, insertRating, rating.UserID, rating.ProductID, rating.Rating)
 				if err != nil {
 					return 1, err
 				}
 
-				err = executeNoRowsQuery(tx, updateProductNewRating, rating.Rating, rating.ProductID)
+				err = executeNoRowsQuery(ctx, tx, updateProductNewRating, rating.Rating, rating.ProductID)
 				if err != nil {
 					return 1, err
 				}
 			} else {
-				err = executeNoRowsQuery(tx, updateRating, rating.Rating, rating.UserID, rating.ProductID)
+				err = executeNoRowsQuery(ctx, tx, updateRating, rating.Rating, rating.UserID, rating.ProductID)
 				if err != nil {
 					return 1, err
 				}
 
-				err = executeNoRowsQuery(tx, updateProductExistingRating, rating.Rating, existingRating.Rating, rating.ProductID)
+				err = executeNoRowsQuery(ctx, tx, updateProductExistingRating, rating.Rating, existingRating.Rating, rating.ProductID)
 				if err != nil {
 					return 1, err
 				}
@@ -216,13 +285,30 @@ func (p *ProductDAO) AddRating(rating *model.Rating) (*model.Product, error) {
 	if err != nil {
 		return nil, &DAOError{Query: "Update product rating transaction", Message: "Error while updating the rating", Err: err}
 	}
+	metrics.RatingsSubmitted.Inc()
 
-	return p.GetByID(rating.ProductID.Int64)
+	return p.GetByID(ctx, rating.ProductID.Int64)
+}
+
+func (p *ProductDAO) GetRatingsByProductIDs(ctx context.Context, productIDs []int64) ([]*model.Rating, error) {
+	return executeMultiRowQuery(ctx, p.qe, scanRating,
+		selectRatingsByProductIDs,
+		int64Array(productIDs))
+}
+
+func (p *ProductDAO) GetRatingsByUserID(ctx context.Context, userID int64) ([]*model.Rating, error) {
+	return executeMultiRowQuery(ctx, p.qe, scanRating,
+		selectRatingsByUserID, userID)
+}
+
+func scanRating(row rowScanner) (*model.Rating, error) {
+	var rating model.Rating
+	return propertyScanner(&rating, &rating.UserID, &rating.ProductID, &rating.Rating)(row)
 }
 
 func scanProduct(row rowScanner) (*model.Product, error) {
 	var product model.Product
-	return propertyScanner(&product, &product.ID, &product.Name, &product.Description, &product.Price.Units, &product.Price.Currency, &product.Quantity, &product.Category, &product.Available, &product.Rating, &product.RatingsCount, &product.CreatedAt, &product.UserID)(row)
+	return propertyScanner(&product, &product.ID, &product.Name, &product.Description, &product.Price.Units, &product.Price.Currency, &product.Quantity, &product.Category, &product.Available, &product.Rating, &product.RatingsCount, &product.CreatedAt, &product.UserID, &product.Version)(row)
 }
 
 func getPageSizeAndOffset(pageSize, page int) (int, int) {
@@ -230,6 +316,11 @@ func getPageSizeAndOffset(pageSize, page int) (int, int) {
 		page = minPage
 	}
 
+	minPageSize, maxPageSize := defaultMinPageSize, defaultMaxPageSize
+	if options != nil && options.MinPageSize > 0 && options.MaxPageSize >= options.MinPageSize {
+		minPageSize, maxPageSize = options.MinPageSize, options.MaxPageSize
+	}
+
 	if pageSize < minPageSize {
 		pageSize = minPageSize
 	}
@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+func TestProductDAO_Listing(t *testing.T) {
+	ctx := testContext(t)
+	seller := createTestUser(t, "seller")
+	book := createTestProduct(t, seller.ID.Int64, "Listing book", model.Books, 3)
+	createTestProduct(t, seller.ID.Int64, "Listing shoes", model.Shoes, 3)
+
+	products, count, err := NewProductDAO().GetByUserID(ctx, seller.ID.Int64, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || len(products) != 2 {
+		t.Errorf("expected 2 products of the seller, got %d of %d", len(products), count)
+	}
+
+	products, _, err = NewProductDAO().GetByNameLike(ctx, "%listing%", 1, 10, model.Books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || products[0].ID != book.ID {
+		t.Errorf("expected only the book, got %v", products)
+	}
+
+	products, err = NewProductDAO().GetByIDs(ctx, []int64{book.ID.Int64})
+	if err != nil || len(products) != 1 || !products[0].Available.Bool {
+		t.Errorf("expected the available book by ID, got %v, %v", products, err)
+	}
+}
+
+func TestProductDAO_AddRating(t *testing.T) {
+	ctx := testContext(t)
+	seller, buyer := createTestUser(t, "rated-seller"), createTestUser(t, "rater")
+	product := createTestProduct(t, seller.ID.Int64, "Rated product", model.Home, 1)
+
+	rating := &model.Rating{UserID: buyer.ID, ProductID: product.ID, Rating: model.NullInt64JSON{Int64: 4, Valid: true}}
+	if _, err := NewProductDAO().AddRating(ctx, rating); err != nil {
+		t.Fatal(err)
+	}
+
+	rating.Rating.Int64 = 2
+	rated, err := NewProductDAO().AddRating(ctx, rating)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rated.RatingsCount.Int64 != 1 || rated.Rating.Float64 != 2 {
+		t.Errorf("expected a single rating of 2, got %v from %d", rated.Rating.Float64, rated.RatingsCount.Int64)
+	}
+
+	ratings, err := NewProductDAO().GetRatingsByProductIDs(ctx, []int64{product.ID.Int64})
+	if err != nil || len(ratings) != 1 {
+		t.Errorf("expected one rating, got %v, %v", ratings, err)
+	}
+}
+
+func TestProductDAO_AdjustQuantity(t *testing.T) {
+	ctx := testContext(t)
+	seller := createTestUser(t, "stock-seller")
+	product := createTestProduct(t, seller.ID.Int64, "Stocked product", model.Sport, 2)
+
+	adjusted, err := NewProductDAO().AdjustQuantity(ctx, product.ID.Int64, -2)
+	if err != nil || adjusted.Quantity.Int64 != 0 {
+		t.Fatalf("expected the stock to drop to 0, got %v, %v", adjusted, err)
+	}
+
+	if _, err := NewProductDAO().AdjustQuantity(ctx, product.ID.Int64, -1); err == nil {
+		t.Error("expected the stock not to become negative")
+	}
+}
@@ -0,0 +1,78 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	// takeRateLimit moves the arrival time of a bucket forward unless it would
+	// pass the limit, in which case no row is returned. Times are Unix
+	// microseconds.
+	takeRateLimit = `
+		INSERT INTO rate_limits(bucket, arrival_at)
+		VALUES ($1, $2)
+		ON CONFLICT (bucket) DO UPDATE
+		SET arrival_at = CASE WHEN rate_limits.arrival_at > $3 THEN rate_limits.arrival_at ELSE $3 END + $4
+		WHERE CASE WHEN rate_limits.arrival_at > $3 THEN rate_limits.arrival_at ELSE $3 END + $4 <= $5
+		RETURNING arrival_at;
+	`
+
+	selectRateLimit = `
+		SELECT arrival_at
+		FROM rate_limits
+		WHERE bucket = $1;
+	`
+
+	deleteExpiredRateLimits = `
+		DELETE FROM rate_limits
+		WHERE arrival_at <= $1;
+	`
+)
+
+// RateLimitDAO keeps the buckets of the rate limiter in the database, so that
+// the replicas share them. It is a ratelimit.Store.
+type RateLimitDAO struct {
+	dao *DAO
+	qe  queryExecutor
+}
+
+func NewRateLimitDAO() *RateLimitDAO {
+	return newRateLimitDAO(GetDAO().db)
+}
+
+func newRateLimitDAO(qe queryExecutor) *RateLimitDAO {
+	return &RateLimitDAO{
+		dao: GetDAO(),
+		qe:  qe,
+	}
+}
+
+func (r *RateLimitDAO) Take(ctx context.Context, key string, now, limit time.Time, interval time.Duration) (time.Time, bool, error) {
+	arrival, err := executeSingleRowQuery(ctx, r.qe, scanArrival,
+		takeRateLimit, key, now.Add(interval).UnixMicro(), now.UnixMicro(), interval.Microseconds(), limit.UnixMicro())
+	if err == nil {
+		return time.UnixMicro(arrival).UTC(), true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, err
+	}
+
+	arrival, err = executeSingleRowQuery(ctx, r.qe, scanArrival, selectRateLimit, key)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMicro(arrival).UTC(), false, nil
+}
+
+func (r *RateLimitDAO) DeleteExpired(ctx context.Context, now time.Time) error {
+	return executeNoRowsQuery(ctx, r.qe, deleteExpiredRateLimits, now.UnixMicro())
+}
+
+func scanArrival(row rowScanner) (int64, error) {
+	var arrival int64
+	err := row.Scan(&arrival)
+	return arrival, err
+}
@@ -0,0 +1,36 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitDAO_Take(t *testing.T) {
+	ctx := testContext(t)
+	rateLimitDAO := NewRateLimitDAO()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	limit, interval := now.Add(time.Minute), 30*time.Second
+
+	for i := 1; i <= 2; i++ {
+		arrival, ok, err := rateLimitDAO.Take(ctx, "test:bucket", now, limit, interval)
+		if err != nil || !ok || !arrival.Equal(now.Add(time.Duration(i)*interval)) {
+			t.Fatalf("expected request %d to be allowed, got %v, %v, %v", i, arrival, ok, err)
+		}
+	}
+
+	arrival, ok, err := rateLimitDAO.Take(ctx, "test:bucket", now, limit, interval)
+	if err != nil || ok || !arrival.Equal(limit) {
+		t.Fatalf("expected the bucket to be empty, got %v, %v, %v", arrival, ok, err)
+	}
+
+	if _, ok, _ := rateLimitDAO.Take(ctx, "test:bucket", now.Add(interval), limit.Add(interval), interval); !ok {
+		t.Error("expected a token after an interval")
+	}
+
+	if err := rateLimitDAO.DeleteExpired(ctx, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if arrival, ok, _ := rateLimitDAO.Take(ctx, "test:bucket", now, limit, interval); !ok || !arrival.Equal(now.Add(interval)) {
+		t.Errorf("expected the expired bucket to be full again, got %v", arrival)
+	}
+}
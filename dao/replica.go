@@ -0,0 +1,113 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReplicaCheckInterval = 5 * time.Second
+
+type primaryCtxKey struct{}
+
+// replica is a read-only copy of the primary database. It only serves
+// queries while its last health check passed.
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+type ReplicaHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+}
+
+// WithPrimary makes DAO calls with the returned context read from the primary,
+// for paths that have to see their own writes.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryCtxKey{}).(bool)
+	return primary
+}
+
+func openReplicas(connStrs []string) []*replica {
+	replicas := make([]*replica, 0, len(connStrs))
+	for i, connStr := range connStrs {
+		db, err := sql.Open(options.DriverName, dialect.DataSourceName(connStr))
+		if err != nil {
+			panic(err.Error())
+		}
+		configurePool(db)
+
+		replicas = append(replicas, &replica{name: "replica-" + strconv.Itoa(i+1), db: db})
+	}
+	return replicas
+}
+
+// reader returns the executor for a read-only query that may lag behind the
+// primary. Queries in a transaction, or with a WithPrimary context, keep
+// using qe. Healthy replicas are picked in turn, falling back to the primary
+// when none is healthy.
+func (d *DAO) reader(ctx context.Context, qe queryExecutor) queryExecutor {
+	if qe != queryExecutor(d.db) || len(d.replicas) == 0 || usePrimary(ctx) {
+		return qe
+	}
+
+	start := d.nextReplica.Add(1)
+	for i := range d.replicas {
+		replica := d.replicas[(int(start)+i)%len(d.replicas)]
+		if replica.healthy.Load() {
+			return replica.db
+		}
+	}
+
+	return qe
+}
+
+// checkReplicas pings the replicas every interval until done is closed.
+func (d *DAO) checkReplicas(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, replica := range d.replicas {
+			d.checkReplica(replica, interval)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *DAO) checkReplica(replica *replica, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := replica.db.PingContext(ctx)
+	healthy := err == nil
+	if replica.healthy.Swap(healthy) != healthy {
+		if healthy {
+			slog.Info("Replica is healthy, routing reads to it", "replica", replica.name)
+		} else {
+			slog.Warn("Replica is unhealthy, routing its reads to the primary", "replica", replica.name, "error", err)
+		}
+	}
+}
+
+func (d *DAO) replicaHealth() []ReplicaHealth {
+	health := make([]ReplicaHealth, 0, len(d.replicas))
+	for _, replica := range d.replicas {
+		health = append(health, ReplicaHealth{Name: replica.name, Healthy: replica.healthy.Load()})
+	}
+	return health
+}
@@ -0,0 +1,70 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// pingDriver opens connections whose Ping fails for the "down" data source.
+type pingDriver struct{}
+
+type pingConn struct{ down bool }
+
+func (pingDriver) Open(name string) (driver.Conn, error) { return pingConn{down: name == "down"}, nil }
+func (pingConn) Prepare(string) (driver.Stmt, error)     { return nil, driver.ErrSkip }
+func (pingConn) Close() error                            { return nil }
+func (pingConn) Begin() (driver.Tx, error)               { return nil, driver.ErrSkip }
+
+func (c pingConn) Ping(context.Context) error {
+	if c.down {
+		return errors.New("replica is down")
+	}
+	return nil
+}
+
+func init() {
+	sql.Register("replica-stub", pingDriver{})
+}
+
+func openStub(t *testing.T, name string) *sql.DB {
+	db, err := sql.Open("replica-stub", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestReader_RoutesToHealthyReplicas(t *testing.T) {
+	d := &DAO{
+		db:       openStub(t, "primary"),
+		replicas: []*replica{{name: "up", db: openStub(t, "up")}, {name: "down", db: openStub(t, "down")}},
+	}
+	for _, replica := range d.replicas {
+		d.checkReplica(replica, time.Second)
+	}
+
+	for i := 0; i < 4; i++ {
+		if reader := d.reader(context.Background(), d.db); reader != queryExecutor(d.replicas[0].db) {
+			t.Fatalf("expected reads on the healthy replica, got %v", reader)
+		}
+	}
+
+	if reader := d.reader(WithPrimary(context.Background()), d.db); reader != queryExecutor(d.db) {
+		t.Error("expected WithPrimary to read from the primary")
+	}
+
+	tx := &sql.Tx{}
+	if reader := d.reader(context.Background(), tx); reader != queryExecutor(tx) {
+		t.Error("expected transactions to keep their executor")
+	}
+
+	d.replicas[0].healthy.Store(false)
+	if reader := d.reader(context.Background(), d.db); reader != queryExecutor(d.db) {
+		t.Error("expected a fallback to the primary without healthy replicas")
+	}
+}
@@ -0,0 +1,138 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	selectSessions = `
+		SELECT id, user_id, token_hash, data, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions
+	`
+
+	selectSessionByTokenHash = selectSessions +
+		"WHERE token_hash = $1;"
+
+	selectActiveSessionsByUserID = selectSessions +
+		"WHERE user_id = $1 AND expires_at > $2 AND last_seen_at > $3 ORDER BY last_seen_at DESC;"
+
+	insertSession = `
+		INSERT INTO sessions(user_id, token_hash, data, user_agent, ip_address, created_at, last_seen_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id;
+	`
+
+	updateSession = `
+		UPDATE sessions
+		SET user_id = $1, data = $2, last_seen_at = $3
+		WHERE id = $4
+		RETURNING id;
+	`
+
+	deleteSession = `
+		DELETE FROM sessions
+		WHERE id = $1;
+	`
+
+	deleteUserSession = `
+		DELETE FROM sessions
+		WHERE id = $1 AND user_id = $2
+		RETURNING id;
+	`
+
+	deleteSessionsByUserID = `
+		DELETE FROM sessions
+		WHERE user_id = $1;
+	`
+
+	deleteExpiredSessions = `
+		DELETE FROM sessions
+		WHERE expires_at <= $1 OR last_seen_at <= $2;
+	`
+)
+
+// SessionDAO stores the sessions of the security package in the database, so
+// that they are shared by all instances of the store and can be revoked.
+type SessionDAO struct {
+	dao *DAO
+	qe  queryExecutor
+}
+
+func NewSessionDAO() *SessionDAO {
+	return newSessionDAO(GetDAO().db)
+}
+
+func newSessionDAO(qe queryExecutor) *SessionDAO {
+	return &SessionDAO{
+		dao: GetDAO(),
+		qe:  qe,
+	}
+}
+
+func (s *SessionDAO) GetByTokenHash(ctx context.Context, tokenHash string) (*model.Session, error) {
+	return executeSingleRowQuery(ctx, s.qe, scanSession,
+		selectSessionByTokenHash, tokenHash)
+}
+
+// GetActiveByUserID returns the sessions of a user that have neither expired
+// by now nor been idle since idleSince, most recently used first.
+func (s *SessionDAO) GetActiveByUserID(ctx context.Context, userID int64, now, idleSince time.Time) ([]*model.Session, error) {
+	return executeMultiRowQuery(ctx, s.qe, scanSession,
+		selectActiveSessionsByUserID, userID, now, idleSince)
+}
+
+func (s *SessionDAO) Create(ctx context.Context, session *model.Session) (*model.Session, error) {
+	if session == nil {
+		return nil, &DAOError{Query: insertSession, Message: "Nil Session"}
+	}
+
+	return executeSingleRowQuery(ctx, s.qe, propertyScanner(session, &session.ID),
+		insertSession, session.UserID, session.TokenHash, session.Data, session.UserAgent, session.IPAddress,
+		session.CreatedAt, session.LastSeenAt, session.ExpiresAt)
+}
+
+// Update stores the data and last use of a session. It fails with
+// sql.ErrNoRows if the session was revoked in the meantime.
+func (s *SessionDAO) Update(ctx context.Context, session *model.Session) (*model.Session, error) {
+	if session == nil {
+		return nil, &DAOError{Query: updateSession, Message: "Nil Session"}
+	}
+
+	return executeSingleRowQuery(ctx, s.qe, propertyScanner(session, &session.ID),
+		updateSession, session.UserID, session.Data, session.LastSeenAt, session.ID)
+}
+
+func (s *SessionDAO) Delete(ctx context.Context, id int64) error {
+	return executeNoRowsQuery(ctx, s.qe, deleteSession, id)
+}
+
+// DeleteByUser deletes a session of a user. It fails with sql.ErrNoRows if the
+// user has no such session.
+func (s *SessionDAO) DeleteByUser(ctx context.Context, userID, id int64) error {
+	_, err := executeSingleRowQuery(ctx, s.qe, func(row rowScanner) (int64, error) {
+		var id int64
+		err := row.Scan(&id)
+		return id, err
+	}, deleteUserSession, id, userID)
+	return err
+}
+
+func (s *SessionDAO) DeleteByUserID(ctx context.Context, userID int64) error {
+	return executeNoRowsQuery(ctx, s.qe, deleteSessionsByUserID, userID)
+}
+
+// DeleteExpired deletes the sessions that expired by now or have been idle
+// since idleSince.
+func (s *SessionDAO) DeleteExpired(ctx context.Context, now, idleSince time.Time) error {
+	return executeNoRowsQuery(ctx, s.qe, deleteExpiredSessions, now, idleSince)
+}
+
+func scanSession(row rowScanner) (*model.Session, error) {
+	var session model.Session
+	return propertyScanner(&session,
+		&session.ID, &session.UserID, &session.TokenHash, &session.Data, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)(row)
+}
@@ -0,0 +1,50 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+func TestSessionDAO(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "session-user")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	session, err := NewSessionDAO().Create(ctx, &model.Session{
+		UserID:     user.ID,
+		TokenHash:  "session-token-hash",
+		Data:       []byte{1, 2, 3},
+		UserAgent:  "test",
+		IPAddress:  "127.0.0.1",
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewSessionDAO().GetByTokenHash(ctx, "session-token-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ID != session.ID || string(loaded.Data) != "\x01\x02\x03" || !loaded.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected session %+v", loaded)
+	}
+
+	active, err := NewSessionDAO().GetActiveByUserID(ctx, user.ID.Int64, now, now.Add(-time.Minute))
+	if err != nil || len(active) != 1 {
+		t.Errorf("expected one active session, got %v, %v", active, err)
+	}
+
+	if err := NewSessionDAO().DeleteExpired(ctx, now, now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSessionDAO().Update(ctx, loaded); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the idle session to be deleted, got %v", err)
+	}
+}
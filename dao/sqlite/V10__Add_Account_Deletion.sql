@@ -0,0 +1,17 @@
+-- SQLite schema for local development, keep in sync with sql/V10__Add_Account_Deletion.sql.
+
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX users_deletion_scheduled_at_index ON users(deletion_scheduled_at);
+
+DROP TRIGGER audit_log_no_update;
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+WHEN NOT (NEW.ip_address = ''
+    AND NEW.id = OLD.id AND NEW.actor_id IS OLD.actor_id AND NEW.service = OLD.service
+    AND NEW.action = OLD.action AND NEW.entity_type = OLD.entity_type AND NEW.entity_id = OLD.entity_id
+    AND NEW.changes = OLD.changes AND NEW.request_id = OLD.request_id AND NEW.created_at IS OLD.created_at)
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
@@ -0,0 +1,10 @@
+-- SQLite schema for local development, keep in sync with sql/V11__Add_Unique_User_Emails.sql.
+
+UPDATE users
+SET email = ''
+WHERE email != '' AND EXISTS (
+    SELECT 1 FROM users older
+    WHERE LOWER(older.email) = LOWER(users.email) AND older.id < users.id
+);
+
+CREATE UNIQUE INDEX users_email_unique_index ON users(LOWER(email)) WHERE email != '';
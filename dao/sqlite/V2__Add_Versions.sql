@@ -0,0 +1,5 @@
+-- SQLite schema for local development, keep in sync with sql/V2__Add_Versions.sql.
+
+ALTER TABLE users ADD COLUMN version BIGINT DEFAULT 1 NOT NULL;
+ALTER TABLE products ADD COLUMN version BIGINT DEFAULT 1 NOT NULL;
+ALTER TABLE orders ADD COLUMN version BIGINT DEFAULT 1 NOT NULL;
@@ -0,0 +1,18 @@
+-- SQLite schema for local development, keep in sync with sql/V3__Add_Sessions.sql.
+
+CREATE TABLE sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash VARCHAR(64) NOT NULL,
+    user_id BIGINT,
+    data BLOB NOT NULL,
+    user_agent VARCHAR(512) NOT NULL,
+    ip_address VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    CONSTRAINT session_token_hash_unique_constraint UNIQUE (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX sessions_user_id_index ON sessions(user_id);
+CREATE INDEX sessions_expires_at_index ON sessions(expires_at);
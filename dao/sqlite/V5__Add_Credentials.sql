@@ -0,0 +1,24 @@
+-- SQLite schema for local development, keep in sync with sql/V5__Add_Credentials.sql.
+
+CREATE TABLE credentials (
+    user_id BIGINT PRIMARY KEY,
+    password_hash VARCHAR(255) NOT NULL,
+    email_verified BOOLEAN DEFAULT FALSE NOT NULL,
+    failed_attempts INT DEFAULT 0 NOT NULL,
+    locked_until TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE account_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id BIGINT NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT account_token_hash_unique_constraint UNIQUE (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX account_tokens_user_id_index ON account_tokens(user_id);
@@ -0,0 +1,8 @@
+-- SQLite schema for local development, keep in sync with sql/V8__Add_Rate_Limits.sql.
+
+CREATE TABLE rate_limits (
+    bucket VARCHAR(255) PRIMARY KEY,
+    arrival_at BIGINT NOT NULL
+);
+
+CREATE INDEX rate_limits_arrival_at_index ON rate_limits(arrival_at);
@@ -0,0 +1,28 @@
+-- SQLite schema for local development, keep in sync with sql/V9__Add_Audit_Log.sql.
+
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id BIGINT,
+    service VARCHAR(255) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    entity_type VARCHAR(64) NOT NULL,
+    entity_id BIGINT NOT NULL,
+    changes TEXT NOT NULL,
+    request_id VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_entity_index ON audit_log(entity_type, entity_id);
+CREATE INDEX audit_log_actor_id_index ON audit_log(actor_id);
+CREATE INDEX audit_log_created_at_index ON audit_log(created_at);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
@@ -0,0 +1,165 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/vladoiliev02/online-store/model"
+)
+
+const (
+	selectTwoFactorByUserID = `
+		SELECT t.user_id, t.secret, t.enabled, t.last_used_step,
+			(SELECT COUNT(*) FROM recovery_codes r WHERE r.user_id = t.user_id), t.created_at
+		FROM two_factor t
+		WHERE t.user_id = $1;
+	`
+
+	// upsertPendingTwoFactor starts over an enrolment that was not confirmed,
+	// but keeps an enabled authenticator.
+	upsertPendingTwoFactor = `
+		INSERT INTO two_factor(user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = excluded.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+		WHERE two_factor.enabled = FALSE
+		RETURNING user_id;
+	`
+
+	enableTwoFactor = `
+		UPDATE two_factor
+		SET enabled = TRUE, last_used_step = $2
+		WHERE user_id = $1 AND enabled = FALSE
+		RETURNING user_id;
+	`
+
+	// useTwoFactorStep records the time step of a code, which fails for a step
+	// that was used already.
+	useTwoFactorStep = `
+		UPDATE two_factor
+		SET last_used_step = $2
+		WHERE user_id = $1 AND enabled = TRUE AND last_used_step < $2
+		RETURNING user_id;
+	`
+
+	deleteTwoFactor = `
+		DELETE FROM two_factor
+		WHERE user_id = $1;
+	`
+
+	insertRecoveryCode = `
+		INSERT INTO recovery_codes(user_id, code_hash)
+		VALUES ($1, $2);
+	`
+
+	useRecoveryCode = `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1 AND code_hash = $2
+		RETURNING id;
+	`
+
+	deleteRecoveryCodes = `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1;
+	`
+)
+
+// TwoFactorDAO stores the TOTP authenticators of users and their recovery
+// codes, of which only the hashes are stored.
+type TwoFactorDAO struct {
+	dao *DAO
+	qe  queryExecutor
+}
+
+func NewTwoFactorDAO() *TwoFactorDAO {
+	return newTwoFactorDAO(GetDAO().db)
+}
+
+func newTwoFactorDAO(qe queryExecutor) *TwoFactorDAO {
+	return &TwoFactorDAO{
+		dao: GetDAO(),
+		qe:  qe,
+	}
+}
+
+func (t *TwoFactorDAO) GetByUserID(ctx context.Context, userID int64) (*model.TwoFactor, error) {
+	return executeSingleRowQuery(ctx, t.qe, scanTwoFactor,
+		selectTwoFactorByUserID, userID)
+}
+
+// CreatePending stores the secret of an authenticator that is yet to be
+// confirmed. It fails with sql.ErrNoRows if the user has one enabled.
+func (t *TwoFactorDAO) CreatePending(ctx context.Context, userID int64, secret string) error {
+	_, err := executeSingleRowQuery(ctx, t.qe, scanUserID, upsertPendingTwoFactor, userID, secret)
+	return err
+}
+
+// Enable enables the pending authenticator of a user, which was confirmed
+// with a code of step, and replaces their recovery codes. It fails with
+// sql.ErrNoRows if there is no pending authenticator.
+func (t *TwoFactorDAO) Enable(ctx context.Context, userID, step int64, recoveryCodeHashes []string) error {
+	_, err := executeInTransactionOf(ctx, t.dao.db, t.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (int64, error) {
+			if _, err := executeSingleRowQuery(ctx, tx, scanUserID, enableTwoFactor, userID, step); err != nil {
+				return 0, err
+			}
+			return userID, newTwoFactorDAO(tx).ReplaceRecoveryCodes(ctx, userID, recoveryCodeHashes)
+		})
+	return err
+}
+
+// UseStep records that a code of step was used. It fails with sql.ErrNoRows
+// if a code of the same or a later step was used already, so that an
+// intercepted code cannot be replayed.
+func (t *TwoFactorDAO) UseStep(ctx context.Context, userID, step int64) error {
+	_, err := executeSingleRowQuery(ctx, t.qe, scanUserID, useTwoFactorStep, userID, step)
+	return err
+}
+
+// UseRecoveryCode deletes a recovery code. It fails with sql.ErrNoRows if the
+// user has no such code.
+func (t *TwoFactorDAO) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	_, err := executeSingleRowQuery(ctx, t.qe, scanUserID, useRecoveryCode, userID, codeHash)
+	return err
+}
+
+func (t *TwoFactorDAO) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	_, err := executeInTransactionOf(ctx, t.dao.db, t.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (int64, error) {
+			if err := executeNoRowsQuery(ctx, tx, deleteRecoveryCodes, userID); err != nil {
+				return 0, err
+			}
+			for _, codeHash := range codeHashes {
+				if err := executeNoRowsQuery(ctx, tx, insertRecoveryCode, userID, codeHash); err != nil {
+					return 0, err
+				}
+			}
+			return userID, nil
+		})
+	return err
+}
+
+// Delete disables two-factor authentication for a user.
+func (t *TwoFactorDAO) Delete(ctx context.Context, userID int64) error {
+	_, err := executeInTransactionOf(ctx, t.dao.db, t.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (int64, error) {
+			if err := executeNoRowsQuery(ctx, tx, deleteRecoveryCodes, userID); err != nil {
+				return 0, err
+			}
+			return userID, executeNoRowsQuery(ctx, tx, deleteTwoFactor, userID)
+		})
+	return err
+}
+
+func scanTwoFactor(row rowScanner) (*model.TwoFactor, error) {
+	var twoFactor model.TwoFactor
+	return propertyScanner(&twoFactor,
+		&twoFactor.UserID, &twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastUsedStep,
+		&twoFactor.RecoveryCodesLeft, &twoFactor.CreatedAt)(row)
+}
+
+func scanUserID(row rowScanner) (int64, error) {
+	var userID int64
+	err := row.Scan(&userID)
+	return userID, err
+}
@@ -0,0 +1,58 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestTwoFactorDAO(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "two-factor-user")
+	userID := user.ID.Int64
+
+	if err := NewTwoFactorDAO().CreatePending(ctx, userID, "FIRST"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewTwoFactorDAO().UseStep(ctx, userID, 10); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a pending authenticator not to be usable, got %v", err)
+	}
+	if err := NewTwoFactorDAO().CreatePending(ctx, userID, "SECOND"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewTwoFactorDAO().Enable(ctx, userID, 10, []string{"code-1", "code-2"}); err != nil {
+		t.Fatal(err)
+	}
+	twoFactor, err := NewTwoFactorDAO().GetByUserID(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !twoFactor.Enabled || twoFactor.Secret != "SECOND" || twoFactor.RecoveryCodesLeft != 2 {
+		t.Errorf("unexpected authenticator %+v", twoFactor)
+	}
+
+	if err := NewTwoFactorDAO().CreatePending(ctx, userID, "THIRD"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected an enabled authenticator to be kept, got %v", err)
+	}
+	if err := NewTwoFactorDAO().UseStep(ctx, userID, 10); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the confirmation step not to be usable again, got %v", err)
+	}
+	if err := NewTwoFactorDAO().UseStep(ctx, userID, 11); err != nil {
+		t.Errorf("expected a later step to be usable, got %v", err)
+	}
+
+	if err := NewTwoFactorDAO().UseRecoveryCode(ctx, userID, "code-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewTwoFactorDAO().UseRecoveryCode(ctx, userID, "code-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a recovery code to work once, got %v", err)
+	}
+
+	if err := NewTwoFactorDAO().Delete(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTwoFactorDAO().GetByUserID(ctx, userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected two-factor authentication to be disabled, got %v", err)
+	}
+}
@@ -1,14 +1,16 @@
 package dao
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/vladoiliev02/online-store/model"
 )
 
 const (
 	selectAllUsers = `
-		SELECT u.id, u.name, u.first_name, u.last_name, u.picture_url, u.email, u.created_at,
+		SELECT u.id, u.name, u.first_name, u.last_name, u.picture_url, u.email, u.created_at, u.version, u.deletion_scheduled_at,
 			a.id, a.city, a.country, a.address, a.postal_code
 		FROM users u
 		LEFT JOIN addresses a ON u.address_id = a.id
@@ -17,27 +19,76 @@ const (
 	selectUserByID = selectAllUsers +
 		"WHERE u.id = $1;"
 
+	selectUsersByIDs = selectAllUsers +
+		"WHERE u.id = ANY($1);"
+
 	selectUserByEmail = selectAllUsers +
 		"WHERE u.email = $1;"
 
 	insertUser = `
 		INSERT INTO users(name, first_name, last_name, picture_url, email, address_id, created_at)
 		VALUES($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
-		RETURNING id, created_at;
+		RETURNING id, created_at, version;
 	`
 
-	updateUsersAddress = `
+	updateUser = `
 		UPDATE users
-		SET address_id=$1
-		WHERE id=$2;
+		SET name=COALESCE($1, name), first_name=COALESCE($2, first_name), last_name=COALESCE($3, last_name), picture_url=COALESCE($4, picture_url), address_id=$5, version=version + 1
+		WHERE id=$6 AND ($7 = 0 OR version=$7)
+		RETURNING name, first_name, last_name, picture_url, email, created_at, version, deletion_scheduled_at;
 	`
 
-	deleteUser = `
-		DELETE FROM users
-		WHERE id=$1;
+	scheduleUserDeletion = `
+		UPDATE users
+		SET deletion_scheduled_at = $2
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id;
+	`
+
+	cancelUserDeletion = `
+		UPDATE users
+		SET deletion_scheduled_at = NULL
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL AND deleted_at IS NULL
+		RETURNING id;
+	`
+
+	selectUsersDueForDeletion = `
+		SELECT id
+		FROM users
+		WHERE deletion_scheduled_at <= $1 AND deleted_at IS NULL;
+	`
+
+	// anonymizeUser keeps the user, which orders, invoices and ratings refer
+	// to, but nothing that identifies them.
+	anonymizeUser = `
+		UPDATE users
+		SET name = 'Deleted user', first_name = 'Deleted', last_name = 'User', picture_url = '', email = '', address_id = NULL,
+			deletion_scheduled_at = NULL, deleted_at = $2, version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id;
+	`
+
+	// withdrawUserProducts takes the products of a deleted seller off sale.
+	withdrawUserProducts = `
+		UPDATE products
+		SET available = FALSE, version = version + 1
+		WHERE user_id = $1 AND available;
 	`
 )
 
+// deleteUserData deletes what the database does not cascade, as deleted users
+// are kept.
+var deleteUserData = []string{
+	"DELETE FROM comments WHERE user_id = $1;",
+	"DELETE FROM sessions WHERE user_id = $1;",
+	"DELETE FROM identities WHERE user_id = $1;",
+	"DELETE FROM credentials WHERE user_id = $1;",
+	"DELETE FROM account_tokens WHERE user_id = $1;",
+	"DELETE FROM recovery_codes WHERE user_id = $1;",
+	"DELETE FROM two_factor WHERE user_id = $1;",
+	"DELETE FROM api_tokens WHERE user_id = $1;",
+}
+
 type UserDAO struct {
 	dao *DAO
 	qe  queryExecutor
@@ -54,70 +105,187 @@ func newUserDAO(qe queryExecutor) *UserDAO {
 	}
 }
 
-func (u *UserDAO) GetAll() ([]*model.User, error) {
-	return executeMultiRowQuery(u.qe, u.scanUser,
+func (u *UserDAO) GetAll(ctx context.Context) ([]*model.User, error) {
+	return executeMultiRowQuery(ctx, u.qe, u.scanUser,
 		selectAllUsers)
 }
 
-func (u *UserDAO) GetByEmail(email string) (*model.User, error) {
-	return executeSingleRowQuery(u.qe, u.scanUser,
+func (u *UserDAO) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	return executeSingleRowQuery(ctx, u.qe, u.scanUser,
 		selectUserByEmail, email)
 }
 
-func (u *UserDAO) GetByID(id int64) (*model.User, error) {
-	return executeSingleRowQuery(u.qe, u.scanUser,
+func (u *UserDAO) GetByID(ctx context.Context, id int64) (*model.User, error) {
+	return executeSingleRowQuery(ctx, u.qe, u.scanUser,
 		selectUserByID, id)
 }
 
-func (u *UserDAO) Create(user *model.User) (*model.User, error) {
+func (u *UserDAO) GetByIDs(ctx context.Context, ids []int64) ([]*model.User, error) {
+	return executeMultiRowQuery(ctx, u.qe, u.scanUser,
+		selectUsersByIDs, int64Array(ids))
+}
+
+func (u *UserDAO) Create(ctx context.Context, user *model.User) (*model.User, error) {
 	if user == nil {
 		return nil, &DAOError{Query: insertUser, Message: "Nil User"}
 	}
 
-	return executeInTransaction(u.dao.db,
-		func(tx *sql.Tx) (*model.User, error) {
+	return executeInTransactionOf(ctx, u.dao.db, u.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.User, error) {
 			if (user.Address != model.Address{}) {
 				addressTx := newAddressDAO(tx)
-				address, err := addressTx.CreateAddress(&user.Address)
+				address, err := addressTx.CreateAddress(ctx, &user.Address)
 				if err != nil {
 					return nil, err
 				}
 				user.Address = *address
 			}
 
-			return executeSingleRowQuery(tx, propertyScanner(user, &user.ID, &user.CreatedAt),
+			return executeSingleRowQuery(ctx, tx, propertyScanner(user, &user.ID, &user.CreatedAt, &user.Version),
 				insertUser, user.Name, user.FirstName, user.LastName, user.PictureURL, user.Email, user.Address.ID)
 		})
 }
 
-func (u *UserDAO) Update(user *model.User) (*model.User, error) {
+// Update changes the profile and address of a user. Null profile fields keep
+// their stored values and the email, which comes from the identity provider,
+// cannot be changed.
+func (u *UserDAO) Update(ctx context.Context, user *model.User) (*model.User, error) {
 	if user == nil {
-		return nil, &DAOError{Query: updateUsersAddress, Message: "Nil User"}
+		return nil, &DAOError{Query: updateUser, Message: "Nil User"}
 	}
 
-	return executeInTransaction(u.dao.db,
-		func(tx *sql.Tx) (*model.User, error) {
+	return executeInTransaction(ctx, u.dao.db, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.User, error) {
+			before, err := newUserDAO(tx).GetByID(ctx, user.ID.Int64)
+			if err != nil {
+				return nil, versionError(err, user.Version)
+			}
+
 			addressTx := newAddressDAO(tx)
-			address, err := addressTx.CreateAddress(&user.Address)
+			address, err := addressTx.CreateAddress(ctx, &user.Address)
 			if err != nil {
 				return nil, err
 			}
 
-			err = executeNoRowsQuery(tx, updateUsersAddress, address.ID, user.ID)
+			_, err = executeSingleRowQuery(ctx, tx, propertyScanner(user, &user.Name, &user.FirstName, &user.LastName, &user.PictureURL, &user.Email, &user.CreatedAt, &user.Version, &user.DeletionScheduledAt),
+				updateUser, user.Name, user.FirstName, user.LastName, user.PictureURL, address.ID, user.ID, user.Version)
 			if err != nil {
-				return nil, err
+				return nil, versionError(err, user.Version)
 			}
 			user.Address = *address
 
-			return user, nil
+			return user, recordAudit(ctx, tx, model.AuditUserUpdate, auditUser, user.ID.Int64, before, user)
 		})
 }
 
-func (u *UserDAO) Delete(id int64) error {
-	return executeNoRowsQuery(u.dao.db, deleteUser, id)
+// ScheduleDeletion deletes a user at the given time, unless it is canceled
+// before. It fails with sql.ErrNoRows if the user does not exist.
+func (u *UserDAO) ScheduleDeletion(ctx context.Context, id int64, at time.Time) error {
+	_, err := executeSingleRowQuery(ctx, u.qe, scanUserID, scheduleUserDeletion, id, at.UTC())
+	return err
+}
+
+// CancelDeletion keeps a user scheduled for deletion. It fails with
+// sql.ErrNoRows if no deletion is scheduled.
+func (u *UserDAO) CancelDeletion(ctx context.Context, id int64) error {
+	_, err := executeSingleRowQuery(ctx, u.qe, scanUserID, cancelUserDeletion, id)
+	return err
+}
+
+// GetDueForDeletion returns the IDs of the users to delete at now.
+func (u *UserDAO) GetDueForDeletion(ctx context.Context, now time.Time) ([]int64, error) {
+	return executeMultiRowQuery(ctx, u.qe, scanUserID, selectUsersDueForDeletion, now.UTC())
+}
+
+// Delete anonymises a user. Orders, invoices and ratings are kept for the
+// books, while the profile, comments, logins and tokens are deleted and the
+// products are taken off sale. It fails with sql.ErrNoRows if the user does
+// not exist or is deleted already. The audit log records the anonymised user
+// and forgets the IP addresses of the user, it keeps no other personal data.
+func (u *UserDAO) Delete(ctx context.Context, id int64) error {
+	_, err := executeInTransactionOf(ctx, u.dao.db, u.qe, defaultTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.User, error) {
+			if _, err := executeSingleRowQuery(ctx, tx, scanUserID, anonymizeUser, id, time.Now().UTC()); err != nil {
+				return nil, err
+			}
+			for _, query := range deleteUserData {
+				if err := executeNoRowsQuery(ctx, tx, query, id); err != nil {
+					return nil, err
+				}
+			}
+			if err := executeNoRowsQuery(ctx, tx, withdrawUserProducts, id); err != nil {
+				return nil, err
+			}
+
+			user, err := newUserDAO(tx).GetByID(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if err := recordAudit(ctx, tx, model.AuditUserDelete, auditUser, id, nil, user); err != nil {
+				return nil, err
+			}
+			return nil, executeNoRowsQuery(ctx, tx, eraseAuditActorIPAddresses, id)
+		})
+	return err
+}
+
+// Export collects the data of a user, as seen by a single snapshot of the
+// database.
+func (u *UserDAO) Export(ctx context.Context, id int64) (*model.UserExport, error) {
+	return executeInTransaction(ctx, u.dao.db, readOnlyTxOptions,
+		func(ctx context.Context, tx *sql.Tx) (*model.UserExport, error) {
+			export := &model.UserExport{ExportedAt: time.Now().UTC()}
+
+			var err error
+			if export.Profile, err = newUserDAO(tx).GetByID(ctx, id); err != nil {
+				return nil, err
+			}
+
+			orderTx := newOrderDAO(tx)
+			if export.Orders, err = orderTx.GetByUserID(ctx, id); err != nil {
+				return nil, err
+			}
+			for _, order := range export.Orders {
+				if _, err := orderTx.LoadItems(ctx, order); err != nil {
+					return nil, err
+				}
+			}
+
+			if export.Invoices, err = newInvoiceDAO(tx).GetByUserID(ctx, id); err != nil {
+				return nil, err
+			}
+			if export.Comments, err = newCommentDAO(tx).GetByUserID(ctx, id); err != nil {
+				return nil, err
+			}
+			if export.Ratings, err = newProductDAO(tx).GetRatingsByUserID(ctx, id); err != nil {
+				return nil, err
+			}
+
+			export.Addresses = userAddresses(export)
+			return export, nil
+		})
+}
+
+// userAddresses returns the distinct addresses of the profile and the orders
+// of a user.
+func userAddresses(export *model.UserExport) []*model.Address {
+	addresses := []*model.Address{}
+	seen := map[int64]bool{}
+	add := func(address *model.Address) {
+		if address.ID.Valid && !seen[address.ID.Int64] {
+			seen[address.ID.Int64] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	add(&export.Profile.Address)
+	for _, order := range export.Orders {
+		add(&order.Address)
+	}
+	return addresses
 }
 
 func (u *UserDAO) scanUser(row rowScanner) (*model.User, error) {
 	var user model.User
-	return propertyScanner(&user, &user.ID, &user.Name, &user.FirstName, &user.LastName, &user.PictureURL, &user.Email, &user.CreatedAt, &user.Address.ID, &user.Address.City, &user.Address.Country, &user.Address.Address, &user.Address.PostalCode)(row)
+	return propertyScanner(&user, &user.ID, &user.Name, &user.FirstName, &user.LastName, &user.PictureURL, &user.Email, &user.CreatedAt, &user.Version, &user.DeletionScheduledAt, &user.Address.ID, &user.Address.City, &user.Address.Country, &user.Address.Address, &user.Address.PostalCode)(row)
 }
@@ -1,9 +1,184 @@
 package dao
 
 import (
+	"database/sql"
+	"errors"
+	"slices"
 	"testing"
+	"time"
+
+	"github.com/vladoiliev02/online-store/model"
 )
 
 func TestUserDAO_GetByID(t *testing.T) {
-	t.Cleanup(func() {})
+	ctx := testContext(t)
+	created := createTestUser(t, "ivan")
+
+	user, err := NewUserDAO().GetByID(ctx, created.ID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email.String != "ivan@example.com" || user.Version != 1 || !user.CreatedAt.Valid {
+		t.Errorf("unexpected user %+v", user)
+	}
+
+	byEmail, err := NewUserDAO().GetByEmail(ctx, "ivan@example.com")
+	if err != nil || byEmail.ID != created.ID {
+		t.Errorf("expected the user by email, got %+v, %v", byEmail, err)
+	}
+}
+
+func TestUserDAO_EmailsAreUnique(t *testing.T) {
+	createTestUser(t, "unique")
+
+	_, err := NewUserDAO().Create(testContext(t), &model.User{
+		Name:       model.NullStringJSON{String: "Copy", Valid: true},
+		FirstName:  model.NullStringJSON{String: "Copy", Valid: true},
+		LastName:   model.NullStringJSON{String: "Cat", Valid: true},
+		PictureURL: model.NullStringJSON{String: "", Valid: true},
+		Email:      model.NullStringJSON{String: "Unique@Example.com", Valid: true},
+	})
+	if err == nil {
+		t.Error("expected a second user with the email to be refused")
+	}
+}
+
+func TestUserDAO_GetByIDs(t *testing.T) {
+	first, second := createTestUser(t, "maria"), createTestUser(t, "georgi")
+
+	users, err := NewUserDAO().GetByIDs(testContext(t), []int64{first.ID.Int64, second.ID.Int64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Errorf("expected 2 users, got %d", len(users))
+	}
+}
+
+func TestUserDAO_UpdateChecksVersion(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "elena")
+
+	user.FirstName = model.NullStringJSON{String: "Elena", Valid: true}
+	user.Address = model.Address{
+		City:       model.NullStringJSON{String: "Sofia", Valid: true},
+		Country:    model.NullStringJSON{String: "Bulgaria", Valid: true},
+		Address:    model.NullStringJSON{String: "1 Vitosha Blvd", Valid: true},
+		PostalCode: model.NullStringJSON{String: "1000", Valid: true},
+	}
+	updated, err := NewUserDAO().Update(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 2 || !updated.Address.ID.Valid {
+		t.Errorf("expected version 2 with an address, got %+v", updated)
+	}
+
+	updated.Version = 1
+	if _, err := NewUserDAO().Update(ctx, updated); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expected a version mismatch, got %v", err)
+	}
+}
+
+func TestUserDAO_DeleteAnonymises(t *testing.T) {
+	ctx := testContext(t)
+	seller, buyer := createTestUser(t, "deleted-seller"), createTestUser(t, "deleted-buyer")
+	sold := createTestProduct(t, buyer.ID.Int64, "Product of a deleted seller", model.Home, 1)
+	product := createTestProduct(t, seller.ID.Int64, "Bought by a deleted buyer", model.Home, 5)
+	order := checkoutTestOrder(t, buyer.ID.Int64, product.ID.Int64, 1)
+
+	if _, err := NewCommentDAO().Create(ctx, &model.Comment{
+		User:      *buyer,
+		ProductID: product.ID,
+		Comment:   model.NullStringJSON{String: "Personal opinion", Valid: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rating := &model.Rating{UserID: buyer.ID, ProductID: product.ID, Rating: model.NullInt64JSON{Int64: 5, Valid: true}}
+	if _, err := NewProductDAO().AddRating(ctx, rating); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewUserDAO().Delete(ctx, buyer.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := NewUserDAO().GetByID(ctx, buyer.ID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Email.String != "" || deleted.FirstName.String == buyer.FirstName.String || deleted.Address.ID.Valid {
+		t.Errorf("expected the user to be anonymised, got %+v", deleted)
+	}
+
+	if invoices, err := NewInvoiceDAO().GetByUserID(ctx, buyer.ID.Int64); err != nil || len(invoices) != 1 || invoices[0].Order.ID != order.ID {
+		t.Errorf("expected the invoice to be kept, got %v, %v", invoices, err)
+	}
+	if comments, err := NewCommentDAO().GetByUserID(ctx, buyer.ID.Int64); err != nil || len(comments) != 0 {
+		t.Errorf("expected the comments to be deleted, got %v, %v", comments, err)
+	}
+	if ratings, err := NewProductDAO().GetRatingsByUserID(ctx, buyer.ID.Int64); err != nil || len(ratings) != 1 {
+		t.Errorf("expected the rating to be kept, got %v, %v", ratings, err)
+	}
+	if withdrawn, err := NewProductDAO().GetByID(ctx, sold.ID.Int64); err != nil || withdrawn.Available.Bool {
+		t.Errorf("expected the products of the user to be taken off sale, got %v, %v", withdrawn, err)
+	}
+
+	if err := NewUserDAO().Delete(ctx, buyer.ID.Int64); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a deleted user not to be deleted again, got %v", err)
+	}
+}
+
+func TestUserDAO_ScheduleDeletion(t *testing.T) {
+	ctx := testContext(t)
+	user := createTestUser(t, "leaving")
+	now := time.Now().UTC()
+
+	if err := NewUserDAO().ScheduleDeletion(ctx, user.ID.Int64, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if scheduled, _ := NewUserDAO().GetByID(ctx, user.ID.Int64); !scheduled.DeletionScheduledAt.Valid {
+		t.Error("expected the deletion to be scheduled")
+	}
+
+	if due, err := NewUserDAO().GetDueForDeletion(ctx, now); err != nil || slices.Contains(due, user.ID.Int64) {
+		t.Errorf("expected the user not to be due before the grace period, got %v, %v", due, err)
+	}
+	if due, err := NewUserDAO().GetDueForDeletion(ctx, now.Add(2*time.Hour)); err != nil || !slices.Contains(due, user.ID.Int64) {
+		t.Errorf("expected the user to be due after the grace period, got %v, %v", due, err)
+	}
+
+	if err := NewUserDAO().CancelDeletion(ctx, user.ID.Int64); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewUserDAO().CancelDeletion(ctx, user.ID.Int64); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected nothing to cancel, got %v", err)
+	}
+	if due, _ := NewUserDAO().GetDueForDeletion(ctx, now.Add(2*time.Hour)); slices.Contains(due, user.ID.Int64) {
+		t.Error("expected a canceled deletion not to be due")
+	}
+}
+
+func TestUserDAO_Export(t *testing.T) {
+	ctx := testContext(t)
+	seller, buyer := createTestUser(t, "export-seller"), createTestUser(t, "exporter")
+	product := createTestProduct(t, seller.ID.Int64, "Exported product", model.Books, 5)
+	checkoutTestOrder(t, buyer.ID.Int64, product.ID.Int64, 2)
+
+	export, err := NewUserDAO().Export(ctx, buyer.ID.Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Profile.ID != buyer.ID || len(export.Invoices) != 1 || len(export.Addresses) != 1 {
+		t.Errorf("expected the profile, one invoice and its address, got %+v", export)
+	}
+	// The checked out order and the new cart.
+	if len(export.Orders) != 2 {
+		t.Fatalf("expected 2 orders, got %d", len(export.Orders))
+	}
+	for _, order := range export.Orders {
+		if order.Status == model.InProgress && len(order.Products) != 1 {
+			t.Errorf("expected the items of the order, got %v", order.Products)
+		}
+	}
 }
@@ -13,6 +13,8 @@ func Init(router chi.Router) {
 	router.Get("/store/users/{userId}", serveFile("static/user.html"))
 	router.Get("/store/orders/{orderId}", serveFile("static/order.html"))
 	router.Get("/store/login", serveFile("static/login.html"))
+	router.Get("/store/reset-password", serveFile("static/reset-password.html"))
+	router.Get("/store/two-factor", serveFile("static/two-factor.html"))
 	router.Mount("/store", http.StripPrefix("/store/", fs))
 }
 
@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Options struct {
+	// Level is one of debug, info, warn or error. Defaults to info.
+	Level string
+	// Format is either json or text. Defaults to text.
+	Format string
+	Output io.Writer
+}
+
+type ctxKey struct{}
+
+// requestAttrs holds the attributes of the request being served. It is shared
+// by all handlers of the request, so that attributes added by inner handlers,
+// like the user ID, are also logged by the outer ones.
+type requestAttrs struct {
+	mu    sync.Mutex
+	attrs []any
+}
+
+// Init configures the default slog logger, which also receives the output of
+// the standard log package.
+func Init(options *Options) error {
+	var level slog.Level
+	if options.Level != "" {
+		if err := level.UnmarshalText([]byte(options.Level)); err != nil {
+			return fmt.Errorf("invalid log level %q: %w", options.Level, err)
+		}
+	}
+
+	output := options.Output
+	if output == nil {
+		output = os.Stderr
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	var handler slog.Handler
+	switch strings.ToLower(options.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(output, handlerOptions)
+	case "json":
+		handler = slog.NewJSONHandler(output, handlerOptions)
+	default:
+		return fmt.Errorf("invalid log format %q", options.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// FromContext returns the default logger with the attributes of the current
+// request, if any.
+func FromContext(ctx context.Context) *slog.Logger {
+	request, ok := ctx.Value(ctxKey{}).(*requestAttrs)
+	if !ok {
+		return slog.Default()
+	}
+
+	request.mu.Lock()
+	defer request.mu.Unlock()
+	return slog.Default().With(request.attrs...)
+}
+
+// AddAttrs adds attributes to all following log records of the current request.
+func AddAttrs(ctx context.Context, attrs ...any) {
+	if request, ok := ctx.Value(ctxKey{}).(*requestAttrs); ok {
+		request.mu.Lock()
+		request.attrs = append(request.attrs, attrs...)
+		request.mu.Unlock()
+	}
+}
+
+// Middleware logs every request once it is served. It has to be installed
+// after middleware.RequestID and, for logs to carry the trace ID, after the
+// tracing middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		request := &requestAttrs{attrs: []any{"request_id", middleware.GetReqID(r.Context())}}
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.HasTraceID() {
+			request.attrs = append(request.attrs, "trace_id", spanContext.TraceID().String())
+		}
+		ctx := context.WithValue(r.Context(), ctxKey{}, request)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			attrs := []any{
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"latency", time.Since(start),
+			}
+			if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+				attrs = append(attrs, "route", routeContext.RoutePattern())
+			}
+
+			level := slog.LevelInfo
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			}
+
+			FromContext(ctx).Log(ctx, level, "request served", attrs...)
+		}()
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestRedact(t *testing.T) {
+	var output bytes.Buffer
+	if err := Init(&Options{Format: "json", Output: &output}); err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Info("login of john@example.com",
+		"access_token", "abc",
+		"userEmail", "john@example.com",
+		"header", "Bearer abc.def",
+		"error", errors.New("no user jane@example.com"),
+		"api_token_id", 42,
+		"sessionID", 7,
+	)
+
+	logged := output.String()
+	for _, secret := range []string{"abc", "example.com"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("%q was logged: %s", secret, logged)
+		}
+	}
+	for _, id := range []string{`"api_token_id":42`, `"sessionID":7`} {
+		if !strings.Contains(logged, id) {
+			t.Errorf("expected %s to be logged: %s", id, logged)
+		}
+	}
+}
+
+func TestInit_InvalidOptions(t *testing.T) {
+	if err := Init(&Options{Level: "verbose"}); err == nil {
+		t.Errorf("expected an invalid level error")
+	}
+	if err := Init(&Options{Format: "xml"}); err == nil {
+		t.Errorf("expected an invalid format error")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var output bytes.Buffer
+	if err := Init(&Options{Format: "json", Output: &output}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(Middleware)
+	r.Get("/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+		AddAttrs(r.Context(), "user_id", int64(7))
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/products/1", nil))
+
+	var record map[string]any
+	if err := json.Unmarshal(output.Bytes(), &record); err != nil {
+		t.Fatalf("invalid log record %q: %v", output.String(), err)
+	}
+
+	if record["level"] != "WARN" || record["status"] != float64(http.StatusNotFound) || record["route"] != "/products/{id}" ||
+		record["user_id"] != float64(7) || record["request_id"] == "" {
+		t.Errorf("unexpected log record %v", record)
+	}
+}
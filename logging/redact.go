@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	// Attributes whose key contains one of these are never logged, unless
+	// they are identifiers such as api_token_id.
+	sensitiveKeys = []string{"token", "secret", "password", "authorization", "cookie", "session", "email"}
+
+	emailRegex  = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+	bearerRegex = regexp.MustCompile(`(?i)\b(bearer|token)\s+[a-zA-Z0-9._~+/=-]+`)
+)
+
+// redact is a slog.HandlerOptions.ReplaceAttr function, which hides the values
+// of sensitive attributes and scrubs emails and tokens from all strings and errors.
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) && !isIdentifierKey(attr.Key) {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Scrub(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, Scrub(err.Error()))
+		}
+	}
+
+	return attr
+}
+
+// isIdentifierKey tells if key names the id of something, e.g. api_token_id
+// or sessionId, which is logged even if the thing is sensitive.
+func isIdentifierKey(key string) bool {
+	return strings.HasSuffix(strings.ToLower(key), "_id") || strings.HasSuffix(key, "Id") || strings.HasSuffix(key, "ID")
+}
+
+// Scrub replaces emails and bearer tokens in s.
+func Scrub(s string) string {
+	s = emailRegex.ReplaceAllString(s, redacted)
+	return bearerRegex.ReplaceAllString(s, "$1 "+redacted)
+}
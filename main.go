@@ -1,92 +1,428 @@
 package main
 
 import (
-	"log"
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/vladoiliev02/online-store/config"
 	"github.com/vladoiliev02/online-store/controller"
 	"github.com/vladoiliev02/online-store/controller/security"
 	"github.com/vladoiliev02/online-store/dao"
 	"github.com/vladoiliev02/online-store/frontend"
+	"github.com/vladoiliev02/online-store/logging"
+	"github.com/vladoiliev02/online-store/metrics"
+	"github.com/vladoiliev02/online-store/model"
+	"github.com/vladoiliev02/online-store/ratelimit"
+	"github.com/vladoiliev02/online-store/rpc"
+	"github.com/vladoiliev02/online-store/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"google.golang.org/grpc"
 )
 
+// migrationScripts are the Flyway migrations the readiness probe expects to
+// be applied.
+//
+//go:embed sql/*.sql
+var migrationScripts embed.FS
+
 var (
-	port   string
-	host   string
-	router chi.Router
+	cfg        *config.Config
+	router     chi.Router
+	grpcServer *grpc.Server
+
+	metricsServer *http.Server
+
+	// stopCleanup stops deleting expired sessions, rate limits and accounts
+	// due for deletion once the servers stop.
+	stopCleanup = make(chan struct{})
+
+	shutdownTracing func(context.Context) error
 )
 
-func init() {
+func main() {
+	loadConfig()
+	initLogging()
+	initTracing()
 	initDb()
 	initServer()
+	initGRPCServer()
+
+	server := newHTTPServer(cfg.Server.Port, router)
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	server.BaseContext = func(net.Listener) context.Context { return requestsCtx }
+
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+
+	slog.Info("Welcome to the store", "port", cfg.Server.Port)
+
+	if grpcServer != nil {
+		go serveGRPC()
+	}
+
+	if metricsServer != nil {
+		go serveMetrics()
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("HTTP server stopped", "error", err)
+		exitCode = 1
+	case <-signalCtx.Done():
+		slog.Info("Shutting down")
+		stopSignals()
+		drain(server, cancelRequests)
+	}
+
+	closeResources()
+	os.Exit(exitCode)
 }
 
-func main() {
-	log.Println("Welcome to the store")
-	http.ListenAndServe(":"+port, router)
+// newHTTPServer returns a server with timeouts, so that slow or idle clients
+// cannot hold connections open indefinitely.
+func newHTTPServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + strconv.Itoa(port),
+		Handler:           handler,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// drain fails the readiness probe and keeps serving for the shutdown delay, so
+// that load balancers stop sending new requests. It then stops accepting
+// connections and waits for in-flight requests until the shutdown timeout,
+// after which the remaining requests are canceled.
+func drain(server *http.Server, cancelRequests context.CancelFunc) {
+	controller.SetShuttingDown()
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("In-flight requests did not finish in time", "error", err)
+		cancelRequests()
+		server.Close()
+	}
+
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
+	}
+}
+
+// closeResources closes the database and flushes the pending spans. It runs
+// after the servers stop, so that no request is cut off mid-transaction.
+func closeResources() {
+	close(stopCleanup)
+	if err := dao.Close(); err != nil {
+		slog.Error("Cannot close the database", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Cannot flush traces", "error", err)
+	}
+
+	slog.Info("Store stopped")
+}
+
+// loadConfig reads the configuration from the config file, the environment and
+// the command line. With --print-config it prints the result and exits.
+func loadConfig() {
+	result, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if result == nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if result.PrintConfig {
+		if err := result.Config.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var validationErrors model.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		for _, validationError := range validationErrors {
+			fmt.Fprintln(os.Stderr, "  "+validationError.Error())
+		}
+		os.Exit(1)
+	}
+
+	if result.PrintConfig {
+		os.Exit(0)
+	}
+
+	cfg = result.Config
+}
+
+func initLogging() {
+	err := logging.Init(&logging.Options{
+		Level:  cfg.Logging.Level,
+		Format: cfg.Logging.Format,
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+func initTracing() {
+	var err error
+	shutdownTracing, err = tracing.Init(context.Background(), &tracing.Options{
+		Exporter: cfg.Tracing.Exporter,
+	})
+	if err != nil {
+		panic(err.Error())
+	}
 }
 
 func initDb() {
-	driverName := getEnvVar("DB_DRIVER_NAME")
-	connectionString := getEnvVar("DB_CONNECTION_STRING")
+	// SQLite databases are migrated by the DAO with its own copy of the scripts.
+	var migrations fs.FS
+	if cfg.Database.Driver == "postgres" {
+		var err error
+		migrations, err = fs.Sub(migrationScripts, "sql")
+		if err != nil {
+			panic(err.Error())
+		}
+	}
 
 	dbOptions := dao.DBOptions{
-		DriverName: driverName,
-		ConnStr:    connectionString,
+		DriverName:      cfg.Database.Driver,
+		ConnStr:         cfg.Database.ConnectionString,
+		MinPageSize:     cfg.Pagination.MinPageSize,
+		MaxPageSize:     cfg.Pagination.MaxPageSize,
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.Database.ConnMaxIdleTime,
+		Migrations:      migrations,
+		HealthCacheTTL:  cfg.Database.HealthCacheTTL,
+
+		ReplicaConnStrs:      cfg.Database.Replicas,
+		ReplicaCheckInterval: cfg.Database.ReplicaCheckInterval,
 	}
 
 	dao.Init(&dbOptions)
-}
 
-func initServer() {
-	var exists bool
-	port, exists = os.LookupEnv("PORT")
-	if !exists {
-		port = "8080"
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Database.ConnectTimeout)
+	defer cancel()
+	if err := dao.Connect(ctx); err != nil {
+		slog.Error("Giving up on the database", "error", err)
+		os.Exit(1)
 	}
+}
 
-	host = getEnvVar("HOST")
-	sessionStoreKey := os.Getenv("SESSION_STORE_KEY")
-	clientID := getEnvVar("CLIENT_ID")
-	clientSecret := getEnvVar("CLIENT_SECRET")
+func initServer() {
 	oauthConfig := &security.OAuthConfiguration{
-		Config: oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			RedirectURL:  host + "/oauth/code",
-			Scopes:       []string{"openid", "profile", "email"},
-			Endpoint:     google.Endpoint,
-		},
-		UserEndpoint: "https://www.googleapis.com/oauth2/v3/userinfo",
-		LogoutPath:   "/logout",
-		HomePath:     "/store/",
-	}
-	securityConfig := security.NewSecurityConfiguration(router, oauthConfig, sessionStoreKey)
+		RedirectURL: cfg.Server.Host + "/oauth/code",
+		Providers:   identityProviders(),
+		LogoutPath:  "/logout",
+		HomePath:    "/store/",
+	}
+	sessionStore := newSessionStore()
+	rateLimiter := newRateLimiter()
+	accountConfig := &security.AccountConfiguration{
+		BaseURL:         cfg.Server.Host,
+		Mailer:          newMailer(),
+		MaxFailedLogins: cfg.Accounts.MaxFailedLogins,
+		LockoutDuration: cfg.Accounts.LockoutDuration,
+		VerificationTTL: cfg.Accounts.VerificationTTL,
+		ResetTTL:        cfg.Accounts.ResetTTL,
+		RateLimiter:     rateLimiter,
+		LoginRateLimit:  ratelimit.PerMinute(cfg.RateLimits.Login),
+	}
+	securityConfig := security.NewSecurityConfiguration(router, oauthConfig, accountConfig, sessionStore)
+	controller.SetSessionManager(sessionStore)
+	controller.SetAdmins(cfg.Server.Admins)
+	controller.SetDeletionGracePeriod(cfg.Accounts.DeletionGracePeriod)
+	go controller.DeleteDueAccountsEvery(time.Hour, stopCleanup)
+	controller.SetRateLimiter(rateLimiter, controller.RateLimits{
+		API:    ratelimit.PerMinute(cfg.RateLimits.API),
+		Search: ratelimit.PerMinute(cfg.RateLimits.Search),
+		Write:  ratelimit.PerMinute(cfg.RateLimits.Write),
+	})
 
 	router = chi.NewMux()
 
 	router.Use(middleware.RequestID)
 	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(tracing.Middleware)
+	router.Use(logging.Middleware)
+	router.Use(metrics.Middleware)
 	router.Use(middleware.Recoverer)
+	router.Use(controller.RequestTimeout(cfg.Server.RequestTimeout))
+	if len(cfg.Database.Replicas) > 0 {
+		router.Use(controller.ReadYourWrites(cfg.Database.ReadYourWritesWindow))
+	}
 
 	securityConfig.ConfigureRouter(router)
 
 	frontend.Init(router)
 	router.Mount("/api/v1", controller.Router())
+
+	initMetrics()
+}
+
+func identityProviders() []*security.ProviderConfig {
+	var providers []*security.ProviderConfig
+	for _, provider := range cfg.IdentityProviders() {
+		providers = append(providers, &security.ProviderConfig{
+			Name:         provider.Name,
+			DisplayName:  provider.DisplayName,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			AuthURL:      provider.AuthURL,
+			TokenURL:     provider.TokenURL,
+			UserInfoURL:  provider.UserInfoURL,
+			Scopes:       provider.Scopes,
+			TrustEmail:   provider.TrustEmail,
+			Claims: security.ClaimNames{
+				Subject:       provider.Claims.Subject,
+				Email:         provider.Claims.Email,
+				EmailVerified: provider.Claims.EmailVerified,
+				Name:          provider.Claims.Name,
+				FirstName:     provider.Claims.FirstName,
+				LastName:      provider.Claims.LastName,
+				Picture:       provider.Claims.Picture,
+			},
+		})
+	}
+	return providers
+}
+
+// newMailer sends emails through the configured SMTP server, or logs them
+// if there is none.
+func newMailer() security.Mailer {
+	if cfg.Mail.SMTPAddr == "" {
+		return security.LogMailer{}
+	}
+	return security.NewSMTPMailer(cfg.Mail.SMTPAddr, cfg.Mail.From, cfg.Mail.Username, cfg.Mail.Password)
+}
+
+// newSessionStore keeps the sessions in the database unless the memory store is
+// configured, and deletes the expired ones every hour.
+func newSessionStore() *security.SessionStore {
+	var backend security.SessionBackend = dao.NewSessionDAO()
+	if cfg.Sessions.Store == "memory" {
+		backend = security.NewMemorySessionBackend()
+	}
+
+	store := security.NewSessionStore(backend, &security.SessionOptions{
+		Keys:         append([]string{cfg.Server.SessionKey}, cfg.Sessions.PreviousKeys...),
+		MaxAge:       cfg.Sessions.MaxAge,
+		IdleTimeout:  cfg.Sessions.IdleTimeout,
+		SecureCookie: cfg.Sessions.SecureCookie,
+	})
+	go store.DeleteExpiredEvery(time.Hour, stopCleanup)
+
+	return store
+}
+
+// newRateLimiter counts the requests in memory unless the database is
+// configured, which the replicas share, and deletes the full buckets every
+// minute.
+func newRateLimiter() *ratelimit.Limiter {
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimits.Store == "database" {
+		store = dao.NewRateLimitDAO()
+	}
+
+	limiter := ratelimit.NewLimiter(store)
+	go limiter.DeleteExpiredEvery(time.Minute, stopCleanup)
+
+	return limiter
 }
 
-func getEnvVar(name string) string {
-	val, exists := os.LookupEnv(name)
-	if !exists {
-		log.Panic("Provide an environment variable: " + name)
+// initMetrics exposes /metrics on a separate listener if the metrics port is
+// set, or on the main router if a metrics token is set. Otherwise metrics are
+// not served.
+func initMetrics() {
+	switch {
+	case cfg.Metrics.Port != 0:
+		metricsRouter := chi.NewMux()
+		metricsRouter.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+		metricsServer = newHTTPServer(cfg.Metrics.Port, metricsRouter)
+	case cfg.Metrics.Token != "":
+		router.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+	default:
+		slog.Info("Neither metrics port nor metrics token configured, metrics disabled")
+	}
+}
+
+// initGRPCServer configures the gRPC server for internal consumers. It is only
+// started when at least one "service:token" pair is configured.
+func initGRPCServer() {
+	serviceTokens := cfg.GRPCServiceTokens()
+	if len(serviceTokens) == 0 {
+		slog.Info("No gRPC service tokens configured, gRPC server disabled")
+		return
 	}
 
-	return val
+	grpcServer = rpc.NewServer(serviceTokens)
+}
+
+func serveGRPC() {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.GRPC.Port))
+	if err != nil {
+		panic("Cannot listen on gRPC port: " + err.Error())
+	}
+
+	slog.Info("Serving gRPC", "port", cfg.GRPC.Port)
+	if err := grpcServer.Serve(listener); err != nil {
+		slog.Error("gRPC server stopped", "error", err)
+	}
+}
+
+func serveMetrics() {
+	slog.Info("Serving metrics", "port", cfg.Metrics.Port)
+	if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Metrics server stopped", "error", err)
+	}
 }
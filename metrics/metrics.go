@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "store"
+
+// Checkout failure reasons.
+const (
+	CheckoutEmptyCart      = "empty_cart"
+	CheckoutInvalidAddress = "invalid_address"
+	CheckoutInvalidStatus  = "invalid_status"
+	CheckoutConflict       = "conflict"
+	CheckoutError          = "error"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency by DAO method.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "Failed database queries by DAO method.",
+	}, []string{"query"})
+
+	CartsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "carts_created_total",
+		Help:      "Shopping carts created.",
+	})
+
+	Checkouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "checkouts_total",
+		Help:      "Carts checked out successfully.",
+	})
+
+	CheckoutFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "checkout_failures_total",
+		Help:      "Failed checkouts by reason.",
+	}, []string{"reason"})
+
+	RatingsSubmitted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ratings_submitted_total",
+		Help:      "Product ratings submitted or changed.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, queryDuration, queryErrors,
+		CartsCreated, Checkouts, CheckoutFailures, RatingsSubmitted,
+	)
+}
+
+// RegisterDB exposes the connection pool statistics of db, labeled with name.
+func RegisterDB(name string, db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveQuery records the duration and outcome of a database query.
+func ObserveQuery(name string, duration time.Duration, failed bool) {
+	queryDuration.WithLabelValues(name).Observe(duration.Seconds())
+	if failed {
+		queryErrors.WithLabelValues(name).Inc()
+	}
+}
+
+// Handler serves the metrics in the Prometheus exposition format. If token is
+// not empty, requests must present it as a bearer token.
+func Handler(token string) http.Handler {
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return handler
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Middleware records the count and latency of HTTP requests. Requests are
+// labeled by chi route pattern rather than path, to keep the cardinality low.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			route := "unmatched"
+			if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+				route = strings.ReplaceAll(routeContext.RoutePattern(), "/*", "")
+				if len(route) > 1 {
+					route = strings.TrimSuffix(route, "/")
+				}
+			}
+
+			httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+			httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
@@ -1,5 +1,10 @@
 package model
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type ProductCategory int
 
 const (
@@ -34,6 +39,10 @@ type User struct {
 	Email      NullStringJSON `json:"email"`
 	Address    Address        `json:"address"`
 	CreatedAt  NullStringJSON `json:"createdAt"`
+	Version    int64          `json:"version"`
+	// DeletionScheduledAt is when the account is deleted, null unless the
+	// user asked for it.
+	DeletionScheduledAt NullStringJSON `json:"deletionScheduledAt"`
 }
 
 type Product struct {
@@ -50,6 +59,7 @@ type Product struct {
 	Ratings      []*Rating       `json:"-"`
 	CreatedAt    NullStringJSON  `json:"createdAt"`
 	UserID       NullInt64JSON   `json:"userId"`
+	Version      int64           `json:"version"`
 }
 
 type Image struct {
@@ -75,6 +85,7 @@ type Order struct {
 	Address      Address        `json:"address"`
 	CreatedAt    NullStringJSON `json:"createdAt"`
 	LatestUpdate NullStringJSON `json:"latestUpdate"`
+	Version      int64          `json:"version"`
 }
 
 type Invoice struct {
@@ -107,6 +118,142 @@ type Rating struct {
 	Rating    NullInt64JSON `json:"rating"`
 }
 
+// Identity links an account at an identity provider, named by its subject,
+// to a user. A user can log in with each of their identities.
+type Identity struct {
+	ID        NullInt64JSON  `json:"id"`
+	UserID    NullInt64JSON  `json:"userId"`
+	Provider  NullStringJSON `json:"provider"`
+	Subject   NullStringJSON `json:"subject"`
+	Email     NullStringJSON `json:"email"`
+	CreatedAt NullStringJSON `json:"createdAt"`
+}
+
+// Credential is the password of a user with a local account. The user can
+// only log in with it once their email is verified.
+type Credential struct {
+	UserID         NullInt64JSON `json:"userId"`
+	PasswordHash   string        `json:"-"`
+	EmailVerified  bool          `json:"emailVerified"`
+	FailedAttempts int           `json:"failedAttempts"`
+	LockedUntil    *time.Time    `json:"lockedUntil"`
+}
+
+// AccountToken is a one-time token emailed to the owner of a local account,
+// of which only the hash is stored.
+type AccountToken struct {
+	ID        NullInt64JSON `json:"id"`
+	UserID    NullInt64JSON `json:"userId"`
+	Purpose   string        `json:"purpose"`
+	TokenHash string        `json:"-"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+}
+
+// TwoFactor is the TOTP authenticator of a user. It is pending until the user
+// confirms it with a code, and only then asked for when logging in.
+type TwoFactor struct {
+	UserID            NullInt64JSON  `json:"userId"`
+	Secret            string         `json:"-"`
+	Enabled           bool           `json:"enabled"`
+	LastUsedStep      int64          `json:"-"`
+	RecoveryCodesLeft int            `json:"recoveryCodesLeft"`
+	CreatedAt         NullStringJSON `json:"createdAt"`
+}
+
+// APIToken is a personal access token, with which scripts call the API on
+// behalf of a user. Only its hash is stored, the token itself is only shown
+// when it is created.
+type APIToken struct {
+	ID         NullInt64JSON  `json:"id"`
+	UserID     NullInt64JSON  `json:"userId"`
+	Name       NullStringJSON `json:"name"`
+	TokenHash  string         `json:"-"`
+	Scopes     []string       `json:"scopes"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	ExpiresAt  time.Time      `json:"expiresAt"`
+	LastUsedAt *time.Time     `json:"lastUsedAt"`
+}
+
+const (
+	// ReadScope allows an API token to read, with GET and HEAD requests.
+	ReadScope = "read"
+	// WriteScope allows an API token to make any other request.
+	WriteScope = "write"
+
+	MaxAPITokenLifetime = 365 * 24 * time.Hour
+)
+
+// UserExport is the data the store keeps about a user, which users can
+// download.
+type UserExport struct {
+	ExportedAt time.Time  `json:"exportedAt"`
+	Profile    *User      `json:"profile"`
+	Addresses  []*Address `json:"addresses"`
+	Orders     []*Order   `json:"orders"`
+	Invoices   []*Invoice `json:"invoices"`
+	Comments   []*Comment `json:"comments"`
+	Ratings    []*Rating  `json:"ratings"`
+}
+
+// AuditEntry records a privileged or financial change of an entity, made by a
+// user or by a service. Entries are never changed or deleted.
+type AuditEntry struct {
+	ID         NullInt64JSON          `json:"id"`
+	ActorID    NullInt64JSON          `json:"actorId"`
+	Service    string                 `json:"service,omitempty"`
+	Action     string                 `json:"action"`
+	EntityType string                 `json:"entityType"`
+	EntityID   int64                  `json:"entityId"`
+	Changes    map[string]AuditChange `json:"changes"`
+	RequestID  string                 `json:"requestId"`
+	IPAddress  string                 `json:"ipAddress"`
+	CreatedAt  time.Time              `json:"createdAt"`
+}
+
+// AuditChange is a field of an entity before and after a change, null where
+// the entity did not exist. Fields that identify people are Redacted, only
+// their change is recorded.
+type AuditChange struct {
+	Before   json.RawMessage `json:"before"`
+	After    json.RawMessage `json:"after"`
+	Redacted bool            `json:"redacted,omitempty"`
+}
+
+// Actions of the audit log.
+const (
+	AuditProductUpdate = "product.update"
+	AuditStockAdjust   = "product.adjust_stock"
+	AuditOrderUpdate   = "order.update"
+	AuditUserUpdate    = "user.update"
+	AuditUserDelete    = "user.delete"
+	AuditCommentDelete = "comment.delete"
+	AuditImageDelete   = "image.delete"
+)
+
+// Registration is a request for a local account.
+type Registration struct {
+	Email     NullStringJSON `json:"email"`
+	Password  NullStringJSON `json:"password"`
+	FirstName NullStringJSON `json:"firstName"`
+	LastName  NullStringJSON `json:"lastName"`
+}
+
+// Session is a browser session kept on the server. The cookie of the client
+// holds its token, of which only the hash is stored. UserID is null until the
+// client logs in.
+type Session struct {
+	ID         NullInt64JSON `json:"id"`
+	UserID     NullInt64JSON `json:"userId"`
+	TokenHash  string        `json:"-"`
+	Data       []byte        `json:"-"`
+	UserAgent  string        `json:"userAgent"`
+	IPAddress  string        `json:"ipAddress"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	LastSeenAt time.Time     `json:"lastSeenAt"`
+	ExpiresAt  time.Time     `json:"expiresAt"`
+	Current    bool          `json:"current"`
+}
+
 var (
 	ProductCategories = map[ProductCategory]string{
 		Home:          "Home",
@@ -0,0 +1,63 @@
+package model
+
+import "strings"
+
+// The Normalize* functions bring user input to its canonical form. They are
+// meant to be called before the matching Validate* function, which never
+// modifies its argument.
+
+func NormalizeProduct(product *Product) {
+	if product == nil {
+		return
+	}
+
+	trim(&product.Name)
+	trim(&product.Description)
+}
+
+func NormalizeUser(user *User) {
+	if user == nil {
+		return
+	}
+
+	trim(&user.Name)
+	trim(&user.FirstName)
+	trim(&user.LastName)
+	trim(&user.PictureURL)
+	trim(&user.Email)
+	NormalizeAddress(&user.Address)
+}
+
+func NormalizeAddress(address *Address) {
+	if address == nil {
+		return
+	}
+
+	trim(&address.City)
+	trim(&address.Country)
+	trim(&address.Address)
+	trim(&address.PostalCode)
+}
+
+func NormalizeOrder(order *Order) {
+	if order == nil {
+		return
+	}
+
+	NormalizeAddress(&order.Address)
+}
+
+func NormalizeComment(comment *Comment) {
+	if comment == nil {
+		return
+	}
+
+	trim(&comment.Comment)
+	NormalizeUser(&comment.User)
+}
+
+func trim(s *NullStringJSON) {
+	if s.Valid {
+		s.String = strings.TrimSpace(s.String)
+	}
+}
@@ -0,0 +1,219 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors holds every failed rule of a single validation run.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Rule checks a single value and returns an empty string when the value is
+// acceptable, or a message describing the problem otherwise.
+type Rule[T any] func(T) string
+
+type Presence int
+
+const (
+	Optional Presence = iota
+	Required
+	Forbidden
+)
+
+type number interface {
+	~int | ~int32 | ~int64 | ~float64
+}
+
+// Validator collects the errors of a validation run, keyed by JSON field path.
+type Validator struct {
+	path   string
+	errors *ValidationErrors
+}
+
+func NewValidator() *Validator {
+	return &Validator{errors: &ValidationErrors{}}
+}
+
+// Nested runs fn with a validator whose paths are prefixed by field.
+func (v *Validator) Nested(field string, fn func(*Validator)) {
+	fn(&Validator{path: v.fieldPath(field), errors: v.errors})
+}
+
+// Index runs fn with a validator whose paths are prefixed by field[i].
+func (v *Validator) Index(field string, i int, fn func(*Validator)) {
+	fn(&Validator{path: v.fieldPath(field) + "[" + strconv.Itoa(i) + "]", errors: v.errors})
+}
+
+func (v *Validator) AddError(field, message string) {
+	*v.errors = append(*v.errors, &ValidationError{Field: v.fieldPath(field), Message: message})
+}
+
+// Err returns the collected errors, or nil if every rule passed.
+func (v *Validator) Err() error {
+	if len(*v.errors) == 0 {
+		return nil
+	}
+	return *v.errors
+}
+
+func (v *Validator) fieldPath(field string) string {
+	if v.path == "" {
+		return field
+	}
+	if field == "" {
+		return v.path
+	}
+	return v.path + "." + field
+}
+
+// Field applies rules to value, recording every failure under field.
+func Field[T any](v *Validator, field string, value T, rules ...Rule[T]) {
+	for _, rule := range rules {
+		if message := rule(value); message != "" {
+			v.AddError(field, message)
+		}
+	}
+}
+
+// NullField checks the presence of a nullable value and, if it is set,
+// applies rules to the underlying value.
+func NullField[T any, N nullable[T]](v *Validator, field string, value N, presence Presence, rules ...Rule[T]) {
+	val, valid := value.get()
+	switch {
+	case !valid && presence == Required:
+		v.AddError(field, "is required")
+	case valid && presence == Forbidden:
+		v.AddError(field, "must not be set")
+	case valid:
+		Field(v, field, val, rules...)
+	}
+}
+
+func NotBlank() Rule[string] {
+	return func(s string) string {
+		if strings.TrimSpace(s) == "" {
+			return "cannot be empty"
+		}
+		return ""
+	}
+}
+
+func Length(min, max int) Rule[string] {
+	return func(s string) string {
+		if l := utf8.RuneCountInString(s); l < min || l > max {
+			return fmt.Sprintf("length should be between %d and %d", min, max)
+		}
+		return ""
+	}
+}
+
+func Range[T number](min, max T) Rule[T] {
+	return func(n T) string {
+		if n < min || n > max {
+			return fmt.Sprintf("should be between %v and %v", min, max)
+		}
+		return ""
+	}
+}
+
+func Positive[T number]() Rule[T] {
+	return func(n T) string {
+		if n <= 0 {
+			return "should be positive"
+		}
+		return ""
+	}
+}
+
+func Match(re *regexp.Regexp, message string) Rule[string] {
+	return func(s string) string {
+		if !re.MatchString(s) {
+			return message
+		}
+		return ""
+	}
+}
+
+func OneOf[T comparable](values ...T) Rule[T] {
+	return func(val T) string {
+		for _, v := range values {
+			if v == val {
+				return ""
+			}
+		}
+		return fmt.Sprintf("should be one of %v", values)
+	}
+}
+
+// InRange accepts enum values in the half-open interval [first, end).
+func InRange[T ~int](first, end T) Rule[T] {
+	return func(val T) string {
+		if val < first || val >= end {
+			return "is not a valid value"
+		}
+		return ""
+	}
+}
+
+// BitSet accepts non-empty combinations of the flags in mask.
+func BitSet[T ~int](mask T) Rule[T] {
+	return func(val T) string {
+		if val <= 0 || val&mask != val {
+			return "is not a valid combination of flags"
+		}
+		return ""
+	}
+}
+
+func ValidURL() Rule[string] {
+	return func(s string) string {
+		if _, err := url.Parse(s); err != nil {
+			return "should be a valid url"
+		}
+		return ""
+	}
+}
+
+type nullable[T any] interface {
+	get() (T, bool)
+}
+
+func (n NullInt64JSON) get() (int64, bool) {
+	return n.Int64, n.Valid
+}
+
+func (n NullStringJSON) get() (string, bool) {
+	return n.String, n.Valid
+}
+
+func (n NullBoolJSON) get() (bool, bool) {
+	return n.Bool, n.Valid
+}
+
+func (n NullFloat64JSON) get() (float64, bool) {
+	return n.Float64, n.Valid
+}
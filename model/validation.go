@@ -1,9 +1,8 @@
 package model
 
 import (
-	"net/url"
 	"regexp"
-	"strings"
+	"time"
 )
 
 const (
@@ -11,311 +10,187 @@ const (
 	maxProductNameLength = 255
 	maxEmailLength       = 255
 	maxUsernameLength    = 255
+	maxAddressLength     = 255
+	maxPostalCodeLength  = 10
+	maxRating            = 5
+	minPasswordLength    = 8
+	maxPasswordLength    = 128
+	maxAPITokenName      = 255
 )
 
-type ValidationError struct {
-	Message string `json:"message"`
-	Err     error  `json:"error"`
-}
-
-func (e *ValidationError) Error() string {
-	return e.Message
-}
-
-func (e *ValidationError) Unwrap() error {
-	return e.Err
-}
+var (
+	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+)
 
 func ValidatePrice(price *Price) error {
-	if price == nil {
-		return &ValidationError{"Price: is nil", nil}
-	}
-
-	if price.Units <= 0 {
-		return &ValidationError{"Price: units should be positive", nil}
-	}
-
-	if price.Currency <= 0 || price.Currency >= InvalidCurrency {
-		return &ValidationError{"Price: currency is not valid", nil}
-	}
-
-	return nil
+	return validate("price", price, validatePrice)
 }
 
 func ValidateProduct(product *Product, exists bool) error {
-	if product == nil {
-		return &ValidationError{"Product: is nil", nil}
-	}
-
-	if (exists && !product.ID.Valid) || (!exists && product.ID.Valid) {
-		return &ValidationError{"Product: invalid ID", nil}
-	}
-
-	if product.Name.Valid {
-		product.Name.String = strings.TrimSpace(product.Name.String)
-		if product.Name.String == "" || len(product.Name.String) > maxProductNameLength {
-			return &ValidationError{"Product: name cannot be empty", nil}
-		}
-	}
-
-	if product.Quantity.Int64 <= 0 {
-		return &ValidationError{"Product: quantity should be positive", nil}
-	}
-
-	if err := validateBitSet(int(product.Category), ProductCategoryMask, "product category"); err != nil {
-		return &ValidationError{"Product: invalid category", nil}
-	}
-
-	if err := ValidatePrice(&product.Price); err != nil {
-		return &ValidationError{"Product: invalid price", nil}
-	}
-
-	return nil
+	return validate("product", product, func(v *Validator, product *Product) {
+		validateID(v, product.ID, exists)
+		NullField(v, "name", product.Name, Optional, NotBlank(), Length(1, maxProductNameLength))
+		NullField(v, "quantity", product.Quantity, Required, Positive[int64]())
+		Field(v, "category", product.Category, BitSet[ProductCategory](ProductCategoryMask))
+		v.Nested("price", func(v *Validator) { validatePrice(v, &product.Price) })
+	})
 }
 
 func ValidateUser(user *User, exists bool) error {
-	if user == nil {
-		return &ValidationError{"User: is nil", nil}
-	}
-
-	if (exists && !user.ID.Valid) || (!exists && user.ID.Valid) {
-		return &ValidationError{"User: invalid ID", nil}
-	}
-
-	if user.FirstName.Valid {
-		user.FirstName.String = strings.TrimSpace(user.FirstName.String)
-		if user.FirstName.String == "" || len(user.FirstName.String) > maxUsernameLength {
-			return &ValidationError{"User: first name cannot be empty", nil}
-		}
-	}
-
-	if user.LastName.Valid {
-		user.LastName.String = strings.TrimSpace(user.LastName.String)
-		if user.LastName.String == "" || len(user.LastName.String) > maxUsernameLength {
-			return &ValidationError{"User: last name cannot be empty", nil}
-		}
-	}
-
-	if user.Name.Valid {
-		user.Name.String = strings.TrimSpace(user.Name.String)
-		if user.Name.String == "" || len(user.Name.String) > maxUsernameLength {
-			return &ValidationError{"User: name cannot be empty", nil}
-		}
-	}
-
-	if user.PictureURL.Valid {
-		if _, err := url.Parse(user.PictureURL.String); err != nil {
-			return &ValidationError{"User: picture url should be a valid url", err}
-		}
-	}
-
-	if user.Email.Valid {
-		user.Email.String = strings.TrimSpace(user.Email.String)
-		if len(user.Email.String) > maxEmailLength || !isValidEmail(user.Email.String) {
-			return &ValidationError{"User: invalid email address", nil}
-		}
-	}
-
-	if err := ValidateAddress(&user.Address); err != nil {
-		return &ValidationError{"User: invalid address", err}
-	}
-
-	return nil
+	return validate("user", user, func(v *Validator, user *User) {
+		validateUser(v, user, exists)
+	})
 }
 
 func ValidateItem(item *Item, exists bool) error {
-	if item == nil {
-		return &ValidationError{"Item: is nil", nil}
-	}
-
-	if (exists && !item.ID.Valid) || (!exists && item.ID.Valid) {
-		return &ValidationError{"Item: invalid ID", nil}
-	}
-
-	if !item.ProductID.Valid {
-		return &ValidationError{"Item: invalid product ID", nil}
-	}
-
-	if !item.OrderID.Valid {
-		return &ValidationError{"Item: invalid order ID", nil}
-	}
-
-	if !item.Quantity.Valid {
-		return &ValidationError{"Item: invalid quantity", nil}
-	}
-
-	return nil
+	return validate("item", item, func(v *Validator, item *Item) {
+		validateID(v, item.ID, exists)
+		NullField[int64](v, "productId", item.ProductID, Required)
+		NullField[int64](v, "orderId", item.OrderID, Required)
+		NullField(v, "quantity", item.Quantity, Required, Positive[int64]())
+	})
 }
 
 func ValidateImage(image *Image, exists bool) error {
-	if image == nil {
-		return &ValidationError{"Image: is nil", nil}
-	}
-
-	if (exists && !image.ID.Valid) || (!exists && image.ID.Valid) {
-		return &ValidationError{"Image: invalid ID", nil}
-	}
-
-	if !image.ProductID.Valid {
-		return &ValidationError{"Image: invalid product ID", nil}
-	}
-
-	if image.Data == "" || len(image.Data) == 0 {
-		return &ValidationError{"Image: invalid data", nil}
-	}
-
-	return nil
+	return validate("image", image, func(v *Validator, image *Image) {
+		validateID(v, image.ID, exists)
+		NullField[int64](v, "productId", image.ProductID, Required)
+		Field(v, "data", image.Data, NotBlank())
+	})
 }
 
 func ValidateOrder(order *Order, exists bool) error {
-	if order == nil {
-		return &ValidationError{"Order: is nil", nil}
-	}
-
-	if (exists && !order.ID.Valid) || (!exists && order.ID.Valid) {
-		return &ValidationError{"Order: invalid ID", nil}
-	}
-
-	if !order.UserID.Valid {
-		return &ValidationError{"Order: invalid user ID", nil}
-	}
-
-	if !IsValidOrderStatus(order.Status) {
-		return &ValidationError{"Order: invalid status", nil}
-	}
-
-	if err := ValidateAddress(&order.Address); err != nil {
-		return &ValidationError{"Order: invalid address", err}
-	}
-
-	return nil
+	return validate("order", order, func(v *Validator, order *Order) {
+		validateOrder(v, order, exists)
+	})
 }
 
 func IsValidOrderStatus(status OrderStatus) bool {
-	return !(status <= 0 || status >= InvalidOrderStatus)
+	return InRange(InCart, InvalidOrderStatus)(status) == ""
 }
 
 func ValidateInvoice(invoice *Invoice, exists bool) error {
-	if invoice == nil {
-		return &ValidationError{"Invoice: is nil", nil}
-	}
-
-	if (exists && !invoice.ID.Valid) || (!exists && invoice.ID.Valid) {
-		return &ValidationError{"Invoice: invalid ID", nil}
-	}
-
-	if !invoice.UserID.Valid {
-		return &ValidationError{"Invoice: invalid user ID", nil}
-	}
-
-	if err := ValidateOrder(&invoice.Order, exists); err != nil {
-		return &ValidationError{"Invoice: invalid order", err}
-	}
-
-	if err := ValidatePrice(&invoice.TotalPrice); err != nil {
-		return &ValidationError{"Invoice: invalid total price", err}
-	}
-
-	return nil
+	return validate("invoice", invoice, func(v *Validator, invoice *Invoice) {
+		validateID(v, invoice.ID, exists)
+		NullField[int64](v, "userId", invoice.UserID, Required)
+		v.Nested("order", func(v *Validator) { validateOrder(v, &invoice.Order, exists) })
+		v.Nested("totalPrice", func(v *Validator) { validatePrice(v, &invoice.TotalPrice) })
+	})
 }
 
 func ValidateAddress(address *Address) error {
-	if address == nil {
-		return &ValidationError{"Address: is nil", nil}
-	}
-
-	if address.ID.Valid && address.ID.Int64 < 0 {
-		return &ValidationError{"Address: invalid ID", nil}
-	}
+	return validate("address", address, validateAddress)
+}
 
-	if address.City.Valid {
-		address.City.String = strings.TrimSpace(address.City.String)
-		if address.City.String == "" || len(address.City.String) > 255 {
-			return &ValidationError{"Address: city cannot be empty", nil}
-		}
-	}
+func ValidateComment(comment *Comment, exists bool) error {
+	return validate("comment", comment, func(v *Validator, comment *Comment) {
+		validateID(v, comment.ID, exists)
+		v.Nested("user", func(v *Validator) { validateUser(v, &comment.User, true) })
+		NullField[int64](v, "productId", comment.ProductID, Required)
+		NullField(v, "comment", comment.Comment, Optional, NotBlank(), Length(1, maxCommentLength))
+	})
+}
 
-	if address.Country.Valid {
-		address.Country.String = strings.TrimSpace(address.Country.String)
-		if address.Country.String == "" || len(address.Country.String) > 255 {
-			return &ValidationError{"Address: country cannot be empty", nil}
+func ValidateRating(rating *Rating) error {
+	return validate("rating", rating, func(v *Validator, rating *Rating) {
+		NullField[int64](v, "userId", rating.UserID, Required)
+		NullField[int64](v, "productId", rating.ProductID, Required)
+		NullField(v, "rating", rating.Rating, Required, Range[int64](0, maxRating))
+	})
+}
+
+func ValidateRegistration(registration *Registration) error {
+	return validate("registration", registration, func(v *Validator, registration *Registration) {
+		NullField(v, "email", registration.Email, Required, Length(1, maxEmailLength), validEmail())
+		NullField(v, "password", registration.Password, Required, validPassword())
+		NullField(v, "firstName", registration.FirstName, Required, NotBlank(), Length(1, maxUsernameLength))
+		NullField(v, "lastName", registration.LastName, Required, NotBlank(), Length(1, maxUsernameLength))
+	})
+}
+
+func ValidatePassword(password string) error {
+	v := NewValidator()
+	Field(v, "password", password, validPassword())
+	return v.Err()
+}
+
+// ValidateAPIToken checks a token that is created at now.
+func ValidateAPIToken(token *APIToken, now time.Time) error {
+	return validate("apiToken", token, func(v *Validator, token *APIToken) {
+		validateID(v, token.ID, false)
+		NullField(v, "name", token.Name, Required, NotBlank(), Length(1, maxAPITokenName))
+		if len(token.Scopes) == 0 {
+			v.AddError("scopes", "is required")
 		}
-	}
-
-	if address.Address.Valid {
-		address.Address.String = strings.TrimSpace(address.Address.String)
-		if address.Address.String == "" || len(address.Address.String) > 255 {
-			return &ValidationError{"Address: address cannot be empty", nil}
+		for i, scope := range token.Scopes {
+			v.Index("scopes", i, func(v *Validator) { Field(v, "", scope, OneOf(ReadScope, WriteScope)) })
 		}
-	}
-
-	if address.PostalCode.Valid {
-		address.PostalCode.String = strings.TrimSpace(address.PostalCode.String)
-		if address.PostalCode.String == "" || len(address.PostalCode.String) > 10 {
-			return &ValidationError{"Address: postal code cannot be empty", nil}
+		if !token.ExpiresAt.After(now) || token.ExpiresAt.After(now.Add(MaxAPITokenLifetime)) {
+			v.AddError("expiresAt", "should be within a year")
 		}
-	}
-
-	return nil
+	})
 }
 
-func ValidateComment(comment *Comment, exists bool) error {
-	if comment == nil {
-		return &ValidationError{"Comment: is nil", nil}
-	}
-
-	if (exists && !comment.ID.Valid) || (!exists && comment.ID.Valid) {
-		return &ValidationError{"Comment: invalid ID", nil}
+// validate runs fn against obj, reporting a nil obj as an error of its own.
+func validate[T any](name string, obj *T, fn func(*Validator, *T)) error {
+	v := NewValidator()
+	if obj == nil {
+		v.AddError("", name+" is nil")
+	} else {
+		fn(v, obj)
 	}
 
-	if err := ValidateUser(&comment.User, true); err != nil {
-		return &ValidationError{"Comment: invalid user", err}
-	}
-
-	if !comment.ProductID.Valid {
-		return &ValidationError{"Comment: invalid product ID", nil}
-	}
+	return v.Err()
+}
 
-	if comment.Comment.Valid {
-		comment.Comment.String = strings.TrimSpace(comment.Comment.String)
-		if comment.Comment.String == "" || len(comment.Comment.String) >= maxCommentLength {
-			return &ValidationError{"Comment: invalid comment", nil}
-		}
+func validateID(v *Validator, id NullInt64JSON, exists bool) {
+	if exists {
+		NullField(v, "id", id, Required, Positive[int64]())
+	} else {
+		NullField[int64](v, "id", id, Forbidden)
 	}
-
-	return nil
 }
 
-func ValidateRating(rating *Rating) error {
-	if rating == nil {
-		return &ValidationError{"Rating: is nil", nil}
-	}
+func validatePrice(v *Validator, price *Price) {
+	Field(v, "units", price.Units, Positive[int64]())
+	Field(v, "currency", price.Currency, InRange(BGN, InvalidCurrency))
+}
 
-	if !rating.UserID.Valid {
-		return &ValidationError{"Rating: invalid user ID", nil}
-	}
+func validateUser(v *Validator, user *User, exists bool) {
+	validateID(v, user.ID, exists)
+	NullField(v, "firstName", user.FirstName, Optional, NotBlank(), Length(1, maxUsernameLength))
+	NullField(v, "lastName", user.LastName, Optional, NotBlank(), Length(1, maxUsernameLength))
+	NullField(v, "name", user.Name, Optional, NotBlank(), Length(1, maxUsernameLength))
+	NullField(v, "pictureUrl", user.PictureURL, Optional, ValidURL())
+	NullField(v, "email", user.Email, Optional, Length(0, maxEmailLength), validEmail())
+	v.Nested("address", func(v *Validator) { validateAddress(v, &user.Address) })
+}
 
-	if !rating.ProductID.Valid {
-		return &ValidationError{"Rating: invalid product ID", nil}
-	}
+func validateOrder(v *Validator, order *Order, exists bool) {
+	validateID(v, order.ID, exists)
+	NullField[int64](v, "userId", order.UserID, Required)
+	Field(v, "status", order.Status, InRange(InCart, InvalidOrderStatus))
+	v.Nested("address", func(v *Validator) { validateAddress(v, &order.Address) })
+}
 
-	if rating.Rating.Int64 < 0 || rating.Rating.Int64 > 5 {
-		return &ValidationError{"Rating: invalid rating value", nil}
-	}
+func validateAddress(v *Validator, address *Address) {
+	NullField(v, "id", address.ID, Optional, Range[int64](0, 1<<63-1))
+	NullField(v, "city", address.City, Optional, NotBlank(), Length(1, maxAddressLength))
+	NullField(v, "country", address.Country, Optional, NotBlank(), Length(1, maxAddressLength))
+	NullField(v, "address", address.Address, Optional, NotBlank(), Length(1, maxAddressLength))
+	NullField(v, "postalCode", address.PostalCode, Optional, NotBlank(), Length(1, maxPostalCodeLength))
+}
 
-	return nil
+func validPassword() Rule[string] {
+	return Length(minPasswordLength, maxPasswordLength)
 }
 
-func validateBitSet(bitset, mask int, name string) error {
-	if bitset <= 0 || bitset&mask != bitset {
-		return &ValidationError{
-			Message: "Invalid " + name + " bitset",
+func validEmail() Rule[string] {
+	match := Match(emailRegex, "should be a valid email address")
+	return func(email string) string {
+		if email == "" {
+			return ""
 		}
+		return match(email)
 	}
-	return nil
-}
-
-func isValidEmail(email string) bool {
-	emailRegex := `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
-	return email == "" || regexp.MustCompile(emailRegex).MatchString(email)
 }
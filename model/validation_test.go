@@ -0,0 +1,81 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateProduct_CollectsAllErrors(t *testing.T) {
+	product := &Product{
+		Name:     NullStringJSON{String: "   ", Valid: true},
+		Category: ProductCategoryMask + 1,
+		Price:    NewPrice(0, InvalidCurrency),
+	}
+
+	err := ValidateProduct(product, false)
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+
+	fields := map[string]bool{}
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+
+	for _, field := range []string{"name", "quantity", "category", "price.units", "price.currency"} {
+		if !fields[field] {
+			t.Errorf("expected an error for %q, got %v", field, err)
+		}
+	}
+}
+
+func TestValidateUser_DoesNotModifyInput(t *testing.T) {
+	user := &User{
+		ID:      NullInt64JSON{Int64: 1, Valid: true},
+		Name:    NullStringJSON{String: " John ", Valid: true},
+		Address: Address{City: NullStringJSON{String: " Sofia ", Valid: true}},
+	}
+
+	if err := ValidateUser(user, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if user.Name.String != " John " || user.Address.City.String != " Sofia " {
+		t.Errorf("validation modified the user: %+v", user)
+	}
+
+	NormalizeUser(user)
+	if user.Name.String != "John" || user.Address.City.String != "Sofia" {
+		t.Errorf("normalization did not trim the user: %+v", user)
+	}
+}
+
+func TestValidateComment_NestedFieldPath(t *testing.T) {
+	comment := &Comment{
+		ProductID: NullInt64JSON{Int64: 1, Valid: true},
+		Comment:   NullStringJSON{String: "nice", Valid: true},
+	}
+
+	err := ValidateComment(comment, false)
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "user.id" {
+		t.Errorf("expected a single user.id error, got %v", err)
+	}
+}
+
+func TestValidateRegistration_RequiresEverything(t *testing.T) {
+	registration := &Registration{
+		Email:    NullStringJSON{String: "not an email", Valid: true},
+		Password: NullStringJSON{String: "short", Valid: true},
+	}
+
+	err := ValidateRegistration(registration)
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 4 {
+		t.Fatalf("expected errors for all four fields, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the buckets of a single replica.
+type MemoryStore struct {
+	mu       sync.Mutex
+	arrivals map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{arrivals: map[string]time.Time{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, now, limit time.Time, interval time.Duration) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	arrival := s.arrivals[key]
+	next := arrival
+	if next.Before(now) {
+		next = now
+	}
+	next = next.Add(interval)
+
+	if next.After(limit) {
+		return arrival, false, nil
+	}
+	s.arrivals[key] = next
+	return next, true, nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, arrival := range s.arrivals {
+		if !arrival.After(now) {
+			delete(s.arrivals, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Package ratelimit limits the requests of clients with token buckets, which
+// are kept in memory or in a database shared by the replicas.
+//
+// The buckets are implemented with the generic cell rate algorithm: a bucket
+// is the time at which it is full again, its theoretical arrival time, which
+// moves one interval forward per request. A request is allowed if the arrival
+// time then stays within one period from now.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vladoiliev02/online-store/logging"
+)
+
+// Limit allows Requests per Period, all at once at most.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// PerMinute allows n requests per minute. Zero disables the limit.
+func PerMinute(n int) Limit {
+	return Limit{Requests: n, Period: time.Minute}
+}
+
+// Enabled tells if the limit allows fewer than infinitely many requests.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// interval is the time it takes to add a token to the bucket.
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Store keeps the arrival times of the buckets.
+type Store interface {
+	// Take moves the arrival time of the bucket key one interval after
+	// itself or now, whichever is later, unless that is after limit. It
+	// returns the arrival time of the bucket and whether it was moved.
+	Take(ctx context.Context, key string, now, limit time.Time, interval time.Duration) (time.Time, bool, error)
+	// DeleteExpired deletes the buckets that are full at now.
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// Result is the state of a bucket after a request.
+type Result struct {
+	Allowed bool
+	Limit   Limit
+	// Remaining is how many more requests are allowed right away.
+	Remaining int
+	// Reset is the time until the bucket is full.
+	Reset time.Duration
+	// RetryAfter is the time until a request is allowed again, zero if
+	// Allowed.
+	RetryAfter time.Duration
+}
+
+// SetHeaders describes the result with the RateLimit headers of the IETF
+// draft, and Retry-After if the request is not allowed.
+func (r *Result) SetHeaders(header http.Header) {
+	header.Set("RateLimit-Limit", strconv.Itoa(r.Limit.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(seconds(r.Reset)))
+	header.Set("RateLimit-Policy", strconv.Itoa(r.Limit.Requests)+";w="+strconv.Itoa(seconds(r.Limit.Period)))
+	if !r.Allowed {
+		header.Set("Retry-After", strconv.Itoa(max(seconds(r.RetryAfter), 1)))
+	}
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow takes a token from the bucket key, which holds limit.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	now := l.now().UTC()
+	interval := limit.interval()
+	end := now.Add(limit.Period)
+
+	arrival, allowed, err := l.store.Take(ctx, key, now, end, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Allowed: allowed, Limit: limit, Reset: max(arrival.Sub(now), 0)}
+	if allowed {
+		result.Remaining = int(end.Sub(arrival) / interval)
+	} else {
+		result.RetryAfter = arrival.Add(interval).Sub(end)
+	}
+	return result, nil
+}
+
+// Middleware limits the requests of every key of group, which key returns.
+// Requests over the limit are answered by limited, after the headers are set.
+// A failing store lets the requests through.
+func (l *Limiter) Middleware(group string, limit Limit, key func(*http.Request) string, limited http.HandlerFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil || !limit.Enabled() {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := l.Allow(r.Context(), group+":"+key(r), limit)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("cannot check rate limit", "group", group, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result.SetHeaders(w.Header())
+			if !result.Allowed {
+				limited(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DeleteExpiredEvery deletes the full buckets every interval until done is
+// closed.
+func (l *Limiter) DeleteExpiredEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := l.store.DeleteExpired(context.Background(), l.now().UTC()); err != nil {
+			slog.Warn("Cannot delete expired rate limits", "error", err)
+		}
+	}
+}
+
+// ClientIP returns the IP address of the client, which middleware.RealIP
+// takes from the proxy headers.
+func ClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(now *time.Time) *Limiter {
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+	limit := PerMinute(3)
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := limiter.Allow(context.Background(), "client", limit)
+		if err != nil || !result.Allowed || result.Remaining != remaining {
+			t.Fatalf("expected %d remaining requests, got %+v, %v", remaining, result, err)
+		}
+	}
+
+	result, _ := limiter.Allow(context.Background(), "client", limit)
+	if result.Allowed || result.RetryAfter != 20*time.Second || result.Reset != time.Minute {
+		t.Errorf("expected to wait for a token, got %+v", result)
+	}
+
+	if result, _ := limiter.Allow(context.Background(), "another client", limit); !result.Allowed {
+		t.Error("expected clients to have their own buckets")
+	}
+
+	now = now.Add(20 * time.Second)
+	if result, _ := limiter.Allow(context.Background(), "client", limit); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected one token after 20s, got %+v", result)
+	}
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(&now)
+
+	handler := limiter.Middleware("test", PerMinute(1), ClientIP, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request()
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Policy") != "1;w=60" {
+		t.Errorf("expected the first request with the RateLimit headers, got %d %v", w.Code, w.Header())
+	}
+
+	w = request()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" || w.Header().Get("RateLimit-Reset") != "60" {
+		t.Errorf("expected the second request to be limited, got %d %v", w.Code, w.Header())
+	}
+
+	handler = limiter.Middleware("test", PerMinute(0), ClientIP, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if w := request(); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected a zero limit to be disabled, got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"strings"
+
+	"github.com/vladoiliev02/online-store/dao"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type serviceNameKey struct{}
+
+type serviceAuthenticator struct {
+	tokens map[string]string
+}
+
+// ServiceName returns the name of the authenticated service calling the RPC.
+func ServiceName(ctx context.Context) string {
+	name, _ := ctx.Value(serviceNameKey{}).(string)
+	return name
+}
+
+func (a *serviceAuthenticator) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *serviceAuthenticator) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+func (a *serviceAuthenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token, found := strings.CutPrefix(value, "Bearer ")
+		if !found {
+			continue
+		}
+
+		// Compare against every token, so the time taken does not reveal a match.
+		var service string
+		for known, name := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				service = name
+			}
+		}
+
+		if service != "" {
+			ctx = dao.WithAuditActor(ctx, dao.AuditActor{
+				Service:   service,
+				RequestID: firstValue(md, "x-request-id"),
+				IPAddress: peerIP(ctx),
+			})
+			return context.WithValue(ctx, serviceNameKey{}, service), nil
+		}
+	}
+
+	return nil, status.Error(codes.Unauthenticated, "a valid service token is required")
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// peerIP returns the IP address of the client of an RPC.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
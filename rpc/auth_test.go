@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServiceAuthenticator(t *testing.T) {
+	auth := &serviceAuthenticator{tokens: map[string]string{"secret": "warehouse"}}
+
+	tests := []struct {
+		name    string
+		header  string
+		service string
+	}{
+		{"valid token", "Bearer secret", "warehouse"},
+		{"invalid token", "Bearer other", ""},
+		{"missing scheme", "secret", ""},
+		{"missing header", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.header != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.header))
+			}
+
+			ctx, err := auth.authenticate(ctx)
+			if tt.service == "" {
+				if status.Code(err) != codes.Unauthenticated {
+					t.Errorf("expected Unauthenticated, got %v", err)
+				}
+				return
+			}
+
+			if err != nil || ServiceName(ctx) != tt.service {
+				t.Errorf("expected service %q, got %q, %v", tt.service, ServiceName(ctx), err)
+			}
+		})
+	}
+}
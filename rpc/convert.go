@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"github.com/vladoiliev02/online-store/model"
+	"github.com/vladoiliev02/online-store/rpc/storepb"
+)
+
+func toPrice(price model.Price) *storepb.Price {
+	return &storepb.Price{
+		Units:    price.Units,
+		Currency: int32(price.Currency),
+	}
+}
+
+func toAddress(address model.Address) *storepb.Address {
+	if !address.ID.Valid {
+		return nil
+	}
+
+	return &storepb.Address{
+		Id:         address.ID.Int64,
+		City:       address.City.String,
+		Country:    address.Country.String,
+		Address:    address.Address.String,
+		PostalCode: address.PostalCode.String,
+	}
+}
+
+func toProduct(product *model.Product) *storepb.Product {
+	return &storepb.Product{
+		Id:           product.ID.Int64,
+		Name:         product.Name.String,
+		Description:  product.Description.String,
+		Price:        toPrice(product.Price),
+		Quantity:     product.Quantity.Int64,
+		Category:     int32(product.Category),
+		Available:    product.Available.Bool,
+		Rating:       product.Rating.Float64,
+		RatingsCount: product.RatingsCount.Int64,
+		CreatedAt:    product.CreatedAt.String,
+		SellerId:     product.UserID.Int64,
+	}
+}
+
+func toOrder(order *model.Order) *storepb.Order {
+	items := make([]*storepb.Item, 0, len(order.Products))
+	for _, item := range order.Products {
+		items = append(items, &storepb.Item{
+			Id:        item.ID.Int64,
+			ProductId: item.ProductID.Int64,
+			Quantity:  item.Quantity.Int64,
+			Price:     toPrice(item.Price),
+		})
+	}
+
+	return &storepb.Order{
+		Id:           order.ID.Int64,
+		UserId:       order.UserID.Int64,
+		Status:       storepb.OrderStatus(order.Status),
+		Address:      toAddress(order.Address),
+		Items:        items,
+		CreatedAt:    order.CreatedAt.String,
+		LatestUpdate: order.LatestUpdate.String,
+	}
+}
+
+func toInvoice(invoice *model.Invoice) *storepb.Invoice {
+	return &storepb.Invoice{
+		Id:         invoice.ID.Int64,
+		UserId:     invoice.UserID.Int64,
+		Order:      toOrder(&invoice.Order),
+		TotalPrice: toPrice(invoice.TotalPrice),
+		CreatedAt:  invoice.CreatedAt.String,
+	}
+}
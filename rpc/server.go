@@ -0,0 +1,245 @@
+//go:generate protoc --proto_path=../proto --go_out=storepb --go_opt=paths=source_relative --go-grpc_out=storepb --go-grpc_opt=paths=source_relative store.proto
+
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/vladoiliev02/online-store/dao"
+	"github.com/vladoiliev02/online-store/model"
+	"github.com/vladoiliev02/online-store/rpc/storepb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	listPageSize = 80
+)
+
+type storeServer struct {
+	storepb.UnimplementedStoreServer
+	productDAO *dao.ProductDAO
+	orderDAO   *dao.OrderDAO
+	itemDAO    *dao.ItemDAO
+	invoiceDAO *dao.InvoiceDAO
+}
+
+// NewServer creates a gRPC server exposing the Store service. Only callers
+// presenting one of serviceTokens are allowed; the map key is the token and
+// the value is the name of the service it belongs to.
+func NewServer(serviceTokens map[string]string) *grpc.Server {
+	auth := &serviceAuthenticator{tokens: serviceTokens}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.unaryInterceptor),
+		grpc.StreamInterceptor(auth.streamInterceptor),
+	)
+
+	storepb.RegisterStoreServer(server, &storeServer{
+		productDAO: dao.NewProductDAO(),
+		orderDAO:   dao.NewOrderDAO(),
+		itemDAO:    dao.NewItemDAO(),
+		invoiceDAO: dao.NewInvoiceDAO(),
+	})
+
+	return server
+}
+
+func (s *storeServer) GetProduct(ctx context.Context, req *storepb.GetProductRequest) (*storepb.Product, error) {
+	product, err := s.productDAO.GetByID(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "product not found")
+	}
+
+	return toProduct(product), nil
+}
+
+func (s *storeServer) ListProducts(req *storepb.ListProductsRequest, stream storepb.Store_ListProductsServer) error {
+	category := model.ProductCategory(req.GetCategories())
+	if category == 0 {
+		category = model.ProductCategoryMask
+	}
+
+	if model.BitSet[model.ProductCategory](model.ProductCategoryMask)(category) != "" {
+		return status.Error(codes.InvalidArgument, "invalid categories")
+	}
+
+	ctx := stream.Context()
+
+	for page, sent := 1, int64(0); ; page++ {
+		var products []*model.Product
+		var count int64
+		var err error
+		if req.GetSellerId() != 0 {
+			products, count, err = s.productDAO.GetByUserID(ctx, req.GetSellerId(), page, listPageSize)
+		} else {
+			products, count, err = s.productDAO.GetAll(ctx, page, listPageSize, category)
+		}
+		if err != nil {
+			return toStatus(err, "cannot list products")
+		}
+
+		for _, product := range products {
+			if err := stream.Send(toProduct(product)); err != nil {
+				return err
+			}
+		}
+
+		sent += int64(len(products))
+		if len(products) == 0 || sent >= count {
+			return nil
+		}
+	}
+}
+
+func (s *storeServer) UpdateStock(ctx context.Context, req *storepb.UpdateStockRequest) (*storepb.Product, error) {
+	var product *model.Product
+	var err error
+
+	switch change := req.GetChange().(type) {
+	case *storepb.UpdateStockRequest_Delta:
+		product, err = s.productDAO.AdjustQuantity(ctx, req.GetProductId(), change.Delta)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.FailedPrecondition, "product not found or insufficient stock")
+		}
+	case *storepb.UpdateStockRequest_Quantity:
+		if change.Quantity < 0 {
+			return nil, status.Error(codes.InvalidArgument, "quantity cannot be negative")
+		}
+
+		product, err = s.productDAO.GetByID(ctx, req.GetProductId())
+		if err != nil {
+			return nil, toStatus(err, "product not found")
+		}
+
+		product.Quantity.Scan(change.Quantity)
+		product, err = s.productDAO.Update(ctx, product)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "either quantity or delta is required")
+	}
+
+	if err != nil {
+		return nil, toStatus(err, "cannot update stock")
+	}
+
+	return toProduct(product), nil
+}
+
+func (s *storeServer) GetOrder(ctx context.Context, req *storepb.GetOrderRequest) (*storepb.Order, error) {
+	order, err := s.orderDAO.GetByIDWithItems(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err, "order not found")
+	}
+
+	return toOrder(order), nil
+}
+
+func (s *storeServer) ListOrders(req *storepb.ListOrdersRequest, stream storepb.Store_ListOrdersServer) error {
+	orderStatus := model.OrderStatus(req.GetStatus())
+	if orderStatus != 0 && !model.IsValidOrderStatus(orderStatus) {
+		return status.Error(codes.InvalidArgument, "invalid status")
+	}
+
+	ctx := stream.Context()
+
+	var orders []*model.Order
+	var err error
+	switch {
+	case req.GetUserId() != 0:
+		orders, err = s.orderDAO.GetByUserID(ctx, req.GetUserId())
+	case orderStatus != 0:
+		orders, err = s.orderDAO.GetByStatus(ctx, orderStatus)
+	default:
+		return status.Error(codes.InvalidArgument, "either status or user_id is required")
+	}
+	if err != nil {
+		return toStatus(err, "cannot list orders")
+	}
+
+	orderIDs := make([]int64, 0, len(orders))
+	for _, order := range orders {
+		orderIDs = append(orderIDs, order.ID.Int64)
+	}
+
+	items, err := s.itemDAO.GetByOrderIDs(ctx, orderIDs)
+	if err != nil {
+		return toStatus(err, "cannot load order items")
+	}
+
+	itemsByOrder := map[int64][]*model.Item{}
+	for _, item := range items {
+		itemsByOrder[item.OrderID.Int64] = append(itemsByOrder[item.OrderID.Int64], item)
+	}
+
+	for _, order := range orders {
+		if orderStatus != 0 && order.Status != orderStatus {
+			continue
+		}
+
+		order.Products = itemsByOrder[order.ID.Int64]
+		if err := stream.Send(toOrder(order)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *storeServer) UpdateOrderStatus(ctx context.Context, req *storepb.UpdateOrderStatusRequest) (*storepb.Order, error) {
+	orderStatus := model.OrderStatus(req.GetStatus())
+	if orderStatus != model.Completed && orderStatus != model.Canceled {
+		return nil, status.Error(codes.InvalidArgument, "orders can only be completed or canceled")
+	}
+
+	order, err := s.orderDAO.GetByID(ctx, req.GetOrderId())
+	if err != nil {
+		return nil, toStatus(err, "order not found")
+	}
+
+	if order.Status == model.InCart {
+		return nil, status.Error(codes.FailedPrecondition, "order is still a cart")
+	}
+
+	order.Status = orderStatus
+	order, err = s.orderDAO.Update(ctx, order)
+	switch {
+	case errors.Is(err, dao.ErrInvalidStatusTransition):
+		return nil, status.Error(codes.FailedPrecondition, "invalid status transition")
+	case errors.Is(err, dao.ErrVersionMismatch):
+		return nil, status.Error(codes.Aborted, "order was modified concurrently")
+	case err != nil:
+		return nil, toStatus(err, "cannot update order")
+	}
+
+	return s.GetOrder(ctx, &storepb.GetOrderRequest{Id: order.ID.Int64})
+}
+
+func (s *storeServer) GetInvoice(ctx context.Context, req *storepb.GetInvoiceRequest) (*storepb.Invoice, error) {
+	invoice, err := s.invoiceDAO.GetByOrderID(ctx, req.GetOrderId())
+	if err != nil {
+		return nil, toStatus(err, "invoice not found")
+	}
+
+	order, err := s.orderDAO.LoadItems(ctx, &invoice.Order)
+	if err != nil {
+		return nil, toStatus(err, "cannot load order items")
+	}
+	invoice.Order = *order
+
+	return toInvoice(invoice), nil
+}
+
+func toStatus(err error, message string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Error(codes.NotFound, message)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return status.FromContextError(err).Err()
+	}
+
+	return status.Error(codes.Internal, message)
+}
@@ -0,0 +1,26 @@
+BEGIN;
+
+-- Deleted users are kept anonymised, as the invoices of their orders have to
+-- stay intact.
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX users_deletion_scheduled_at_index ON users(deletion_scheduled_at);
+
+-- The audit log stays append-only, except that the IP addresses of deleted
+-- users are erased.
+CREATE OR REPLACE FUNCTION reject_audit_log_change() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        IF NEW.ip_address = ''
+            AND ROW(NEW.id, NEW.actor_id, NEW.service, NEW.action, NEW.entity_type, NEW.entity_id, NEW.changes, NEW.request_id, NEW.created_at)
+                IS NOT DISTINCT FROM ROW(OLD.id, OLD.actor_id, OLD.service, OLD.action, OLD.entity_type, OLD.entity_id, OLD.changes, OLD.request_id, OLD.created_at) THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+
+    RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN;
+
+-- Emails identify administrators and link identities, so a user can only have
+-- an email nobody else has. Of the users sharing one, the oldest keeps it.
+UPDATE users
+SET email = ''
+WHERE email != '' AND EXISTS (
+    SELECT 1 FROM users older
+    WHERE LOWER(older.email) = LOWER(users.email) AND older.id < users.id
+);
+
+CREATE UNIQUE INDEX users_email_unique_index ON users(LOWER(email)) WHERE email != '';
+
+COMMIT;
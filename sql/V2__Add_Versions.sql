@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE users ADD COLUMN version BIGINT DEFAULT 1 NOT NULL;
+ALTER TABLE products ADD COLUMN version BIGINT DEFAULT 1 NOT NULL;
+ALTER TABLE orders ADD COLUMN version BIGINT DEFAULT 1 NOT NULL;
+
+COMMIT;
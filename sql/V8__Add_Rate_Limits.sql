@@ -0,0 +1,12 @@
+BEGIN;
+
+-- Rate limits are not worth the write-ahead log, losing them on a crash only
+-- resets the limits.
+CREATE UNLOGGED TABLE rate_limits (
+    bucket VARCHAR(255) PRIMARY KEY,
+    arrival_at BIGINT NOT NULL
+);
+
+CREATE INDEX rate_limits_arrival_at_index ON rate_limits(arrival_at);
+
+COMMIT;
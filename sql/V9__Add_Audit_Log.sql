@@ -0,0 +1,36 @@
+BEGIN;
+
+-- The audit log outlives the users and entities it mentions, so it has no
+-- foreign keys.
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id BIGINT,
+    service VARCHAR(255) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    entity_type VARCHAR(64) NOT NULL,
+    entity_id BIGINT NOT NULL,
+    changes TEXT NOT NULL,
+    request_id VARCHAR(255) NOT NULL,
+    ip_address VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_entity_index ON audit_log(entity_type, entity_id);
+CREATE INDEX audit_log_actor_id_index ON audit_log(actor_id);
+CREATE INDEX audit_log_created_at_index ON audit_log(created_at);
+
+CREATE FUNCTION reject_audit_log_change() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION reject_audit_log_change();
+
+CREATE TRIGGER audit_log_no_truncate
+    BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_log_change();
+
+COMMIT;
@@ -60,8 +60,40 @@ function initNavigation() {
         });
 }
 
+const csrfSafeMethods = ['GET', 'HEAD', 'OPTIONS', 'TRACE'];
+let csrfToken = null;
+
+// getCsrfToken fetches the CSRF token of the session once. It has to be sent
+// with every request that changes state.
+function getCsrfToken() {
+    if (!csrfToken) {
+        csrfToken = fetch('/account/csrf-token')
+            .then(response => response.json())
+            .then(body => body.token)
+            .catch(error => {
+                csrfToken = null;
+                throw error;
+            });
+    }
+    return csrfToken;
+}
+
+function withCsrfToken(init) {
+    const method = ((init && init.method) || 'GET').toUpperCase();
+    if (csrfSafeMethods.includes(method)) {
+        return Promise.resolve(init);
+    }
+
+    return getCsrfToken().then(token => {
+        const headers = new Headers(init.headers || {});
+        headers.set('X-CSRF-Token', token);
+        return { ...init, headers };
+    });
+}
+
 function fetchWithStatusCheck(input, init = null, displayError = true) {
-    let promise = fetch(input, init)
+    let promise = withCsrfToken(init)
+        .then(init => fetch(input, init))
         .then(response => {
             if (!response.ok) {
                 return response.json().then(error => {
@@ -126,8 +126,8 @@ window.onload = function () {
                     submitRatingButton.addEventListener('click', () => {
                         const rating = Number(ratingInput.value);
 
-                        fetchWithStatusCheck(`/api/v1/products/${productId}`, {
-                            method: 'PATCH',
+                        fetchWithStatusCheck(`/api/v1/products/${productId}/rating`, {
+                            method: 'PUT',
                             headers: {
                                 'Content-Type': 'application/json',
                             },
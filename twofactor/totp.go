@@ -0,0 +1,133 @@
+// Package twofactor implements time-based one-time passwords (TOTP, RFC 6238)
+// as used by authenticator apps, and recovery codes replacing them when the
+// device of a user is lost.
+package twofactor
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is how long a code is valid, Digits its length. They are the
+	// defaults of authenticator apps, which ignore other values.
+	Period = 30 * time.Second
+	Digits = 6
+
+	secretLength = 20
+	// skew is how many periods a code may be early or late, to allow for
+	// clock drift and slow typing.
+	skew = 1
+
+	recoveryCodeLength = 10
+	// RecoveryCodeCount is how many recovery codes a user gets.
+	RecoveryCodeCount = 10
+)
+
+var (
+	base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	// recoveryAlphabet leaves out characters that are easily confused.
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// GenerateSecret returns a random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// ProvisioningURI returns the otpauth URI that authenticator apps scan as a QR
+// code to add an account.
+func ProvisioningURI(issuer, account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period.Seconds()))},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Validate reports whether code is valid for the secret at time t, and the time
+// step it is valid in. Codes of steps up to lastStep are rejected, so that a
+// code cannot be used twice.
+func Validate(secret, code string, t time.Time, lastStep int64) (step int64, ok bool) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	current := t.Unix() / int64(Period.Seconds())
+	for step := current - skew; step <= current+skew; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(generate(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generate computes the code of a time step as in RFC 4226.
+func generate(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%modulo)
+}
+
+// GenerateRecoveryCodes returns RecoveryCodeCount random codes, formatted as
+// xxxxx-xxxxx.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	random := make([]byte, recoveryCodeLength)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+
+		var code strings.Builder
+		for j, b := range random {
+			if j == recoveryCodeLength/2 {
+				code.WriteByte('-')
+			}
+			// The bias of the modulo does not matter for 10 characters of a
+			// 31 character alphabet.
+			code.WriteByte(recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+		}
+		codes = append(codes, code.String())
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode hashes a recovery code for storage, ignoring case, spaces
+// and dashes. The codes are random enough to need no salt.
+func HashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,70 @@
+package twofactor
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors of RFC 6238.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestValidate_RFC6238Vectors(t *testing.T) {
+	// The 8 digit codes of the RFC, of which authenticator apps show the last 6.
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	}
+
+	for unix, code := range vectors {
+		step, ok := Validate(rfcSecret, code, time.Unix(unix, 0), 0)
+		if !ok || step != unix/30 {
+			t.Errorf("expected %s to be valid at %d, got step %d, %v", code, unix, step, ok)
+		}
+	}
+}
+
+func TestValidate_RejectsReplayAndDrift(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	if _, ok := Validate(rfcSecret, "287082", now, 1); ok {
+		t.Error("expected a code of a used step to be rejected")
+	}
+	if _, ok := Validate(rfcSecret, "287082", now.Add(2*Period), 0); ok {
+		t.Error("expected a code two periods old to be rejected")
+	}
+	if _, ok := Validate(rfcSecret, "287082", now.Add(Period), 0); !ok {
+		t.Error("expected a code one period old to be accepted")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(ProvisioningURI("Online Store", "seller@example.com", "SECRET"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Online Store:seller@example.com" {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if uri.Query().Get("secret") != "SECRET" || uri.Query().Get("issuer") != "Online Store" {
+		t.Errorf("unexpected parameters %s", uri.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(codes[0]) != recoveryCodeLength+1 {
+		t.Fatalf("unexpected codes %v", codes)
+	}
+
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+codes[0][:5]+codes[0][6:]+" ") {
+		t.Error("expected the hash to ignore dashes and spaces")
+	}
+}